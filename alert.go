@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// 告警规则类型
+const (
+	// alertAbove 价格向上穿过 threshold
+	alertAbove = "above"
+	// alertBelow 价格向下穿过 threshold
+	alertBelow = "below"
+	// alertChange window 内涨跌幅的绝对值达到 threshold (%)
+	alertChange = "change"
+	// alertVolume window 内 24 小时成交额 (USD) 的增幅达到 threshold (%)
+	alertVolume = "volume"
+)
+
+// alertRule 一条告警规则, Asset 为 asset_id 或 symbol
+type alertRule struct {
+	ID        int64
+	Name      string
+	Asset     string
+	Kind      string
+	Threshold float64
+	Window    time.Duration
+	Cooldown  time.Duration
+	Currency  string
+	// Webhook 为空时使用 --alertwebhook
+	Webhook string
+	Enabled bool
+}
+
+func (r *alertRule) validate() error {
+	switch r.Kind {
+	case alertAbove, alertBelow:
+	case alertChange, alertVolume:
+		if r.Window <= 0 {
+			return fmt.Errorf("%v rule needs a window", r.Kind)
+		}
+		if r.Threshold <= 0 {
+			return fmt.Errorf("%v rule needs a positive threshold", r.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown alert kind %q", r.Kind)
+	}
+	if r.Asset == "" {
+		return fmt.Errorf("alert rule needs an asset")
+	}
+	if _, ok := indicatorCloses[r.Currency]; !ok {
+		return fmt.Errorf("unknown currency %q", r.Currency)
+	}
+	return nil
+}
+
+func (r *alertRule) matches(id, symbol string) bool {
+	return r.Asset == id || strings.EqualFold(r.Asset, symbol)
+}
+
+// windowed change 和 volume 规则需要保留历史值
+func (r *alertRule) windowed() bool {
+	return r.Kind == alertChange || r.Kind == alertVolume
+}
+
+// alertEvent 触发的告警, 也是 webhook 的请求体.
+// Value 对 above/below 是价格, 对 change/volume 是百分比
+type alertEvent struct {
+	ID        int64   `json:"id,omitempty"`
+	RuleID    int64   `json:"rule_id"`
+	Rule      string  `json:"rule"`
+	Kind      string  `json:"kind"`
+	AssetID   string  `json:"asset_id"`
+	Symbol    string  `json:"symbol"`
+	Currency  string  `json:"currency"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Timestamp int64   `json:"timestamp"`
+	Message   string  `json:"message"`
+	Delivered bool    `json:"delivered"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// AlertStore 告警规则和历史
+type AlertStore interface {
+	InitAlerts(ctx context.Context) error
+	AddAlertRule(ctx context.Context, r *alertRule) (int64, error)
+	// DeleteAlertRule 返回规则是否存在
+	DeleteAlertRule(ctx context.Context, id int64) (bool, error)
+	AlertRules(ctx context.Context) ([]alertRule, error)
+	SaveAlert(ctx context.Context, a *alertEvent) error
+	// AlertHistory 最近 limit 条, 新的在前
+	AlertHistory(ctx context.Context, limit int) ([]alertEvent, error)
+}
+
+type alertSample struct {
+	ts            int64
+	usd, btc, cny float64
+	volume        float64
+}
+
+func (s *alertSample) price(currency string) float64 {
+	switch currency {
+	case "btc":
+		return s.btc
+	case "cny":
+		return s.cny
+	}
+	return s.usd
+}
+
+// alertSeries 一个资产最近一段时间的值, symbol 用于 reload 时判断是否还有规则匹配
+type alertSeries struct {
+	symbol  string
+	samples []alertSample
+}
+
+type alertKey struct {
+	rule  int64
+	asset string
+}
+
+// alertEngine 在 realTimeAggregation 的每次更新上检查规则, 触发的告警交给 deliver 发送
+type alertEngine struct {
+	store   AlertStore
+	webhook string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	log     *slog.Logger
+
+	mu    sync.Mutex
+	rules []alertRule
+	// 有 change 或 volume 规则的资产最近一段时间的值, 覆盖最长的 window
+	samples map[string]*alertSeries
+	keep    int64
+	prev    map[alertKey]float64
+	fired   map[alertKey]int64
+	out     chan *alertEvent
+}
+
+func newAlertEngine(store AlertStore, webhook string) *alertEngine {
+	return &alertEngine{
+		store:   store,
+		webhook: webhook,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retries: 3,
+		backoff: time.Second,
+		log:     component("alert"),
+		samples: make(map[string]*alertSeries),
+		prev:    make(map[alertKey]float64),
+		fired:   make(map[alertKey]int64),
+		out:     make(chan *alertEvent, 100),
+	}
+}
+
+// reload 读取规则, 被删除的规则的状态一并清掉
+func (e *alertEngine) reload(ctx context.Context) error {
+	rules, err := e.store.AlertRules(ctx)
+	if err != nil {
+		return err
+	}
+	var enabled []alertRule
+	ids := make(map[int64]bool)
+	var keep int64
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+		if err := r.validate(); err != nil {
+			e.log.Warn("skipping invalid rule", "rule", r.ID, "err", err)
+			continue
+		}
+		enabled = append(enabled, r)
+		ids[r.ID] = true
+		if w := int64(r.Window / time.Second); w > keep {
+			keep = w
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules, e.keep = enabled, keep
+	for k := range e.prev {
+		if !ids[k.rule] {
+			delete(e.prev, k)
+		}
+	}
+	for k := range e.fired {
+		if !ids[k.rule] {
+			delete(e.fired, k)
+		}
+	}
+	for id, s := range e.samples {
+		if !e.windowed(id, s.symbol) {
+			delete(e.samples, id)
+		}
+	}
+	return nil
+}
+
+// run 定期重新读取规则, 改动不用重启
+func (e *alertEngine) run(ctx context.Context, clk clock, every time.Duration) {
+	ticker := clk.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		if err := e.reload(ctx); err != nil {
+			dbErrors.inc("alert_rules")
+			e.log.Error("reload rules failed", "err", err)
+		}
+	}
+}
+
+// evaluate 不阻塞汇总, 发送队列满时丢弃告警
+func (e *alertEngine) evaluate(x *kPriceCoinMarketCapList) {
+	if e == nil {
+		return
+	}
+	for _, a := range e.check(x) {
+		alertsFired.inc(a.Kind)
+		select {
+		case e.out <- a:
+		default:
+			alertDeliveries.inc("dropped")
+			e.log.Warn("alert queue full, dropping", "rule", a.RuleID, "asset", a.AssetID)
+		}
+	}
+}
+
+func (e *alertEngine) check(x *kPriceCoinMarketCapList) []*alertEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.rules) == 0 {
+		return nil
+	}
+	var events []*alertEvent
+	for i := range x.list {
+		v := &x.list[i]
+		var cur alertSample
+		cur.ts = x.timestamp
+		cur.usd, _ = v.PriceUSDLast.Float64()
+		cur.btc, _ = v.PriceBTCLast.Float64()
+		cur.cny, _ = v.PriceCNYLast.Float64()
+		cur.volume, _ = v.VolumeUSD24H.Float64()
+		s := e.samples[v.Id]
+		var past []alertSample
+		if s != nil {
+			past = s.samples
+		}
+		windowed := false
+		for j := range e.rules {
+			r := &e.rules[j]
+			if !r.matches(v.Id, v.Symbol) {
+				continue
+			}
+			windowed = windowed || r.windowed()
+			if a := e.checkRule(r, v, cur, past); a != nil {
+				events = append(events, a)
+			}
+		}
+		// 只有 above/below 规则的资产不需要历史值
+		if windowed {
+			if s == nil {
+				s = &alertSeries{symbol: v.Symbol}
+				e.samples[v.Id] = s
+			}
+			s.samples = e.record(past, cur)
+		}
+	}
+	return events
+}
+
+// windowed 是否有 change 或 volume 规则匹配这个资产, 持锁调用
+func (e *alertEngine) windowed(id, symbol string) bool {
+	for i := range e.rules {
+		if e.rules[i].windowed() && e.rules[i].matches(id, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// record 追加新值, 只保留一个不晚于 keep 起点的值
+func (e *alertEngine) record(past []alertSample, cur alertSample) []alertSample {
+	if n := len(past); n > 0 && past[n-1].ts >= cur.ts {
+		return past
+	}
+	past = append(past, cur)
+	for len(past) > 1 && past[1].ts <= cur.ts-e.keep {
+		past = past[1:]
+	}
+	return past
+}
+
+func (e *alertEngine) checkRule(r *alertRule, v *kPriceCoinMarketCap, cur alertSample, past []alertSample) *alertEvent {
+	key := alertKey{r.ID, v.Id}
+	price := cur.price(r.Currency)
+	a := &alertEvent{RuleID: r.ID, Rule: r.Name, Kind: r.Kind, AssetID: v.Id, Symbol: v.Symbol,
+		Currency: r.Currency, Threshold: r.Threshold, Timestamp: cur.ts}
+	switch r.Kind {
+	case alertAbove, alertBelow:
+		prev, ok := e.prev[key]
+		e.prev[key] = price
+		if !ok || price == 0 {
+			return nil
+		}
+		if r.Kind == alertAbove && !(prev <= r.Threshold && price > r.Threshold) ||
+			r.Kind == alertBelow && !(prev >= r.Threshold && price < r.Threshold) {
+			return nil
+		}
+		a.Value = price
+		a.Message = fmt.Sprintf("%v crossed %v %v %v: %v", v.Symbol, r.Kind, r.Threshold, strings.ToUpper(r.Currency), price)
+	case alertChange, alertVolume:
+		base, ok := sampleAt(past, cur.ts-int64(r.Window/time.Second))
+		if !ok {
+			return nil
+		}
+		from, to := base.price(r.Currency), price
+		if r.Kind == alertVolume {
+			from, to = base.volume, cur.volume
+		}
+		if from == 0 {
+			return nil
+		}
+		pct := (to - from) / from * 100
+		if r.Kind == alertChange && math.Abs(pct) < r.Threshold || r.Kind == alertVolume && pct < r.Threshold {
+			return nil
+		}
+		a.Value = pct
+		what := "price (" + strings.ToUpper(r.Currency) + ")"
+		if r.Kind == alertVolume {
+			what = "24h volume (USD)"
+		}
+		a.Message = fmt.Sprintf("%v %v changed %+.2f%% in %v: %v -> %v", v.Symbol, what, pct, r.Window, from, to)
+	}
+	if last, ok := e.fired[key]; ok && cur.ts-last < int64(r.Cooldown/time.Second) {
+		return nil
+	}
+	e.fired[key] = cur.ts
+	return a
+}
+
+// sampleAt 返回不晚于 ts 的最后一个值
+func sampleAt(past []alertSample, ts int64) (alertSample, bool) {
+	i := sort.Search(len(past), func(i int) bool { return past[i].ts > ts })
+	if i == 0 {
+		return alertSample{}, false
+	}
+	return past[i-1], true
+}
+
+// deliver 逐个发送告警并写入历史
+func (e *alertEngine) deliver(ctx context.Context) {
+	rules := make(map[int64]string)
+	for {
+		var a *alertEvent
+		select {
+		case <-ctx.Done():
+			return
+		case a = <-e.out:
+		}
+		e.mu.Lock()
+		for _, r := range e.rules {
+			rules[r.ID] = r.Webhook
+		}
+		e.mu.Unlock()
+		url := rules[a.RuleID]
+		if url == "" {
+			url = e.webhook
+		}
+		if url != "" {
+			if err := e.post(ctx, url, a); err != nil {
+				alertDeliveries.inc("failed")
+				a.Error = err.Error()
+				e.log.Error("webhook failed", "rule", a.RuleID, "asset", a.AssetID, "err", err)
+			} else {
+				alertDeliveries.inc("ok")
+				a.Delivered = true
+			}
+		}
+		if err := e.store.SaveAlert(ctx, a); err != nil {
+			dbErrors.inc("save_alert")
+			e.log.Error("save alert failed", "err", err)
+		}
+	}
+}
+
+// post 非 2xx 和网络错误按 backoff 翻倍重试
+func (e *alertEngine) post(ctx context.Context, url string, a *alertEvent) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	wait := e.backoff
+	for attempt := 0; ; attempt++ {
+		err = e.postOnce(ctx, url, body)
+		if err == nil || attempt >= e.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (e *alertEngine) postOnce(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+// openAlertStore 打开存储并建好告警表
+func openAlertStore(c *cli.Context) (AlertStore, func() error, error) {
+	store, err := openStorage(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	as, ok := store.(AlertStore)
+	if !ok {
+		store.Close()
+		return nil, nil, fmt.Errorf("alerts are not supported by %v", c.GlobalString("drivername"))
+	}
+	if err := as.InitAlerts(context.Background()); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return as, store.Close, nil
+}
+
+var alertCommand = cli.Command{
+	Name:  "alerts",
+	Usage: "manage alert rules evaluated by the collector with --alerts",
+	Subcommands: []cli.Command{
+		{
+			Name:  "add",
+			Usage: "add a rule",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "name", Usage: "rule name, included in notifications"},
+				&cli.StringFlag{Name: "asset", Usage: "asset id or symbol"},
+				&cli.StringFlag{Name: "kind", Usage: "above or below (price crosses threshold), change (abs percent change over window) or volume (24h volume increase in percent over window)"},
+				&cli.Float64Flag{Name: "threshold", Usage: "price for above/below, percent for change/volume"},
+				&cli.DurationFlag{Name: "window", Usage: "lookback for change and volume rules"},
+				&cli.DurationFlag{Name: "cooldown", Value: time.Hour, Usage: "minimum time between two alerts of this rule for one asset"},
+				&cli.StringFlag{Name: "currency", Value: "usd", Usage: "usd, btc or cny"},
+				&cli.StringFlag{Name: "webhook", Usage: "notification URL, defaults to the collector's --alertwebhook"},
+				&cli.BoolFlag{Name: "disabled", Usage: "store the rule without evaluating it"},
+			},
+			Action: func(c *cli.Context) error {
+				r := alertRule{
+					Name:      c.String("name"),
+					Asset:     c.String("asset"),
+					Kind:      c.String("kind"),
+					Threshold: c.Float64("threshold"),
+					Window:    c.Duration("window"),
+					Cooldown:  c.Duration("cooldown"),
+					Currency:  strings.ToLower(c.String("currency")),
+					Webhook:   c.String("webhook"),
+					Enabled:   !c.Bool("disabled"),
+				}
+				if err := r.validate(); err != nil {
+					return err
+				}
+				store, closeStore, err := openAlertStore(c)
+				if err != nil {
+					return err
+				}
+				defer closeStore()
+				id, err := store.AddAlertRule(context.Background(), &r)
+				if err != nil {
+					return err
+				}
+				fmt.Println(id)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list rules",
+			Action: func(c *cli.Context) error {
+				store, closeStore, err := openAlertStore(c)
+				if err != nil {
+					return err
+				}
+				defer closeStore()
+				rules, err := store.AlertRules(context.Background())
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tNAME\tASSET\tKIND\tTHRESHOLD\tWINDOW\tCOOLDOWN\tCURRENCY\tWEBHOOK\tENABLED")
+				for _, r := range rules {
+					fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.ID, r.Name, r.Asset, r.Kind, r.Threshold,
+						r.Window, r.Cooldown, r.Currency, r.Webhook, r.Enabled)
+				}
+				return w.Flush()
+			},
+		},
+		{
+			Name:  "delete",
+			Usage: "delete a rule",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{Name: "id", Usage: "rule id"},
+			},
+			Action: func(c *cli.Context) error {
+				store, closeStore, err := openAlertStore(c)
+				if err != nil {
+					return err
+				}
+				defer closeStore()
+				ok, err := store.DeleteAlertRule(context.Background(), c.Int64("id"))
+				if err == nil && !ok {
+					err = fmt.Errorf("no rule %v", c.Int64("id"))
+				}
+				return err
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "show fired alerts, newest first",
+			Flags: []cli.Flag{
+				&cli.IntFlag{Name: "limit", Value: 20},
+			},
+			Action: func(c *cli.Context) error {
+				store, closeStore, err := openAlertStore(c)
+				if err != nil {
+					return err
+				}
+				defer closeStore()
+				events, err := store.AlertHistory(context.Background(), c.Int("limit"))
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "TIME\tRULE\tASSET\tMESSAGE\tDELIVERED\tERROR")
+				for _, a := range events {
+					fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", isoTime(a.Timestamp), a.RuleID, a.AssetID, a.Message, a.Delivered, a.Error)
+				}
+				return w.Flush()
+			},
+		},
+	},
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func alertTick(ts int64, usd, volume float64) *kPriceCoinMarketCapList {
+	x := testTick(ts, usd)
+	x.list[0].VolumeUSD24H.SetFloat64(volume)
+	return x
+}
+
+// alertSink 记录收到的 webhook, fail 为 true 时返回 500
+type alertSink struct {
+	mu     sync.Mutex
+	fail   bool
+	events []alertEvent
+	calls  int
+}
+
+func (s *alertSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var a alertEvent
+	json.NewDecoder(r.Body).Decode(&a)
+	s.events = append(s.events, a)
+}
+
+func TestAlertRules(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	sink, broken := &alertSink{}, &alertSink{fail: true}
+	srv, brokenSrv := httptest.NewServer(sink), httptest.NewServer(broken)
+	defer srv.Close()
+	defer brokenSrv.Close()
+	for _, r := range []alertRule{
+		{Name: "btc 100", Asset: "BTC", Kind: alertAbove, Threshold: 100, Currency: "usd", Enabled: true},
+		{Name: "btc move", Asset: "bitcoin", Kind: alertChange, Threshold: 10, Window: time.Minute, Cooldown: time.Hour, Currency: "usd", Enabled: true},
+		{Name: "btc volume", Asset: "bitcoin", Kind: alertVolume, Threshold: 50, Window: time.Minute, Cooldown: time.Hour, Currency: "usd", Enabled: true, Webhook: brokenSrv.URL},
+		{Name: "off", Asset: "bitcoin", Kind: alertBelow, Threshold: 1000, Currency: "usd"},
+	} {
+		r := r
+		if _, err := store.AddAlertRule(ctx, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := newAlertEngine(store, srv.URL)
+	e.retries, e.backoff = 1, time.Millisecond
+	if err := e.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go e.deliver(ctx)
+
+	base := int64(1514937600)
+	for i, tick := range []struct{ usd, volume float64 }{
+		{90, 1000},
+		{95, 1000},  // 30 秒前没有数据, 不检查涨跌幅
+		{101, 1000}, // 穿过 100, 一分钟涨 12.2%
+		{99, 1600},  // 成交额一分钟涨 60%, 涨跌幅在冷却期内
+		{102, 1600}, // 再次穿过 100
+	} {
+		e.evaluate(alertTick(base+int64(i)*30, tick.usd, tick.volume))
+	}
+
+	var history []alertEvent
+	deadline := time.Now().Add(5 * time.Second)
+	for len(history) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		history, _ = store.AlertHistory(ctx, 10)
+	}
+	// 按触发顺序
+	var fired []alertEvent
+	var got []string
+	for i := len(history) - 1; i >= 0; i-- {
+		fired = append(fired, history[i])
+		got = append(got, history[i].Rule+"@"+isoTime(history[i].Timestamp))
+	}
+	want := []string{"btc 100@2018-01-03T00:01:00Z", "btc move@2018-01-03T00:01:00Z", "btc volume@2018-01-03T00:01:30Z", "btc 100@2018-01-03T00:02:00Z"}
+	if len(got) != len(want) {
+		t.Fatalf("history %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("history %v, want %v", got, want)
+			break
+		}
+	}
+	if a := fired[2]; a.Kind != alertVolume || a.Delivered || a.Error == "" || broken.calls != 2 {
+		t.Errorf("volume alert %+v after %d calls", a, broken.calls)
+	}
+	if a := fired[1]; a.Value < 12.2 || a.Value > 12.3 || !a.Delivered {
+		t.Errorf("change alert %+v", a)
+	}
+
+	// 只为 change 和 volume 规则匹配的资产保留历史值, 规则删除后一并清掉
+	eth := testTick(base+150, 1)
+	eth.list[0].Id, eth.list[0].Symbol = "ethereum", "ETH"
+	e.evaluate(eth)
+	e.mu.Lock()
+	if len(e.samples) != 1 || e.samples["bitcoin"] == nil || len(e.samples["bitcoin"].samples) != 3 {
+		t.Errorf("samples %+v", e.samples)
+	}
+	e.mu.Unlock()
+	for _, r := range e.rules {
+		if r.windowed() {
+			store.DeleteAlertRule(ctx, r.ID)
+		}
+	}
+	if err := e.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.samples) != 0 {
+		t.Errorf("samples after reload %+v", e.samples)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 3 || sink.events[0].Symbol != "BTC" || sink.events[0].Value != 101 {
+		t.Errorf("webhook got %+v", sink.events)
+	}
+}
+
+func TestAlertStoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.InitAlerts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := alertRule{Name: "x", Asset: "ETH", Kind: alertChange, Threshold: 5, Window: time.Hour, Cooldown: time.Minute, Currency: "btc", Enabled: true}
+	id, err := store.AddAlertRule(ctx, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := store.AlertRules(ctx)
+	r.ID = id
+	if err != nil || len(rules) != 1 || rules[0] != r {
+		t.Fatalf("rules %+v, %v", rules, err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.SaveAlert(ctx, &alertEvent{RuleID: id, Rule: "x", Timestamp: int64(i), Delivered: i == 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := store.AlertHistory(ctx, 2)
+	if err != nil || len(history) != 2 || history[0].Timestamp != 2 || !history[0].Delivered {
+		t.Errorf("history %+v, %v", history, err)
+	}
+	if ok, err := store.DeleteAlertRule(ctx, id); !ok || err != nil {
+		t.Errorf("delete: %v %v", ok, err)
+	}
+	if ok, _ := store.DeleteAlertRule(ctx, id); ok {
+		t.Errorf("deleted twice")
+	}
+}
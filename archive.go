@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/urfave/cli"
+)
+
+var errArchiveNotFound = errors.New("archive not found")
+
+// archiveStore 归档文件的存放位置, key 用 / 分隔
+type archiveStore interface {
+	Exists(ctx context.Context, key string) (bool, error)
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	// Get 不存在时返回 errArchiveNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// localArchive 本地目录
+type localArchive struct {
+	dir string
+}
+
+func (l localArchive) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+func (l localArchive) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put 先写临时文件再改名, 中途失败不会留下不完整的归档
+func (l localArchive) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	p := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (l localArchive) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, errArchiveNotFound
+	}
+	return f, err
+}
+
+// openArchive 按 --archive 选择存放位置: 本地目录或 s3://bucket/prefix
+func openArchive(c *cli.Context) (archiveStore, error) {
+	dst := c.GlobalString("archive")
+	if !strings.HasPrefix(dst, "s3://") {
+		return localArchive{dir: dst}, nil
+	}
+	u, err := url.Parse(dst)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(c.GlobalString("s3endpoint"))
+	if err != nil {
+		return nil, fmt.Errorf("s3endpoint: %v", err)
+	}
+	return &s3Archive{
+		client:    &http.Client{Timeout: 10 * time.Minute},
+		endpoint:  endpoint,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    c.GlobalString("s3region"),
+		accessKey: c.GlobalString("s3accesskey"),
+		secretKey: c.GlobalString("s3secretkey"),
+		pathStyle: c.GlobalBool("s3pathstyle"),
+	}, nil
+}
+
+// 压缩格式对应的扩展名
+var archiveExt = map[string]string{"gzip": ".gz", "zstd": ".zst"}
+
+func compressWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// decompressReader 按扩展名解压
+func decompressReader(key string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(key, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(key, ".zst"):
+		d, err := zstd.NewReader(r)
+		return zstdReadCloser{d}, err
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// archiveKey 按天存放, 如 pricecoinmarketcap/2018/01/pricecoinmarketcap-20180103.jsonl.gz
+func archiveKey(name string, day time.Time, ext string) string {
+	return fmt.Sprintf("%v/%v/%v-%v.jsonl%v", name, day.Format("2006/01"), name, day.Format("20060102"), ext)
+}
+
+// archiver 在保留策略删除原始数据之前, 把整天的数据压缩归档
+type archiver struct {
+	dst   archiveStore
+	codec string
+	table string
+	// responses 不为空时同时归档上游原始响应
+	responses *responseLog
+}
+
+func newArchiver(c *cli.Context, table string) (*archiver, error) {
+	dst, err := openArchive(c)
+	if err != nil {
+		return nil, err
+	}
+	codec := c.GlobalString("archivecompression")
+	if _, ok := archiveExt[codec]; !ok {
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+	a := &archiver{dst: dst, codec: codec, table: table}
+	if c.GlobalBool("archiveresponses") {
+		// 当天的响应先写在本地, 过了 0 点再上传
+		if a.responses, err = newResponseLog(filepath.Join(c.GlobalString("spilldir"), "responses")); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// archiveTicks 归档 before 之前的所有整天, before 必须是 UTC 0 点
+func (a *archiver) archiveTicks(ctx context.Context, store TickStore, before int64) error {
+	earliest, ok, err := store.EarliestTimestamp(ctx, a.table)
+	if err != nil || !ok {
+		return err
+	}
+	alog := component("archive", "table", a.table)
+	for d := earliest - earliest%day; d+day <= before; d += day {
+		key := archiveKey(a.table, time.Unix(d, 0).UTC(), archiveExt[a.codec])
+		if ok, err := a.dst.Exists(ctx, key); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := a.put(ctx, key, func(w io.Writer) (int, error) {
+			enc := json.NewEncoder(w)
+			var n int
+			err := store.ScanTicks(ctx, a.table, d, d+day, func(t *rawTicks) error {
+				n++
+				return enc.Encode(t)
+			})
+			return n, err
+		})
+		if err != nil {
+			return fmt.Errorf("archive %v: %v", key, err)
+		}
+		alog.Info("archived", "key", key, "ticks", n)
+	}
+	return nil
+}
+
+// archiveResponses 上传已经结束的响应日志并删除本地文件
+func (a *archiver) archiveResponses(ctx context.Context, now time.Time) error {
+	for _, f := range a.responses.completed(now) {
+		key := "responses/" + archiveKey(f.source, f.day, archiveExt[a.codec])
+		_, err := a.put(ctx, key, func(w io.Writer) (int, error) {
+			in, err := os.Open(f.path)
+			if err != nil {
+				return 0, err
+			}
+			defer in.Close()
+			_, err = io.Copy(w, in)
+			return 1, err
+		})
+		if err != nil {
+			return fmt.Errorf("archive %v: %v", key, err)
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		component("archive").Info("archived", "key", key)
+	}
+	return nil
+}
+
+// put 把 fill 写出的内容压缩到临时文件后上传, fill 没有写任何记录时不上传
+func (a *archiver) put(ctx context.Context, key string, fill func(io.Writer) (int, error)) (int, error) {
+	f, err := os.CreateTemp("", "archive-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	cw, err := compressWriter(a.codec, bw)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fill(cw)
+	if err != nil {
+		return n, err
+	}
+	if err := cw.Close(); err != nil {
+		return n, err
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return n, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return n, err
+	}
+	return n, a.dst.Put(ctx, key, f, size)
+}
+
+// responseRecord 一次上游响应, body 原样保存, 解析失败的内容也能留下
+type responseRecord struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	URL    string    `json:"url"`
+	Status int       `json:"status"`
+	Body   string    `json:"body"`
+}
+
+type responseFile struct {
+	source string
+	day    time.Time
+	path   string
+}
+
+// responseLog 把上游响应按来源和天追加到 dir 下的 jsonl 文件
+type responseLog struct {
+	mu   sync.Mutex
+	dir  string
+	name string
+	f    *os.File
+}
+
+func newResponseLog(dir string) (*responseLog, error) {
+	return &responseLog{dir: dir}, os.MkdirAll(dir, 0o755)
+}
+
+func (l *responseLog) fileName(source string, t time.Time) string {
+	return fmt.Sprintf("%v-%v.jsonl", source, t.UTC().Format("20060102"))
+}
+
+func (l *responseLog) record(r *responseRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if name := l.fileName(r.Source, r.Time); name != l.name {
+		if l.f != nil {
+			l.f.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			l.f, l.name = nil, ""
+			return err
+		}
+		l.f, l.name = f, name
+	}
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+// completed 返回 now 之前各天的文件, 当前打开的旧文件会先关闭
+func (l *responseLog) completed(now time.Time) []responseFile {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	today := now.UTC().Format("20060102")
+	names, _ := filepath.Glob(filepath.Join(l.dir, "*-*.jsonl"))
+	sort.Strings(names)
+	var files []responseFile
+	for _, p := range names {
+		base := strings.TrimSuffix(filepath.Base(p), ".jsonl")
+		i := strings.LastIndex(base, "-")
+		d, err := time.ParseInLocation("20060102", base[i+1:], time.UTC)
+		if err != nil || base[i+1:] >= today {
+			continue
+		}
+		if filepath.Base(p) == l.name {
+			l.f.Close()
+			l.f, l.name = nil, ""
+		}
+		files = append(files, responseFile{source: base[:i], day: d, path: p})
+	}
+	return files
+}
+
+// readArchive 逐行解码一个归档文件, 不存在时返回 errArchiveNotFound
+func readArchive(ctx context.Context, src archiveStore, key string, fn func(json.RawMessage) error) error {
+	r, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dr, err := decompressReader(key, r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	dec := json.NewDecoder(dr)
+	for {
+		var m json.RawMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
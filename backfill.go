@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// 周期表的宽度, 周线另外从星期一对齐
+var candleWidths = map[string]int64{
+	coinmarketcapmin:   min,
+	coinmarketcap5min:  min5,
+	coinmarketcap10min: min10,
+	coinmarketcap15min: min15,
+	coinmarketcap30min: min30,
+	coinmarketcaphour:  hour,
+	coinmarketcapday:   day,
+	coinmarketcapweek:  week,
+}
+
+// bucketStart ts 所在周期的起点
+func bucketStart(tbl string, ts int64) int64 {
+	if tbl == coinmarketcapweek {
+		return weekBase(ts) - week
+	}
+	w := candleWidths[tbl]
+	return ts - ts%w
+}
+
+// backfiller 按每条数据自身的 timestamp 分桶重建周期表,
+// 合并方式和 aggregation 一致, 周期数据的 timestamp 为桶内第一条数据的时间
+type backfiller struct {
+	store  CandleStore
+	group  string
+	open   map[string]*kPriceCoinMarketCapList
+	bucket map[string]int64
+	closed int
+	// stale 标记 last_updated 没有前进的数据, 不排除资产
+	stale *staleTracker
+}
+
+func newBackfiller(store CandleStore, group string) *backfiller {
+	return &backfiller{
+		store:  store,
+		group:  group,
+		open:   make(map[string]*kPriceCoinMarketCapList),
+		bucket: make(map[string]int64),
+		stale:  newStaleTracker(coinMarketCapSource, 0),
+	}
+}
+
+// add 数据需按 timestamp 递增送入
+func (b *backfiller) add(ctx context.Context, x *kPriceCoinMarketCapList) error {
+	b.stale.apply(x)
+	for tbl := range candleWidths {
+		start := bucketStart(tbl, x.timestamp)
+		if cur := b.open[tbl]; cur != nil && b.bucket[tbl] != start {
+			if err := b.save(ctx, tbl, cur); err != nil {
+				return err
+			}
+			b.open[tbl] = nil
+		}
+		if b.open[tbl] == nil {
+			b.open[tbl], b.bucket[tbl] = x.Copy(), start
+			continue
+		}
+		summary(b.open[tbl], x)
+	}
+	return nil
+}
+
+// flush 写入所有未结束的周期
+func (b *backfiller) flush(ctx context.Context) error {
+	for tbl, cur := range b.open {
+		if cur == nil {
+			continue
+		}
+		if err := b.save(ctx, tbl, cur); err != nil {
+			return err
+		}
+		b.open[tbl] = nil
+	}
+	return nil
+}
+
+func (b *backfiller) save(ctx context.Context, tbl string, x *kPriceCoinMarketCapList) error {
+	rows := make([]*candleRow, len(x.list))
+	for i := range x.list {
+		rows[i] = newCandleRow(&x.list[i], x.timestamp, b.group)
+	}
+	b.closed++
+	return b.store.UpsertCandles(ctx, tbl, rows...)
+}
+
+var archiveReplayCommand = cli.Command{
+	Name:  "archive-replay",
+	Usage: "read archived raw ticks back and rebuild candles, optionally restoring the raw rows",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "first day: unix seconds, RFC 3339 or 2006-01-02",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "exclusive end day: unix seconds, RFC 3339 or 2006-01-02",
+		},
+		&cli.BoolFlag{
+			Name:  "restore",
+			Usage: "insert the ticks into the raw table again; only for days already deleted by retention",
+		},
+		&cli.BoolFlag{
+			Name:  "nocandles",
+			Usage: "do not rebuild candle tables",
+		},
+	},
+	Action: archiveReplayAction,
+}
+
+func archiveReplayAction(c *cli.Context) error {
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(c.String("to"))
+	if err != nil {
+		return err
+	}
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	src, err := openArchive(c)
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	tbl := c.GlobalString("tblname")
+	ctx := context.Background()
+	if err := store.Init(ctx, tbl); err != nil {
+		return err
+	}
+	var bf *backfiller
+	if !c.Bool("nocandles") {
+		bf = newBackfiller(store, "")
+	}
+	return replayArchive(ctx, src, store, tbl, from, to, c.Bool("restore"), bf)
+}
+
+// replayArchive 依次读取 [from, to) 的每天归档, 缺失的天跳过
+func replayArchive(ctx context.Context, src archiveStore, store TickStore, tbl string, from, to int64, restore bool, bf *backfiller) error {
+	rlog := component("archive-replay", "table", tbl)
+	for d := from - from%day; d < to; d += day {
+		key, err := findArchive(ctx, src, tbl, time.Unix(d, 0).UTC())
+		if err == errArchiveNotFound {
+			rlog.Warn("missing", "day", time.Unix(d, 0).UTC().Format("2006-01-02"))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var n int
+		err = readArchive(ctx, src, key, func(m json.RawMessage) error {
+			var t rawTicks
+			if err := json.Unmarshal(m, &t); err != nil {
+				return err
+			}
+			n++
+			if restore {
+				if err := store.InsertTicks(ctx, tbl, &t); err != nil {
+					return err
+				}
+			}
+			if bf != nil {
+				return bf.add(ctx, newKPriceCoinMarketCapList(t.List, t.Timestamp))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+		rlog.Info("replayed", "key", key, "ticks", n)
+	}
+	if bf != nil {
+		if err := bf.flush(ctx); err != nil {
+			return err
+		}
+		rlog.Info("candles rebuilt", "candles", bf.closed)
+	}
+	return nil
+}
+
+// findArchive 压缩格式可能改过, 两种扩展名都找
+func findArchive(ctx context.Context, src archiveStore, name string, d time.Time) (string, error) {
+	for _, ext := range []string{archiveExt["gzip"], archiveExt["zstd"]} {
+		key := archiveKey(name, d, ext)
+		ok, err := src.Exists(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	return "", errArchiveNotFound
+}
@@ -0,0 +1,148 @@
+// Package candles 读取 market 写入的周期表和 coinmarketcapcurrent,
+// 供其他服务直接使用, 不用再各自拼 SQL.
+package candles
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Timeframe 周期, 如 1m, 1h
+type Timeframe string
+
+const (
+	Minute         Timeframe = "1m"
+	FiveMinutes    Timeframe = "5m"
+	TenMinutes     Timeframe = "10m"
+	FifteenMinutes Timeframe = "15m"
+	ThirtyMinutes  Timeframe = "30m"
+	Hour           Timeframe = "1h"
+	Day            Timeframe = "1d"
+	Week           Timeframe = "1w"
+)
+
+// CurrentTable 实时数据表, 和周期表同结构
+const CurrentTable = "coinmarketcapcurrent"
+
+var tables = map[Timeframe]string{
+	Minute:         "coinmarketcapmin",
+	FiveMinutes:    "coinmarketcap5min",
+	TenMinutes:     "coinmarketcap10min",
+	FifteenMinutes: "coinmarketcap15min",
+	ThirtyMinutes:  "coinmarketcap30min",
+	Hour:           "coinmarketcaphour",
+	Day:            "coinmarketcapday",
+	Week:           "coinmarketcapweek",
+}
+
+// Table 返回周期对应的表名
+func (tf Timeframe) Table() (string, error) {
+	t, ok := tables[tf]
+	if !ok {
+		return "", fmt.Errorf("candles: unknown timeframe %q", tf)
+	}
+	return t, nil
+}
+
+// OHLC 一种计价货币的开高低收
+type OHLC struct {
+	Open  float64 `json:"open"`
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Close float64 `json:"close"`
+}
+
+// Candle 一个资产一个周期的数据.
+// Time 为周期内第一次抓取的时间, Group 为数据来源, 采集程序写入的为空
+type Candle struct {
+	AssetID     string    `json:"asset_id"`
+	Name        string    `json:"name"`
+	Symbol      string    `json:"symbol"`
+	Rank        int64     `json:"rank"`
+	Time        time.Time `json:"time"`
+	LastUpdated time.Time `json:"last_updated"`
+	USD         OHLC      `json:"usd"`
+	BTC         OHLC      `json:"btc"`
+	CNY         OHLC      `json:"cny"`
+	Group       string    `json:"group"`
+	// Stale 周期内上游价格没有更新过
+	Stale  bool   `json:"stale"`
+	Volume Volume `json:"volume"`
+}
+
+// Volume 周期内的成交量, 由成交数据汇总, 只有价格的来源为零值.
+// Base 为资产数量, Quote 为 USD 金额, VWAP = Quote / Base
+type Volume struct {
+	Base   float64 `json:"base"`
+	Quote  float64 `json:"quote"`
+	Trades int64   `json:"trades"`
+	VWAP   float64 `json:"vwap"`
+}
+
+// Quote 最新价格, Day 为当天 (UTC) 到目前为止的开高低收
+type Quote struct {
+	AssetID     string    `json:"asset_id"`
+	Name        string    `json:"name"`
+	Symbol      string    `json:"symbol"`
+	Rank        int64     `json:"rank"`
+	USD         float64   `json:"usd"`
+	BTC         float64   `json:"btc"`
+	CNY         float64   `json:"cny"`
+	LastUpdated time.Time `json:"last_updated"`
+	Day         struct {
+		USD OHLC `json:"usd"`
+		BTC OHLC `json:"btc"`
+		CNY OHLC `json:"cny"`
+	} `json:"day"`
+	Group string `json:"group"`
+	Stale bool   `json:"stale"`
+}
+
+// Reader 逐行读取周期表, market 的各种存储都实现了它
+type Reader interface {
+	// ScanCandles 按 timestamp, symbol 顺序逐行回调
+	ScanCandles(ctx context.Context, q Query, fn func(*Row) error) error
+}
+
+// Client 按资产和周期查询
+type Client struct {
+	r Reader
+}
+
+// New 使用 r 查询, 通常传入 market 打开的存储
+func New(r Reader) *Client {
+	return &Client{r: r}
+}
+
+// GetCandles 返回 assetID 在 [from, to) 内的周期数据, 零值时间表示不限.
+// 只返回采集程序自己写入的数据, 不包括各来源和成交数据的周期
+func (c *Client) GetCandles(ctx context.Context, assetID string, tf Timeframe, from, to time.Time) ([]Candle, error) {
+	tbl, err := tf.Table()
+	if err != nil {
+		return nil, err
+	}
+	q := Query{Table: tbl, AssetIDs: []string{assetID}, Groups: []string{""}}
+	if !from.IsZero() {
+		q.From = from.Unix()
+	}
+	if !to.IsZero() {
+		q.To = to.Unix()
+	}
+	var ret []Candle
+	err = c.r.ScanCandles(ctx, q, func(r *Row) error {
+		ret = append(ret, r.Candle())
+		return nil
+	})
+	return ret, err
+}
+
+// GetCurrent 返回 assetIDs 的最新价格, 不传时返回全部资产
+func (c *Client) GetCurrent(ctx context.Context, assetIDs ...string) ([]Quote, error) {
+	var ret []Quote
+	err := c.r.ScanCandles(ctx, Query{Table: CurrentTable, AssetIDs: assetIDs}, func(r *Row) error {
+		ret = append(ret, r.Quote())
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,134 @@
+package candles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 和 market 的 sqlite3 存储同样的表结构
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	cols := make([]string, len(Columns))
+	for i, c := range Columns {
+		cols[i] = c + " NUMERIC"
+	}
+	for _, tbl := range []string{"coinmarketcapmin", "coinmarketcaphour", CurrentTable} {
+		if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %v (id INTEGER PRIMARY KEY AUTOINCREMENT, %v)", tbl, strings.Join(cols, ", "))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func insertRow(t *testing.T, db *sql.DB, tbl string, r *Row) {
+	t.Helper()
+	ps := make([]string, len(Columns))
+	for i := range ps {
+		ps[i] = "?"
+	}
+	if _, err := db.Exec(fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)", tbl, strings.Join(Columns, ", "), strings.Join(ps, ", ")), r.Values()...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRow(asset string, ts int64, open, close float64) *Row {
+	return &Row{
+		AssetID: asset, Name: strings.ToUpper(asset[:1]) + asset[1:], Symbol: strings.ToUpper(asset[:3]), Rank: 1,
+		PriceUSDFirst: open, PriceUSDLast: close, PriceUSDLow: open - 1, PriceUSDHigh: close + 1,
+		PriceCNYFirst: open * 7, PriceCNYLast: close * 7, PriceCNYLow: (open - 1) * 7, PriceCNYHigh: (close + 1) * 7,
+		LastUpdated: ts + 5, Timestamp: ts,
+	}
+}
+
+func TestGetCandles(t *testing.T) {
+	db := openTestDB(t)
+	base := time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute).Unix()
+		insertRow(t, db, "coinmarketcapmin", testRow("bitcoin", ts, float64(100+i), float64(101+i)))
+		insertRow(t, db, "coinmarketcapmin", testRow("ethereum", ts, 10, 11))
+	}
+	insertRow(t, db, "coinmarketcaphour", testRow("bitcoin", base.Unix(), 100, 105))
+	// 来源自己的周期不返回, _group 为 NULL 的按采集程序的数据处理
+	src := testRow("bitcoin", base.Add(time.Minute).Unix(), 500, 500)
+	src.Group = "binance"
+	insertRow(t, db, "coinmarketcapmin", src)
+	if _, err := db.Exec("UPDATE coinmarketcapmin SET _group = NULL WHERE timestamp = ?", base.Add(2*time.Minute).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	got, err := c.GetCandles(ctx, "bitcoin", Minute, base.Add(time.Minute), base.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Candle{
+		{AssetID: "bitcoin", Name: "Bitcoin", Symbol: "BIT", Rank: 1, Time: base.Add(time.Minute), LastUpdated: base.Add(time.Minute + 5*time.Second),
+			USD: OHLC{101, 103, 100, 102}, CNY: OHLC{707, 721, 700, 714}},
+		{AssetID: "bitcoin", Name: "Bitcoin", Symbol: "BIT", Rank: 1, Time: base.Add(2 * time.Minute), LastUpdated: base.Add(2*time.Minute + 5*time.Second),
+			USD: OHLC{102, 104, 101, 103}, CNY: OHLC{714, 728, 707, 721}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	// 零值时间不限范围
+	if got, err := c.GetCandles(ctx, "bitcoin", Hour, time.Time{}, time.Time{}); err != nil || len(got) != 1 || got[0].USD.Close != 105 {
+		t.Errorf("hour: %+v, %v", got, err)
+	}
+	if _, err := c.GetCandles(ctx, "bitcoin", "2h", time.Time{}, time.Time{}); err == nil {
+		t.Error("unknown timeframe accepted")
+	}
+}
+
+func TestGetCurrent(t *testing.T) {
+	db := openTestDB(t)
+	ts := time.Date(2018, 1, 3, 12, 0, 0, 0, time.UTC).Unix()
+	for _, asset := range []string{"bitcoin", "ethereum", "litecoin"} {
+		r := testRow(asset, ts, 10, 20)
+		r.Group = "pricecoinmarketcap"
+		insertRow(t, db, CurrentTable, r)
+	}
+	if _, err := Open(db, "mysql"); err == nil {
+		t.Error("unsupported driver accepted")
+	}
+	c, _ := Open(db, "sqlite3")
+	got, err := c.GetCurrent(context.Background(), "ethereum", "litecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].AssetID != "ethereum" || got[1].AssetID != "litecoin" {
+		t.Fatalf("got %+v", got)
+	}
+	if q := got[0]; q.USD != 20 || q.CNY != 140 || q.Day.USD != (OHLC{10, 21, 9, 20}) || q.Group != "pricecoinmarketcap" {
+		t.Errorf("quote %+v", q)
+	}
+	if all, _ := c.GetCurrent(context.Background()); len(all) != 3 {
+		t.Errorf("got %d quotes, want 3", len(all))
+	}
+}
+
+func TestSelect(t *testing.T) {
+	q, args := Select(Query{Table: "coinmarketcapday", Symbols: []string{"BTC"}, AssetIDs: []string{"bitcoin", "ethereum"}, From: 1, To: 2}, Dollar)
+	want := "SELECT " + strings.Join(Columns, ", ") + " FROM coinmarketcapday WHERE timestamp >= $1 AND timestamp < $2 AND symbol IN ($3) AND asset_id IN ($4, $5) ORDER BY timestamp, symbol;"
+	if q != want || !reflect.DeepEqual(args, []interface{}{int64(1), int64(2), "BTC", "bitcoin", "ethereum"}) {
+		t.Errorf("got %v %v", q, args)
+	}
+}
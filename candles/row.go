@@ -0,0 +1,133 @@
+package candles
+
+import "time"
+
+// Columns 周期表的列, 顺序同 Row.Values
+var Columns = []string{
+	"asset_id", "name", "symbol", "rank",
+	"price_usd_first", "price_usd_last", "price_usd_low", "price_usd_high",
+	"price_btc_first", "price_btc_last", "price_btc_low", "price_btc_high",
+	"price_cny_first", "price_cny_last", "price_cny_low", "price_cny_high",
+	"last_updated", "timestamp", "_group", "stale",
+	"volume_base", "volume_quote", "trades", "vwap",
+}
+
+// Row 周期表的一行, 字段顺序同 Columns
+type Row struct {
+	AssetID       string  `json:"asset_id"`
+	Name          string  `json:"name"`
+	Symbol        string  `json:"symbol"`
+	Rank          int64   `json:"rank"`
+	PriceUSDFirst float64 `json:"price_usd_first"`
+	PriceUSDLast  float64 `json:"price_usd_last"`
+	PriceUSDLow   float64 `json:"price_usd_low"`
+	PriceUSDHigh  float64 `json:"price_usd_high"`
+	PriceBTCFirst float64 `json:"price_btc_first"`
+	PriceBTCLast  float64 `json:"price_btc_last"`
+	PriceBTCLow   float64 `json:"price_btc_low"`
+	PriceBTCHigh  float64 `json:"price_btc_high"`
+	PriceCNYFirst float64 `json:"price_cny_first"`
+	PriceCNYLast  float64 `json:"price_cny_last"`
+	PriceCNYLow   float64 `json:"price_cny_low"`
+	PriceCNYHigh  float64 `json:"price_cny_high"`
+	LastUpdated   int64   `json:"last_updated"`
+	Timestamp     int64   `json:"timestamp"`
+	Group         string  `json:"_group"`
+	// Stale 周期内上游的 last_updated 一直没有前进
+	Stale bool `json:"stale"`
+	// 由成交数据汇总, 计价货币为 USD, 只有抓取价格的来源都为 0
+	VolumeBase  float64 `json:"volume_base"`
+	VolumeQuote float64 `json:"volume_quote"`
+	Trades      int64   `json:"trades"`
+	VWAP        float64 `json:"vwap"`
+}
+
+// Values 按 Columns 的顺序展开
+func (r *Row) Values() []interface{} {
+	return []interface{}{r.AssetID, r.Name, r.Symbol, r.Rank,
+		r.PriceUSDFirst, r.PriceUSDLast, r.PriceUSDLow, r.PriceUSDHigh,
+		r.PriceBTCFirst, r.PriceBTCLast, r.PriceBTCLow, r.PriceBTCHigh,
+		r.PriceCNYFirst, r.PriceCNYLast, r.PriceCNYLow, r.PriceCNYHigh,
+		r.LastUpdated, r.Timestamp, r.Group, r.Stale,
+		r.VolumeBase, r.VolumeQuote, r.Trades, r.VWAP}
+}
+
+func (r *Row) usd() OHLC {
+	return OHLC{Open: r.PriceUSDFirst, High: r.PriceUSDHigh, Low: r.PriceUSDLow, Close: r.PriceUSDLast}
+}
+
+func (r *Row) btc() OHLC {
+	return OHLC{Open: r.PriceBTCFirst, High: r.PriceBTCHigh, Low: r.PriceBTCLow, Close: r.PriceBTCLast}
+}
+
+func (r *Row) cny() OHLC {
+	return OHLC{Open: r.PriceCNYFirst, High: r.PriceCNYHigh, Low: r.PriceCNYLow, Close: r.PriceCNYLast}
+}
+
+// Candle 周期表的一行转为 Candle
+func (r *Row) Candle() Candle {
+	return Candle{
+		AssetID:     r.AssetID,
+		Name:        r.Name,
+		Symbol:      r.Symbol,
+		Rank:        r.Rank,
+		Time:        time.Unix(r.Timestamp, 0).UTC(),
+		LastUpdated: time.Unix(r.LastUpdated, 0).UTC(),
+		USD:         r.usd(),
+		BTC:         r.btc(),
+		CNY:         r.cny(),
+		Group:       r.Group,
+		Stale:       r.Stale,
+		Volume:      Volume{Base: r.VolumeBase, Quote: r.VolumeQuote, Trades: r.Trades, VWAP: r.VWAP},
+	}
+}
+
+// Quote coinmarketcapcurrent 的一行转为 Quote
+func (r *Row) Quote() Quote {
+	q := Quote{
+		AssetID:     r.AssetID,
+		Name:        r.Name,
+		Symbol:      r.Symbol,
+		Rank:        r.Rank,
+		USD:         r.PriceUSDLast,
+		BTC:         r.PriceBTCLast,
+		CNY:         r.PriceCNYLast,
+		LastUpdated: time.Unix(r.LastUpdated, 0).UTC(),
+		Group:       r.Group,
+		Stale:       r.Stale,
+	}
+	q.Day.USD, q.Day.BTC, q.Day.CNY = r.usd(), r.btc(), r.cny()
+	return q
+}
+
+// Query 周期表查询条件, 时间范围为 [From, To), 0 表示不限
+type Query struct {
+	Table    string
+	Symbols  []string
+	AssetIDs []string
+	// Groups 按 _group 过滤, 空串为采集程序自己写入的数据
+	Groups []string
+	From   int64
+	To     int64
+}
+
+// Match 用于不经过 SQL 的存储
+func (q Query) Match(assetID, symbol, group string, ts int64) bool {
+	if ts < q.From || (q.To > 0 && ts >= q.To) {
+		return false
+	}
+	return contains(q.Symbols, symbol) && contains(q.AssetIDs, assetID) && contains(q.Groups, group)
+}
+
+// contains 空列表表示不限
+func contains(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
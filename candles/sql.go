@@ -0,0 +1,105 @@
+package candles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Placeholder 返回第 i 个参数的占位符
+type Placeholder func(i int) string
+
+// Dollar postgres 和 timescaledb 的 $1, $2
+func Dollar(i int) string {
+	return fmt.Sprintf("$%d", i)
+}
+
+// Question sqlite3 的 ?
+func Question(int) string {
+	return "?"
+}
+
+// Select 生成查询语句
+func Select(q Query, placeholder Placeholder) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+	in := func(col string, list []string) {
+		if len(list) == 0 {
+			return
+		}
+		ps := make([]string, len(list))
+		for i, s := range list {
+			ps[i] = arg(s)
+		}
+		where = append(where, col+" IN ("+strings.Join(ps, ", ")+")")
+	}
+	if q.From > 0 {
+		where = append(where, "timestamp >= "+arg(q.From))
+	}
+	if q.To > 0 {
+		where = append(where, "timestamp < "+arg(q.To))
+	}
+	in("symbol", q.Symbols)
+	in("asset_id", q.AssetIDs)
+	// 早期版本写入的 _group 可能为 NULL
+	in("COALESCE(_group, '')", q.Groups)
+	s := fmt.Sprintf("SELECT %v FROM %v", strings.Join(Columns, ", "), q.Table)
+	if len(where) > 0 {
+		s += " WHERE " + strings.Join(where, " AND ")
+	}
+	return s + " ORDER BY timestamp, symbol;", args
+}
+
+// Scan 执行 Select 并逐行回调
+func Scan(ctx context.Context, db *sql.DB, q Query, placeholder Placeholder, fn func(*Row) error) error {
+	query, args := Select(q, placeholder)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Row
+		var group sql.NullString
+		if err := rows.Scan(&r.AssetID, &r.Name, &r.Symbol, &r.Rank,
+			&r.PriceUSDFirst, &r.PriceUSDLast, &r.PriceUSDLow, &r.PriceUSDHigh,
+			&r.PriceBTCFirst, &r.PriceBTCLast, &r.PriceBTCLow, &r.PriceBTCHigh,
+			&r.PriceCNYFirst, &r.PriceCNYLast, &r.PriceCNYLow, &r.PriceCNYHigh,
+			&r.LastUpdated, &r.Timestamp, &group, &r.Stale,
+			&r.VolumeBase, &r.VolumeQuote, &r.Trades, &r.VWAP); err != nil {
+			return err
+		}
+		r.Group = group.String
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+type sqlReader struct {
+	db          *sql.DB
+	placeholder Placeholder
+}
+
+func (s sqlReader) ScanCandles(ctx context.Context, q Query, fn func(*Row) error) error {
+	return Scan(ctx, s.db, q, s.placeholder, fn)
+}
+
+// Open 直接查询 market 使用的数据库, driver 同 market 的 --drivername:
+// postgres, timescaledb 或 sqlite3. db 由调用方打开和关闭
+func Open(db *sql.DB, driver string) (*Client, error) {
+	switch driver {
+	case "postgres", "timescaledb":
+		return New(sqlReader{db, Dollar}), nil
+	case "sqlite3":
+		return New(sqlReader{db, Question}), nil
+	default:
+		return nil, fmt.Errorf("candles: unsupported driver %q", driver)
+	}
+}
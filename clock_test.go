@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock 模拟时钟, 只有 Advance 才会推进时间
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clk     *fakeClock
+	c       chan time.Time
+	d       time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tk := &fakeTicker{clk: c, c: make(chan time.Time), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, tk)
+	c.cond.Broadcast()
+	return tk
+}
+
+// waitTickers 等待 n 个 ticker 创建完成
+func (c *fakeClock) waitTickers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tickers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Advance 按触发时间依次推进, 每个心跳都等接收方取走后才继续
+func (c *fakeClock) Advance(d time.Duration) {
+	end := c.Now().Add(d)
+	for {
+		c.mu.Lock()
+		var at time.Time
+		for _, tk := range c.tickers {
+			if !tk.stopped && (at.IsZero() || tk.next.Before(at)) {
+				at = tk.next
+			}
+		}
+		if at.IsZero() || at.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		c.now = at
+		var due []*fakeTicker
+		for _, tk := range c.tickers {
+			if !tk.stopped && tk.next.Equal(at) {
+				due = append(due, tk)
+				tk.next = tk.next.Add(tk.d)
+			}
+		}
+		c.mu.Unlock()
+		for _, tk := range due {
+			tk.c <- at
+		}
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clk.mu.Lock()
+	t.stopped = true
+	t.clk.mu.Unlock()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compositeSource 多来源时综合价格使用的来源名, 综合价格写入 _group 为空的周期数据
+const compositeSource = "composite"
+
+// sourceFeed 一个上游, 返回和 coinmarketcap v1 ticker 相同格式的数据
+type sourceFeed struct {
+	name    string
+	url     string
+	fetcher *fetchClient
+	// 多来源时该来源自己的周期数据, _group 为来源名
+	ch     chan *kPriceCoinMarketCapList
+	stale  *staleTracker
+	filter *tickFilter
+	// raw 多来源时该来源返回的原始数据, 写入 sourceRawTable
+	raw *writeQueue[*rawTicks]
+}
+
+// 来源名会出现在表名里
+var sourceName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// sourceRawTable 多来源时每个来源的原始数据表, rawTable 中是综合价格
+func sourceRawTable(rawTable, source string) string {
+	return rawTable + "_" + source
+}
+
+// parseSources 解析 name=url,name=url, url 中的 %d 替换为抓取时间
+func parseSources(s string) ([]*sourceFeed, error) {
+	var ret []*sourceFeed
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(item, "=")
+		name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("source %q: want name=url", item)
+		}
+		if !sourceName.MatchString(name) {
+			return nil, fmt.Errorf("source %q: name must be lowercase letters, digits or _", name)
+		}
+		if name == compositeSource || seen[name] {
+			return nil, fmt.Errorf("source %q: duplicate or reserved name", name)
+		}
+		seen[name] = true
+		ret = append(ret, &sourceFeed{name: name, url: url})
+	}
+	return ret, nil
+}
+
+func (f *sourceFeed) urlAt(ts int64) string {
+	if strings.Contains(f.url, "%d") {
+		return fmt.Sprintf(f.url, ts)
+	}
+	return f.url
+}
+
+// fetchAll 并发抓取所有来源, 失败的来源对应位置为 nil
+func fetchAll(ctx context.Context, feeds []*sourceFeed, ts int64) [][]priceCoinMarketCap {
+	ret := make([][]priceCoinMarketCap, len(feeds))
+	var wg sync.WaitGroup
+	for i, f := range feeds {
+		wg.Add(1)
+		go func(i int, f *sourceFeed) {
+			defer wg.Done()
+			var list []priceCoinMarketCap
+			if err := f.fetcher.getJSON(ctx, f.urlAt(ts), &list); err != nil {
+				f.fetcher.log.Error("fetch failed", "err", err)
+				return
+			}
+			ret[i] = list
+		}(i, f)
+	}
+	wg.Wait()
+	return ret
+}
+
+type consensusMethod int
+
+const (
+	// consensusMedian 各来源价格的中位数
+	consensusMedian consensusMethod = iota
+	// consensusVolume 按各来源 24 小时成交额加权平均, 成交额都为 0 时取中位数
+	consensusVolume
+)
+
+func parseConsensusMethod(s string) (consensusMethod, error) {
+	switch s {
+	case "median":
+		return consensusMedian, nil
+	case "volume":
+		return consensusVolume, nil
+	}
+	return 0, fmt.Errorf("unknown consensus method %q", s)
+}
+
+// consensus 合并多个来源的价格. 每个资产先求各来源 USD 价格的中位数,
+// 偏离超过 deviation 的来源不参与计算, 剩下的来源少于 quorum 时本次跳过该资产
+type consensus struct {
+	method    consensusMethod
+	deviation float64
+	quorum    int
+	log       *slog.Logger
+}
+
+func newConsensus(method consensusMethod, deviation float64, quorum int) *consensus {
+	if quorum < 1 {
+		quorum = 1
+	}
+	return &consensus{method: method, deviation: deviation, quorum: quorum, log: component("consensus")}
+}
+
+// quote 一个来源对一个资产的报价
+type quote struct {
+	source string
+	v      *priceCoinMarketCap
+	usd    float64
+	volume float64
+}
+
+// combine names 和 lists 一一对应, 结果按资产第一次出现的顺序排列
+func (c *consensus) combine(names []string, lists [][]priceCoinMarketCap) []priceCoinMarketCap {
+	var order []string
+	quotes := make(map[string][]quote)
+	for i, list := range lists {
+		for j := range list {
+			v := &list[j]
+			usd, ok := validPrice(v.PriceUSD)
+			if !ok {
+				continue
+			}
+			if _, seen := quotes[v.Id]; !seen {
+				order = append(order, v.Id)
+			}
+			volume, _ := strconv.ParseFloat(v.VolumeUSD24H, 64)
+			quotes[v.Id] = append(quotes[v.Id], quote{source: names[i], v: v, usd: usd, volume: volume})
+		}
+	}
+	ret := make([]priceCoinMarketCap, 0, len(order))
+	for _, id := range order {
+		if v, ok := c.asset(id, quotes[id]); ok {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (c *consensus) asset(id string, qs []quote) (priceCoinMarketCap, bool) {
+	usd := make([]float64, len(qs))
+	for i, q := range qs {
+		usd[i] = q.usd
+	}
+	m := median(usd)
+	var kept []quote
+	for _, q := range qs {
+		if math.Abs(q.usd/m-1) > c.deviation {
+			consensusExcluded.inc(q.source)
+			c.log.Debug("source excluded", "asset", id, "source", q.source, "price_usd", q.usd, "median", m)
+			continue
+		}
+		kept = append(kept, q)
+	}
+	if len(kept) < c.quorum {
+		consensusNoQuorum.inc()
+		c.log.Debug("no quorum", "asset", id, "sources", len(qs), "agreeing", len(kept))
+		return priceCoinMarketCap{}, false
+	}
+
+	// 名称等字段取第一个参与计算的来源
+	ret := *kept[0].v
+	var updated int64
+	for _, q := range kept {
+		if n, _ := strconv.ParseInt(q.v.LastUpdated, 10, 64); n > updated {
+			updated = n
+		}
+	}
+	ret.LastUpdated = strconv.FormatInt(updated, 10)
+	ret.PriceUSD = c.price(kept, func(v *priceCoinMarketCap) string { return v.PriceUSD })
+	ret.PriceBTC = c.price(kept, func(v *priceCoinMarketCap) string { return v.PriceBTC })
+	ret.PriceCNY = c.price(kept, func(v *priceCoinMarketCap) string { return v.PriceCNY })
+	// 参与计算的来源都没有 BTC 或 CNY 价格时跳过, 否则会按 0 进入周期数据成为最低价
+	if ret.PriceBTC == "" || ret.PriceCNY == "" {
+		c.log.Debug("missing price", "asset", id, "price_btc", ret.PriceBTC, "price_cny", ret.PriceCNY)
+		return priceCoinMarketCap{}, false
+	}
+	// 聚合类的来源各自已经是全网数据, 成交额和市值取中位数, 不相加
+	ret.VolumeUSD24H = medianField(kept, func(v *priceCoinMarketCap) string { return v.VolumeUSD24H })
+	ret.VolumeCNY24H = medianField(kept, func(v *priceCoinMarketCap) string { return v.VolumeCNY24H })
+	ret.MarketCapUSD = medianField(kept, func(v *priceCoinMarketCap) string { return v.MarketCapUSD })
+	ret.MarketCapCNY = medianField(kept, func(v *priceCoinMarketCap) string { return v.MarketCapCNY })
+	return ret, true
+}
+
+// price 缺少该币种价格的来源不参与计算
+func (c *consensus) price(qs []quote, field func(*priceCoinMarketCap) string) string {
+	var xs, ws []float64
+	var total float64
+	for _, q := range qs {
+		if p, ok := validPrice(field(q.v)); ok {
+			xs, ws = append(xs, p), append(ws, q.volume)
+			total += q.volume
+		}
+	}
+	if len(xs) == 0 {
+		return ""
+	}
+	if c.method == consensusVolume && total > 0 {
+		var sum float64
+		for i := range xs {
+			sum += xs[i] * ws[i]
+		}
+		return strconv.FormatFloat(sum/total, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(median(xs), 'f', -1, 64)
+}
+
+func medianField(qs []quote, field func(*priceCoinMarketCap) string) string {
+	var xs []float64
+	for _, q := range qs {
+		if x, err := strconv.ParseFloat(field(q.v), 64); err == nil {
+			xs = append(xs, x)
+		}
+	}
+	if len(xs) == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(median(xs), 'f', -1, 64)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sourceQuote(id string, usd, volume string) priceCoinMarketCap {
+	return priceCoinMarketCap{Id: id, Symbol: id, Name: id, PriceUSD: usd, PriceBTC: "1", PriceCNY: usd, VolumeUSD24H: volume, LastUpdated: "1514937600"}
+}
+
+func TestParseSources(t *testing.T) {
+	feeds, err := parseSources("a=http://a/?ts=%d, b = http://b/")
+	if err != nil || len(feeds) != 2 || feeds[1].name != "b" || feeds[0].urlAt(5) != "http://a/?ts=5" || feeds[1].urlAt(5) != "http://b/" {
+		t.Fatalf("%+v, %v", feeds, err)
+	}
+	for _, s := range []string{"a", "a=", "a=x,a=y", "composite=x", "A-1=x"} {
+		if _, err := parseSources(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	lists := [][]priceCoinMarketCap{
+		{sourceQuote("bitcoin", "100", "1000"), sourceQuote("ethereum", "10", "0")},
+		{sourceQuote("bitcoin", "102", "3000"), sourceQuote("ethereum", "20", "0")},
+		// c 的 bitcoin 偏离太多, 不参与计算
+		{sourceQuote("bitcoin", "150", "9000"), sourceQuote("litecoin", "5", "0")},
+	}
+	lists[1][0].LastUpdated = "1514937660"
+
+	got := newConsensus(consensusMedian, 0.05, 2).combine(names, lists)
+	// ethereum 两个来源相差太大, litecoin 只有一个来源, 都达不到 quorum
+	if len(got) != 1 || got[0].Id != "bitcoin" || got[0].PriceUSD != "101" || got[0].VolumeUSD24H != "2000" || got[0].LastUpdated != "1514937660" {
+		t.Fatalf("median: %+v", got)
+	}
+
+	got = newConsensus(consensusVolume, 0.05, 1).combine(names, lists)
+	if len(got) != 2 || got[0].PriceUSD != "101.5" || got[1].Id != "litecoin" {
+		t.Fatalf("volume: %+v", got)
+	}
+
+	// 没有来源给出 BTC 价格时跳过, 不输出空价格
+	lists[0][0].PriceBTC, lists[1][0].PriceBTC = "", ""
+	got = newConsensus(consensusMedian, 0.05, 2).combine(names, lists)
+	if len(got) != 0 {
+		t.Fatalf("missing btc price: %+v", got)
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	serve := func(list []priceCoinMarketCap) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if list == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(list)
+		}))
+	}
+	a := serve([]priceCoinMarketCap{sourceQuote("bitcoin", "100", "0")})
+	b := serve(nil)
+	c := serve([]priceCoinMarketCap{sourceQuote("bitcoin", "104", "0")})
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+	feeds, err := parseSources("a=" + a.URL + ",b=" + b.URL + ",c=" + c.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range feeds {
+		f.fetcher = newFetchClient(f.name, time.Second, 0, newBreaker(10, time.Second))
+		names = append(names, f.name)
+	}
+	lists := fetchAll(context.Background(), feeds, 1514937600)
+	if len(lists[0]) != 1 || lists[1] != nil || len(lists[2]) != 1 {
+		t.Fatalf("lists %+v", lists)
+	}
+	// 一个来源失败时其余来源照常合并
+	if got := newConsensus(consensusMedian, 0.05, 2).combine(names, lists); len(got) != 1 || got[0].PriceUSD != "102" {
+		t.Errorf("composite %+v", got)
+	}
+}
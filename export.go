@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// 每个 parquet row group 的行数
+const parquetGroupSize = 65536
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export candles to csv, jsonl or parquet",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "table",
+			Usage: "candle table, e.g. coinmarketcapmin",
+		},
+		&cli.StringFlag{
+			Name:  "interval",
+			Usage: "candle interval instead of --table: 1m, 5m, 10m, 15m, 30m, 1h, 1d, 1w or current",
+		},
+		&cli.StringFlag{
+			Name:  "symbols",
+			Usage: "comma separated symbols, all when empty",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "inclusive start: unix seconds, RFC 3339 or 2006-01-02",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "exclusive end: unix seconds, RFC 3339 or 2006-01-02",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "csv",
+			Usage: "csv, jsonl or parquet",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Value: "-",
+			Usage: "output file, - for stdout",
+		},
+		&cli.BoolFlag{
+			Name:  "isotime",
+			Usage: "write timestamp and last_updated as ISO-8601 instead of unix seconds",
+		},
+	},
+	Action: exportAction,
+}
+
+func exportAction(c *cli.Context) error {
+	q, err := candleQueryFlags(c)
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	out := io.Writer(os.Stdout)
+	if path := c.String("out"); path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	w, err := newCandleWriter(c.String("format"), bw, c.Bool("isotime"))
+	if err != nil {
+		return err
+	}
+	var n int
+	if err := store.ScanCandles(context.Background(), q, func(r *candleRow) error {
+		n++
+		return w.write(r)
+	}); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	component("export").Info("done", "table", q.Table, "rows", n)
+	return nil
+}
+
+// candleQueryFlags 解析 --table/--interval, --symbols, --from, --to
+func candleQueryFlags(c *cli.Context) (candleQuery, error) {
+	var q candleQuery
+	q.Table = c.String("table")
+	if iv := c.String("interval"); iv != "" {
+		for tbl, tf := range timeframes {
+			if tf == iv {
+				q.Table = tbl
+			}
+		}
+		if q.Table == "" {
+			return q, fmt.Errorf("unknown interval %q", iv)
+		}
+	}
+	if _, ok := timeframes[q.Table]; !ok {
+		return q, fmt.Errorf("unknown candle table %q", q.Table)
+	}
+	for _, s := range strings.Split(c.String("symbols"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			q.Symbols = append(q.Symbols, s)
+		}
+	}
+	var err error
+	if q.From, err = parseTime(c.String("from")); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime(c.String("to")); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// parseTime 接受 unix 秒, RFC 3339 和 UTC 日期, 空串返回 0
+func parseTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time %q", s)
+}
+
+func isoTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+type candleWriter interface {
+	write(r *candleRow) error
+	Close() error
+}
+
+func newCandleWriter(format string, w io.Writer, iso bool) (candleWriter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		return &csvCandleWriter{w: cw, iso: iso}, cw.Write(candleColumns)
+	case "jsonl":
+		return &jsonlCandleWriter{enc: json.NewEncoder(w), iso: iso}, nil
+	case "parquet":
+		cols := make([]parquetColumn, len(candleColumns))
+		for i, v := range (&candleRow{}).Values() {
+			cols[i] = parquetColumn{name: candleColumns[i], converted: parquetNone}
+			switch v.(type) {
+			case string:
+				cols[i].typ, cols[i].converted = parquetByteArray, parquetUTF8
+			case int64:
+				cols[i].typ = parquetInt64
+			case float64:
+				cols[i].typ = parquetDouble
+			case bool:
+				cols[i].typ = parquetBoolean
+			}
+			if iso && isTimeColumn(candleColumns[i]) {
+				cols[i].converted = parquetTimestampMillis
+			}
+		}
+		pw, err := newParquetWriter(w, cols, parquetGroupSize)
+		return &parquetCandleWriter{w: pw, iso: iso}, err
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func isTimeColumn(col string) bool {
+	return col == "timestamp" || col == "last_updated"
+}
+
+type csvCandleWriter struct {
+	w   *csv.Writer
+	iso bool
+}
+
+func (c *csvCandleWriter) write(r *candleRow) error {
+	vals := r.Values()
+	rec := make([]string, len(vals))
+	for i, v := range vals {
+		switch x := v.(type) {
+		case string:
+			rec[i] = x
+		case int64:
+			if c.iso && isTimeColumn(candleColumns[i]) {
+				rec[i] = isoTime(x)
+			} else {
+				rec[i] = strconv.FormatInt(x, 10)
+			}
+		case float64:
+			rec[i] = strconv.FormatFloat(x, 'f', -1, 64)
+		case bool:
+			rec[i] = strconv.FormatBool(x)
+		}
+	}
+	return c.w.Write(rec)
+}
+
+func (c *csvCandleWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// isoCandleRow 覆盖 candleRow 中的两个时间字段
+type isoCandleRow struct {
+	*candleRow
+	LastUpdated string `json:"last_updated"`
+	Timestamp   string `json:"timestamp"`
+}
+
+type jsonlCandleWriter struct {
+	enc *json.Encoder
+	iso bool
+}
+
+func (j *jsonlCandleWriter) write(r *candleRow) error {
+	if j.iso {
+		return j.enc.Encode(isoCandleRow{r, isoTime(r.LastUpdated), isoTime(r.Timestamp)})
+	}
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlCandleWriter) Close() error {
+	return nil
+}
+
+type parquetCandleWriter struct {
+	w   *parquetWriter
+	iso bool
+}
+
+func (p *parquetCandleWriter) write(r *candleRow) error {
+	vals := r.Values()
+	if p.iso {
+		for i, col := range candleColumns {
+			if isTimeColumn(col) {
+				vals[i] = vals[i].(int64) * 1000
+			}
+		}
+	}
+	return p.w.writeRow(vals...)
+}
+
+func (p *parquetCandleWriter) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exportString(t *testing.T, store storage, q candleQuery, format string, iso bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newCandleWriter(format, &buf, iso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ScanCandles(context.Background(), q, w.write); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExport(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	eth := testTick(1514937660, 2)
+	eth.list[0].Id, eth.list[0].Name, eth.list[0].Symbol = "ethereum", "Ethereum", "ETH"
+	store.SaveCandles(ctx, coinmarketcapmin, "g", testTick(1514937660, 1.5), eth, testTick(1514937600, 1))
+
+	q := candleQuery{Table: coinmarketcapmin, Symbols: []string{"BTC"}}
+	got := exportString(t, store, q, "csv", true)
+	want := strings.Join(candleColumns, ",") + "\n" +
+		"bitcoin,Bitcoin,BTC,1,1,1,1,1,1,1,1,1,7,7,7,7,2018-01-03T00:00:00Z,2018-01-03T00:00:00Z,g,false,0,0,0,0\n" +
+		"bitcoin,Bitcoin,BTC,1,1.5,1.5,1.5,1.5,1,1,1,1,10.5,10.5,10.5,10.5,2018-01-03T00:01:00Z,2018-01-03T00:01:00Z,g,false,0,0,0,0\n"
+	if got != want {
+		t.Errorf("csv:\n%v\nwant:\n%v", got, want)
+	}
+
+	q = candleQuery{Table: coinmarketcapmin, From: 1514937660, To: 1514937661}
+	got = exportString(t, store, q, "jsonl", false)
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"symbol":"BTC"`) || !strings.Contains(lines[1], `"symbol":"ETH"`) {
+		t.Fatalf("jsonl: %v", got)
+	}
+	if !strings.Contains(lines[1], `"timestamp":1514937660`) {
+		t.Errorf("jsonl: unix timestamp expected in %v", lines[1])
+	}
+
+	var rows [][]interface{}
+	store.ScanCandles(ctx, q, func(r *candleRow) error {
+		rows = append(rows, r.Values())
+		return nil
+	})
+	got = exportString(t, store, q, "parquet", false)
+	names, read, err := readParquet([]byte(got))
+	if err != nil {
+		t.Fatalf("parquet: %v", err)
+	}
+	if !reflect.DeepEqual(names, candleColumns) || len(rows) != 2 || !reflect.DeepEqual(read, rows) {
+		t.Errorf("parquet: %v\n%v\nwant %v", names, read, rows)
+	}
+	// iso 时两个时间列为毫秒
+	got = exportString(t, store, q, "parquet", true)
+	if _, read, err = readParquet([]byte(got)); err != nil || read[0][16] != int64(1514937660000) {
+		t.Errorf("parquet iso: %v, %v", read, err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":                          0,
+		"1514937600":                1514937600,
+		"2018-01-03":                1514937600,
+		"2018-01-03T00:01:00":       1514937660,
+		"2018-01-03T08:00:00+08:00": 1514937600,
+	} {
+		if got, err := parseTime(s); err != nil || got != want {
+			t.Errorf("parseTime(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := parseTime("yesterday"); err == nil {
+		t.Error("parseTime(yesterday): expected error")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errBreakerOpen = errors.New("fetch: circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// breaker 连续失败 threshold 次后熔断 cooldown, 之后放行一次试探请求
+type breaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	// onTransition 在每次状态切换时调用, 持锁调用不要阻塞
+	onTransition func(from, to breakerState)
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *breaker) setState(to breakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	if to == breakerOpen {
+		b.openedAt = time.Now()
+	}
+	if b.onTransition != nil {
+		b.onTransition(from, to)
+	}
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// 试探请求还没有结果
+		return false
+	}
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.setState(breakerClosed)
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.setState(breakerOpen)
+	}
+}
+
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("fetch: unexpected status %d", e.code)
+}
+
+type fetchClient struct {
+	source     string
+	client     *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *breaker
+	// maxWait 重试前最多等待的时间, 服务端的 Retry-After 也不超过它, 0 表示不限
+	maxWait time.Duration
+	log     *slog.Logger
+	// record 不为空时每次响应都交给它保存
+	record func(*responseRecord)
+}
+
+func newFetchClient(source string, timeout time.Duration, retries int, b *breaker) *fetchClient {
+	return &fetchClient{
+		source:     source,
+		client:     &http.Client{Timeout: timeout},
+		retries:    retries,
+		backoff:    500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		breaker:    b,
+		log:        component("fetch", "source", source),
+	}
+}
+
+// getJSON 请求 url 并把结果解析到 v, 失败按指数退避重试, ctx 结束时不再重试.
+// 一次抓取无论重试几次, 对熔断器只算一次成功或失败
+func (f *fetchClient) getJSON(ctx context.Context, url string, v interface{}) error {
+	if !f.breaker.allow() {
+		return errBreakerOpen
+	}
+	var err error
+	for attempt := 0; attempt <= f.retries; attempt++ {
+		if attempt > 0 {
+			wait := f.delay(attempt)
+			if se, ok := err.(*statusError); ok && se.retryAfter > wait {
+				wait = se.retryAfter
+			}
+			if f.maxWait > 0 && wait > f.maxWait {
+				wait = f.maxWait
+			}
+			if sleep(ctx, wait) != nil {
+				break
+			}
+		}
+		err = f.do(ctx, url, v)
+		if err == nil {
+			f.breaker.success()
+			return nil
+		}
+		fetchErrors.inc(f.source)
+		if !retryable(err) {
+			break
+		}
+		f.log.Warn("fetch attempt failed", "attempt", attempt+1, "err", err)
+	}
+	f.breaker.failure()
+	return err
+}
+
+// sleep 等待 d, ctx 先结束时返回 ctx 的错误
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fetchClient) do(ctx context.Context, url string, v interface{}) error {
+	start := time.Now()
+	defer fetchDuration.since(start, f.source)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if f.record == nil {
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			return &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return fmt.Errorf("decode error %v", err)
+		}
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	f.record(&responseRecord{Time: start, Source: f.source, URL: url, Status: resp.StatusCode, Body: string(body)})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decode error %v", err)
+	}
+	return nil
+}
+
+// delay 指数退避加 full jitter
+func (f *fetchClient) delay(attempt int) time.Duration {
+	d := f.backoff << uint(attempt-1)
+	if d <= 0 || d > f.maxBackoff {
+		d = f.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	// 网络错误和超时都重试, 解析错误不重试
+	var ue interface{ Timeout() bool }
+	return errors.As(err, &ue)
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testFetchClient(retries int, b *breaker) *fetchClient {
+	f := newFetchClient("test", time.Second, retries, b)
+	f.backoff, f.maxBackoff = time.Millisecond, time.Millisecond
+	return f
+}
+
+func TestBreakerCountsFetches(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	b := newBreaker(2, 20*time.Millisecond)
+	f := testFetchClient(2, b)
+	ctx := context.Background()
+	var v []priceCoinMarketCap
+
+	// 三次请求只是一次失败的抓取
+	if err := f.getJSON(ctx, srv.URL, &v); err == nil || atomic.LoadInt32(&calls) != 3 || b.state != breakerClosed {
+		t.Fatalf("first fetch: %v, %d calls, %v", err, calls, b.state)
+	}
+	f.getJSON(ctx, srv.URL, &v)
+	if b.state != breakerOpen {
+		t.Fatalf("state %v after two failed fetches", b.state)
+	}
+	if err := f.getJSON(ctx, srv.URL, &v); err != errBreakerOpen || atomic.LoadInt32(&calls) != 6 {
+		t.Fatalf("open: %v, %d calls", err, calls)
+	}
+
+	// 冷却之后放行一次试探, 成功后关闭
+	time.Sleep(30 * time.Millisecond)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("[]")) })
+	if err := f.getJSON(ctx, srv.URL, &v); err != nil || b.state != breakerClosed {
+		t.Fatalf("half open: %v, %v", err, b.state)
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	var v []priceCoinMarketCap
+
+	f := testFetchClient(1, newBreaker(10, time.Second))
+	f.maxWait = 10 * time.Millisecond
+	start := time.Now()
+	if err := f.getJSON(context.Background(), srv.URL, &v); err != nil || time.Since(start) > time.Second {
+		t.Fatalf("capped: %v after %v", err, time.Since(start))
+	}
+
+	// 不限制时由 ctx 结束等待
+	atomic.StoreInt32(&calls, 0)
+	f.maxWait = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := f.getJSON(ctx, srv.URL, &v); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("ctx: %v after %v", err, time.Since(start))
+	}
+}
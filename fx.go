@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fxRate 一种法币的汇率, Rate 为 1 USD 兑换的该货币数量
+type fxRate struct {
+	Currency  string
+	Timestamp int64
+	Rate      float64
+}
+
+var fxRateColumns = []string{"currency", "timestamp", "rate"}
+
+func (r *fxRate) values() []interface{} {
+	return []interface{}{r.Currency, r.Timestamp, r.Rate}
+}
+
+// convertedRow 换算成其他计价货币的周期数据
+type convertedRow struct {
+	AssetID   string
+	Symbol    string
+	Timeframe string
+	Quote     string
+	Timestamp int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+}
+
+var convertedColumns = []string{"asset_id", "symbol", "timeframe", "quote", "timestamp", "open", "high", "low", "close"}
+
+func (r *convertedRow) values() []interface{} {
+	return []interface{}{r.AssetID, r.Symbol, r.Timeframe, r.Quote, r.Timestamp, r.Open, r.High, r.Low, r.Close}
+}
+
+// ConvertStore 保存汇率和换算后的周期数据
+type ConvertStore interface {
+	InitConverted(ctx context.Context) error
+	SaveFXRates(ctx context.Context, rates ...fxRate) error
+	SaveConverted(ctx context.Context, rows ...*convertedRow) error
+	// UpsertConverted 替换资产, 周期, 计价货币和 timestamp 相同的行
+	UpsertConverted(ctx context.Context, rows ...*convertedRow) error
+}
+
+// fxProvider 汇率来源, 每次返回 ts 时能拿到的全部汇率
+type fxProvider interface {
+	rates(ts int64) ([]fxRate, error)
+}
+
+// parseFXProvider file:path 读取本地 csv, 其他按 http 地址处理
+func parseFXProvider(s string, fetcher *fetchClient) (fxProvider, error) {
+	if path, ok := strings.CutPrefix(s, "file:"); ok {
+		return &fileFX{path: path}, nil
+	}
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return &httpFX{feed: &sourceFeed{name: "fx", url: s, fetcher: fetcher}}, nil
+	}
+	return nil, fmt.Errorf("fx provider %q: want file:path or an http url", s)
+}
+
+// splitList 逗号分隔的列表, 去掉空项
+func splitList(s string) []string {
+	var ret []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// fileFX 每行 timestamp,currency,rate, 可以带表头, # 开头的行忽略.
+// 每次都重新读取, 追加的行下次抓取时生效
+type fileFX struct {
+	path string
+}
+
+func (p *fileFX) rates(ts int64) ([]fxRate, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ret []fxRate
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || (n == 1 && strings.HasPrefix(line, "timestamp")) {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v:%d: want timestamp,currency,rate", p.path, n)
+		}
+		r := fxRate{Currency: strings.ToUpper(strings.TrimSpace(fields[1]))}
+		if r.Timestamp, err = strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64); err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", p.path, n, err)
+		}
+		if r.Rate, err = strconv.ParseFloat(strings.TrimSpace(fields[2]), 64); err != nil || r.Rate <= 0 || r.Currency == "" {
+			return nil, fmt.Errorf("%v:%d: bad rate", p.path, n)
+		}
+		if r.Timestamp <= ts {
+			ret = append(ret, r)
+		}
+	}
+	return ret, sc.Err()
+}
+
+// httpFX 返回 {"timestamp": 1514937600, "rates": {"EUR": 0.83}}, 没有 timestamp 时用抓取时间.
+// url 中的 %d 替换为抓取时间
+type httpFX struct {
+	feed *sourceFeed
+}
+
+func (p *httpFX) rates(ts int64) ([]fxRate, error) {
+	var resp struct {
+		Timestamp int64              `json:"timestamp"`
+		Rates     map[string]float64 `json:"rates"`
+	}
+	if err := p.feed.fetcher.getJSON(context.Background(), p.feed.urlAt(ts), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Timestamp == 0 {
+		resp.Timestamp = ts
+	}
+	var ret []fxRate
+	for cur, rate := range resp.Rates {
+		if rate > 0 {
+			ret = append(ret, fxRate{Currency: strings.ToUpper(cur), Timestamp: resp.Timestamp, Rate: rate})
+		}
+	}
+	return ret, nil
+}
+
+// fxRates 按货币保存的汇率历史, 查询时取 ts 之前最近的一个
+type fxRates struct {
+	mu     sync.Mutex
+	series map[string][]fxRate
+	maxAge int64
+	keep   int64
+}
+
+// newFXRates 超过 maxAge 的汇率不再使用, 只保留最新汇率之前 keep 内的历史
+func newFXRates(maxAge, keep time.Duration) *fxRates {
+	return &fxRates{series: make(map[string][]fxRate), maxAge: int64(maxAge / time.Second), keep: int64(keep / time.Second)}
+}
+
+// add 返回之前没有的汇率, 同一货币同一 timestamp 只保留第一个
+func (f *fxRates) add(rates ...fxRate) []fxRate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var added []fxRate
+	for _, r := range rates {
+		s := f.series[r.Currency]
+		i := sort.Search(len(s), func(i int) bool { return s[i].Timestamp >= r.Timestamp })
+		if i < len(s) && s[i].Timestamp == r.Timestamp {
+			continue
+		}
+		s = append(s, fxRate{})
+		copy(s[i+1:], s[i:])
+		s[i] = r
+		f.series[r.Currency] = s
+		added = append(added, r)
+	}
+	for cur, s := range f.series {
+		cut := s[len(s)-1].Timestamp - f.keep
+		i := sort.Search(len(s), func(i int) bool { return s[i].Timestamp >= cut })
+		f.series[cur] = s[i:]
+	}
+	return added
+}
+
+func (f *fxRates) at(currency string, ts int64) (float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.series[currency]
+	i := sort.Search(len(s), func(i int) bool { return s[i].Timestamp > ts })
+	if i == 0 || ts-s[i-1].Timestamp > f.maxAge {
+		return 0, false
+	}
+	return s[i-1].Rate, true
+}
+
+// quoteConverter 周期表写入后把 USD 价格换算成其他计价货币.
+// fiat 按周期结束前最近的汇率换算, cross 为资产 id, 用两个资产同一周期的 USD 价格相除
+type quoteConverter struct {
+	fx    *fxRates
+	fiat  []string
+	cross []string
+	dst   ConvertStore
+	log   *slog.Logger
+}
+
+func newQuoteConverter(fx *fxRates, fiat, cross []string, dst ConvertStore) *quoteConverter {
+	for i := range fiat {
+		fiat[i] = strings.ToUpper(fiat[i])
+	}
+	return &quoteConverter{fx: fx, fiat: fiat, cross: cross, dst: dst, log: component("converted")}
+}
+
+func (c *quoteConverter) name() string {
+	return "converted"
+}
+
+func (c *quoteConverter) closed(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error {
+	var rows []*convertedRow
+	for _, dat := range batch {
+		rows = append(rows, c.convert(tbl, dat)...)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return c.dst.SaveConverted(ctx, rows...)
+}
+
+// amended 换算不依赖之前的周期, 重新换算修正的周期并替换
+func (c *quoteConverter) amended(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error {
+	var rows []*convertedRow
+	for _, dat := range batch {
+		rows = append(rows, c.convert(tbl, dat)...)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return c.dst.UpsertConverted(ctx, rows...)
+}
+
+func (c *quoteConverter) convert(tbl string, dat *kPriceCoinMarketCapList) []*convertedRow {
+	tf := timeframes[tbl]
+	end := bucketStart(tbl, dat.timestamp) + candleWidths[tbl] - 1
+	usd := make([]*candleRow, len(dat.list))
+	byID := make(map[string]*candleRow, len(dat.list))
+	for i := range dat.list {
+		usd[i] = newCandleRow(&dat.list[i], dat.timestamp, "")
+		byID[usd[i].AssetID] = usd[i]
+	}
+	var rows []*convertedRow
+	for _, cur := range c.fiat {
+		rate, ok := c.fx.at(cur, end)
+		if !ok {
+			fxMissing.inc(cur)
+			c.log.Debug("no fx rate", "currency", cur, "table", tbl, "timestamp", dat.timestamp)
+			continue
+		}
+		for _, r := range usd {
+			rows = append(rows, &convertedRow{AssetID: r.AssetID, Symbol: r.Symbol, Timeframe: tf, Quote: cur, Timestamp: r.Timestamp,
+				Open: r.PriceUSDFirst * rate, High: r.PriceUSDHigh * rate, Low: r.PriceUSDLow * rate, Close: r.PriceUSDLast * rate})
+		}
+	}
+	for _, id := range c.cross {
+		b := byID[id]
+		if b == nil || b.PriceUSDFirst <= 0 || b.PriceUSDHigh <= 0 || b.PriceUSDLow <= 0 || b.PriceUSDLast <= 0 {
+			continue
+		}
+		for _, r := range usd {
+			if r.AssetID == id {
+				continue
+			}
+			rows = append(rows, crossRow(r, b, tf))
+		}
+	}
+	return rows
+}
+
+// crossRow 开盘收盘为同一时刻两个价格之比. 两个高低点不一定在同一时刻, 没有逐笔数据
+// 无法得到比值真正的高低点, 取它能达到的范围: 最高 r.High/b.Low, 最低 r.Low/b.High
+func crossRow(r, b *candleRow, tf string) *convertedRow {
+	return &convertedRow{AssetID: r.AssetID, Symbol: r.Symbol, Timeframe: tf, Quote: b.Symbol, Timestamp: r.Timestamp,
+		Open: r.PriceUSDFirst / b.PriceUSDFirst, High: r.PriceUSDHigh / b.PriceUSDLow, Low: r.PriceUSDLow / b.PriceUSDHigh, Close: r.PriceUSDLast / b.PriceUSDLast}
+}
+
+// poll 立即抓取一次, 之后每 every 抓取一次, 新的汇率写入 fx_rates
+func (c *quoteConverter) poll(clk clock, p fxProvider, every time.Duration) {
+	fetch := func(ts int64) {
+		rates, err := p.rates(ts)
+		if err != nil {
+			c.log.Error("fx fetch failed", "err", err)
+			return
+		}
+		added := c.fx.add(rates...)
+		if len(added) == 0 {
+			return
+		}
+		if err := c.dst.SaveFXRates(context.Background(), added...); err != nil {
+			dbErrors.inc("save_fx_rates")
+			c.log.Error("save fx rates failed", "err", err)
+		}
+	}
+	fetch(clk.Now().Unix())
+	t := clk.NewTicker(every)
+	defer t.Stop()
+	for now := range t.C() {
+		fetch(now.Unix())
+	}
+}
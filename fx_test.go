@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFXProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	os.WriteFile(path, []byte("timestamp,currency,rate\n# ECB\n1514937600,eur,0.83\n1514941200,EUR,0.84\n1514937600,JPY,112.5\n"), 0o644)
+	p, err := parseFXProvider("file:"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 还没到时间的汇率不返回
+	if got, err := p.rates(1514940000); err != nil || len(got) != 2 || got[0] != (fxRate{"EUR", 1514937600, 0.83}) {
+		t.Fatalf("file: %+v, %v", got, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"rates": map[string]float64{"eur": 0.85, "bad": 0}})
+	}))
+	defer srv.Close()
+	p, err = parseFXProvider(srv.URL+"/?at=%d", newFetchClient("fx", time.Second, 0, newBreaker(10, time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.rates(1514937660); err != nil || len(got) != 1 || got[0] != (fxRate{"EUR", 1514937660, 0.85}) {
+		t.Fatalf("http: %+v, %v", got, err)
+	}
+	if _, err := parseFXProvider("rates.csv", nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestFXRates(t *testing.T) {
+	fx := newFXRates(time.Hour, 2*time.Hour)
+	if added := fx.add(fxRate{"EUR", 3600, 0.8}, fxRate{"EUR", 0, 0.7}, fxRate{"EUR", 3600, 0.9}); len(added) != 2 {
+		t.Fatalf("added %+v", added)
+	}
+	for _, c := range []struct {
+		ts   int64
+		want float64
+		ok   bool
+	}{
+		{-1, 0, false},
+		{0, 0.7, true},
+		{3599, 0.7, true},
+		{3600, 0.8, true},
+		{7200, 0.8, true},
+		{7201, 0, false},
+	} {
+		if got, ok := fx.at("EUR", c.ts); got != c.want || ok != c.ok {
+			t.Errorf("at(%d) = %v, %v", c.ts, got, ok)
+		}
+	}
+	// 超出 keep 的历史被丢掉
+	fx.add(fxRate{"EUR", 9000, 0.85})
+	if _, ok := fx.at("EUR", 1000); ok {
+		t.Error("old rate kept")
+	}
+}
+
+func TestQuoteConverter(t *testing.T) {
+	ctx := context.Background()
+	ts := int64(1514937610)
+	dat := newKPriceCoinMarketCapList([]priceCoinMarketCap{
+		{Id: "bitcoin", Symbol: "BTC", Name: "Bitcoin", PriceUSD: "15000", LastUpdated: "1514937600"},
+		{Id: "ethereum", Symbol: "ETH", Name: "Ethereum", PriceUSD: "1000", LastUpdated: "1514937600"},
+	}, ts)
+	dat.list[0].PriceUSDHigh.SetFloat64(16000)
+	dat.list[1].PriceUSDHigh.SetFloat64(1200)
+
+	store := newMemoryStore()
+	fx := newFXRates(time.Hour, time.Hour)
+	// 1 分钟周期结束前最近的汇率是 0.8, 之后的 0.9 不使用
+	fx.add(fxRate{"EUR", ts - 60, 0.5}, fxRate{"EUR", ts, 0.8}, fxRate{"EUR", ts + 60, 0.9})
+	c := newQuoteConverter(fx, []string{"eur", "jpy"}, []string{"ethereum", "litecoin"}, store)
+	if err := c.closed(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{dat}); err != nil {
+		t.Fatal(err)
+	}
+	got := store.Converted()
+	// JPY 没有汇率, litecoin 不在数据里, 都跳过
+	if len(got) != 3 {
+		t.Fatalf("%+v", got)
+	}
+	if r := got[0]; r.Quote != "EUR" || r.Timeframe != "1m" || r.Timestamp != ts || r.Open != 12000 || r.High != 12800 || r.Close != 12000 {
+		t.Errorf("eur: %+v", r)
+	}
+	if r := got[2]; r.AssetID != "bitcoin" || r.Quote != "ETH" || r.Open != 15 || r.Close != 15 || r.High != 16 || r.Low != 12.5 {
+		t.Errorf("cross: %+v", r)
+	}
+
+	// 修正的周期替换原来的换算结果
+	dat.list[0].PriceUSDLast.SetFloat64(16000)
+	if err := c.amended(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{dat}); err != nil {
+		t.Fatal(err)
+	}
+	got = store.Converted()
+	if len(got) != 3 || got[2].Quote != "ETH" || got[2].Close != 16 {
+		t.Errorf("amended: %+v", got)
+	}
+}
+
+func TestConvertStoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.InitConverted(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveFXRates(ctx, fxRate{"EUR", 1, 0.8}); err != nil {
+		t.Fatal(err)
+	}
+	r := &convertedRow{AssetID: "bitcoin", Symbol: "BTC", Timeframe: "1m", Quote: "EUR", Timestamp: 1, Open: 1, High: 2, Low: 0.5, Close: 1.5}
+	if err := store.SaveConverted(ctx, r, r); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := store.db.QueryRow("SELECT count(*) FROM converted_candles WHERE quote = 'EUR' AND high = 2;").Scan(&n); err != nil || n != 2 {
+		t.Errorf("%d rows, %v", n, err)
+	}
+	// Upsert 替换同键的全部行
+	r.High = 3
+	if err := store.UpsertConverted(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.QueryRow("SELECT count(*) FROM converted_candles WHERE high = 3;").Scan(&n); err != nil || n != 1 {
+		t.Errorf("upsert: %d rows, %v", n, err)
+	}
+	if err := store.db.QueryRow("SELECT count(*) FROM converted_candles;").Scan(&n); err != nil || n != 1 {
+		t.Errorf("upsert: %d rows in table, %v", n, err)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"market/candles"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	"按 rank 排序, 不传 ids 和 symbols 时返回前 first 个资产"
+	assets(ids: [ID!], symbols: [String!], first: Int = 100): [Asset!]!
+	asset(id: ID!): Asset
+}
+
+enum Timeframe {
+	M1
+	M5
+	M10
+	M15
+	M30
+	H1
+	D1
+	W1
+}
+
+type Asset {
+	id: ID!
+	name: String!
+	symbol: String!
+	rank: Int!
+	quote: Quote!
+	"[from, to) 内最后 last 个周期, 不传 from 时从 to 往前 last 个周期; group 默认为空串, 即采集程序写入的数据"
+	candles(timeframe: Timeframe!, from: Time, to: Time, last: Int = 100, group: String! = ""): [Candle!]!
+}
+
+type OHLC {
+	open: Float!
+	high: Float!
+	low: Float!
+	close: Float!
+}
+
+type Quote {
+	usd: Float!
+	btc: Float!
+	cny: Float!
+	lastUpdated: Time!
+	dayUSD: OHLC!
+	dayBTC: OHLC!
+	dayCNY: OHLC!
+	group: String!
+	stale: Boolean!
+}
+
+type Candle {
+	time: Time!
+	lastUpdated: Time!
+	usd: OHLC!
+	btc: OHLC!
+	cny: OHLC!
+	group: String!
+	stale: Boolean!
+	volume: Volume!
+}
+
+# 由成交数据汇总, 只抓取价格的来源为 0
+type Volume {
+	base: Float!
+	quote: Float!
+	trades: Int!
+	vwap: Float!
+}
+`
+
+var gqlTimeframes = map[string]candles.Timeframe{
+	"M1":  candles.Minute,
+	"M5":  candles.FiveMinutes,
+	"M10": candles.TenMinutes,
+	"M15": candles.FifteenMinutes,
+	"M30": candles.ThirtyMinutes,
+	"H1":  candles.Hour,
+	"D1":  candles.Day,
+	"W1":  candles.Week,
+}
+
+// graphqlHandler 每个请求一个 gqlRequest, 同一请求内的 candles 字段合并成一次查询
+type graphqlHandler struct {
+	store candles.Reader
+	clk   clock
+	// current coinmarketcapcurrent 中本程序写入的 _group, 即 --tblname
+	current string
+	maxCost int
+	relay   *relay.Handler
+}
+
+func newGraphQLHandler(store candles.Reader, clk clock, current string, maxCost int) *graphqlHandler {
+	schema := graphql.MustParseSchema(graphqlSchema, &gqlRoot{},
+		graphql.UseStringDescriptions(), graphql.MaxDepth(6), graphql.MaxQueryLength(8192))
+	return &graphqlHandler{store: store, clk: clk, current: current, maxCost: maxCost, relay: &relay.Handler{Schema: schema}}
+}
+
+type gqlRequestKey struct{}
+
+func (h *graphqlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &gqlRequest{h: h, loads: make(map[gqlCandleKey][]*gqlCandleLoad)}
+	h.relay.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gqlRequestKey{}, req)))
+}
+
+// gqlRequest 一次请求内的状态: 已经出现的资产, 累计代价和 candles 的批量查询
+type gqlRequest struct {
+	h      *graphqlHandler
+	mu     sync.Mutex
+	assets []string
+	seen   map[string]bool
+	cost   int
+	loads  map[gqlCandleKey][]*gqlCandleLoad
+}
+
+func requestFrom(ctx context.Context) *gqlRequest {
+	return ctx.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// charge 累计代价, 超过上限时后续字段返回错误
+func (q *gqlRequest) charge(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cost += n
+	if q.cost > q.h.maxCost {
+		return fmt.Errorf("query cost %d exceeds the limit of %d", q.cost, q.h.maxCost)
+	}
+	return nil
+}
+
+func (q *gqlRequest) addAssets(ids []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.seen == nil {
+		q.seen = make(map[string]bool)
+	}
+	for _, id := range ids {
+		if !q.seen[id] {
+			q.seen[id] = true
+			q.assets = append(q.assets, id)
+		}
+	}
+}
+
+type gqlCandleKey struct {
+	table    string
+	from, to int64
+	last     int
+	group    string
+}
+
+// gqlCandleLoad 一次批量查询, 覆盖 ids 中的全部资产
+type gqlCandleLoad struct {
+	ids  map[string]bool
+	done chan struct{}
+	rows map[string][]candles.Candle
+	err  error
+}
+
+// candles 同一组参数第一次被请求时, 一次查出本请求中所有资产的数据
+func (q *gqlRequest) candles(ctx context.Context, key gqlCandleKey, assetID string) ([]candles.Candle, error) {
+	q.mu.Lock()
+	for _, l := range q.loads[key] {
+		if l.ids[assetID] {
+			q.mu.Unlock()
+			<-l.done
+			return l.rows[assetID], l.err
+		}
+	}
+	// 资产在已有的批次之后才出现, 为还没覆盖的资产再查一次
+	l := &gqlCandleLoad{ids: make(map[string]bool), done: make(chan struct{})}
+	var ids []string
+	add := func(id string) {
+		if l.ids[id] {
+			return
+		}
+		for _, prev := range q.loads[key] {
+			if prev.ids[id] {
+				return
+			}
+		}
+		l.ids[id] = true
+		ids = append(ids, id)
+	}
+	for _, id := range q.assets {
+		add(id)
+	}
+	add(assetID)
+	q.loads[key] = append(q.loads[key], l)
+	q.mu.Unlock()
+
+	defer close(l.done)
+	if l.err = q.charge(len(ids) * key.last); l.err != nil {
+		return nil, l.err
+	}
+	graphqlLoads.inc(key.table)
+	l.rows = make(map[string][]candles.Candle, len(ids))
+	l.err = q.h.store.ScanCandles(ctx, candleQuery{Table: key.table, AssetIDs: ids, Groups: []string{key.group}, From: key.from, To: key.to}, func(r *candleRow) error {
+		cs := append(l.rows[r.AssetID], r.Candle())
+		// 只保留最后 last 个
+		if len(cs) > key.last {
+			cs = cs[1:]
+		}
+		l.rows[r.AssetID] = cs
+		return nil
+	})
+	return l.rows[assetID], l.err
+}
+
+type gqlRoot struct{}
+
+// quotes coinmarketcapcurrent 中本程序写入的全部资产
+func (r *gqlRoot) quotes(ctx context.Context, q *gqlRequest, ids []string) ([]candles.Quote, error) {
+	graphqlLoads.inc(coinmarketcapcurrent)
+	var ret []candles.Quote
+	err := q.h.store.ScanCandles(ctx, candleQuery{Table: coinmarketcapcurrent, AssetIDs: ids, Groups: []string{q.h.current}}, func(row *candleRow) error {
+		ret = append(ret, row.Quote())
+		return nil
+	})
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Rank < ret[j].Rank })
+	return ret, err
+}
+
+func (r *gqlRoot) Assets(ctx context.Context, args struct {
+	IDs     *[]graphql.ID
+	Symbols *[]string
+	First   int32
+}) ([]*gqlAsset, error) {
+	q := requestFrom(ctx)
+	var ids []string
+	if args.IDs != nil {
+		for _, id := range *args.IDs {
+			ids = append(ids, string(id))
+		}
+	}
+	quotes, err := r.quotes(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	var symbols map[string]bool
+	if args.Symbols != nil {
+		symbols = make(map[string]bool)
+		for _, s := range *args.Symbols {
+			symbols[s] = true
+		}
+	}
+	var ret []*gqlAsset
+	for _, quote := range quotes {
+		if len(ret) == int(args.First) {
+			break
+		}
+		if symbols == nil || symbols[quote.Symbol] {
+			ret = append(ret, &gqlAsset{quote: quote})
+		}
+	}
+	if err := q.charge(len(ret)); err != nil {
+		return nil, err
+	}
+	ids = ids[:0]
+	for _, a := range ret {
+		ids = append(ids, a.quote.AssetID)
+	}
+	q.addAssets(ids)
+	return ret, nil
+}
+
+func (r *gqlRoot) Asset(ctx context.Context, args struct{ ID graphql.ID }) (*gqlAsset, error) {
+	q := requestFrom(ctx)
+	quotes, err := r.quotes(ctx, q, []string{string(args.ID)})
+	if err != nil || len(quotes) == 0 {
+		return nil, err
+	}
+	if err := q.charge(1); err != nil {
+		return nil, err
+	}
+	q.addAssets([]string{quotes[0].AssetID})
+	return &gqlAsset{quote: quotes[0]}, nil
+}
+
+type gqlAsset struct {
+	quote candles.Quote
+}
+
+func (a *gqlAsset) ID() graphql.ID   { return graphql.ID(a.quote.AssetID) }
+func (a *gqlAsset) Name() string     { return a.quote.Name }
+func (a *gqlAsset) Symbol() string   { return a.quote.Symbol }
+func (a *gqlAsset) Rank() int32      { return int32(a.quote.Rank) }
+func (a *gqlAsset) Quote() *gqlQuote { return &gqlQuote{a.quote} }
+
+func (a *gqlAsset) Candles(ctx context.Context, args struct {
+	Timeframe string
+	From      *graphql.Time
+	To        *graphql.Time
+	Last      int32
+	Group     string
+}) ([]*gqlCandle, error) {
+	if args.Last <= 0 {
+		return nil, fmt.Errorf("last must be positive")
+	}
+	q := requestFrom(ctx)
+	tf := gqlTimeframes[args.Timeframe]
+	tbl, err := tf.Table()
+	if err != nil {
+		return nil, err
+	}
+	key := gqlCandleKey{table: tbl, last: int(args.Last), group: args.Group}
+	to := q.h.clk.Now()
+	if args.To != nil {
+		to = args.To.Time
+		key.to = to.Unix()
+	}
+	if args.From != nil {
+		key.from = args.From.Unix()
+	} else {
+		// 多取一个周期, 周线的起点不是宽度的整数倍
+		key.from = to.Unix() - (int64(args.Last)+1)*candleWidths[tbl]
+	}
+	cs, err := q.candles(ctx, key, a.quote.AssetID)
+	ret := make([]*gqlCandle, len(cs))
+	for i := range cs {
+		ret[i] = &gqlCandle{cs[i]}
+	}
+	return ret, err
+}
+
+type gqlOHLC struct {
+	v candles.OHLC
+}
+
+func (o *gqlOHLC) Open() float64  { return o.v.Open }
+func (o *gqlOHLC) High() float64  { return o.v.High }
+func (o *gqlOHLC) Low() float64   { return o.v.Low }
+func (o *gqlOHLC) Close() float64 { return o.v.Close }
+
+type gqlVolume struct {
+	v candles.Volume
+}
+
+func (v *gqlVolume) Base() float64  { return v.v.Base }
+func (v *gqlVolume) Quote() float64 { return v.v.Quote }
+func (v *gqlVolume) Trades() int32  { return int32(v.v.Trades) }
+func (v *gqlVolume) VWAP() float64  { return v.v.VWAP }
+
+type gqlQuote struct {
+	q candles.Quote
+}
+
+func (q *gqlQuote) USD() float64              { return q.q.USD }
+func (q *gqlQuote) BTC() float64              { return q.q.BTC }
+func (q *gqlQuote) CNY() float64              { return q.q.CNY }
+func (q *gqlQuote) LastUpdated() graphql.Time { return graphql.Time{Time: q.q.LastUpdated} }
+func (q *gqlQuote) DayUSD() *gqlOHLC          { return &gqlOHLC{q.q.Day.USD} }
+func (q *gqlQuote) DayBTC() *gqlOHLC          { return &gqlOHLC{q.q.Day.BTC} }
+func (q *gqlQuote) DayCNY() *gqlOHLC          { return &gqlOHLC{q.q.Day.CNY} }
+func (q *gqlQuote) Group() string             { return q.q.Group }
+func (q *gqlQuote) Stale() bool               { return q.q.Stale }
+
+type gqlCandle struct {
+	c candles.Candle
+}
+
+func (c *gqlCandle) Time() graphql.Time        { return graphql.Time{Time: c.c.Time} }
+func (c *gqlCandle) LastUpdated() graphql.Time { return graphql.Time{Time: c.c.LastUpdated} }
+func (c *gqlCandle) USD() *gqlOHLC             { return &gqlOHLC{c.c.USD} }
+func (c *gqlCandle) BTC() *gqlOHLC             { return &gqlOHLC{c.c.BTC} }
+func (c *gqlCandle) CNY() *gqlOHLC             { return &gqlOHLC{c.c.CNY} }
+func (c *gqlCandle) Group() string             { return c.c.Group }
+func (c *gqlCandle) Stale() bool               { return c.c.Stale }
+func (c *gqlCandle) Volume() *gqlVolume        { return &gqlVolume{c.c.Volume} }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingReader 记录每张表被查询的次数
+type countingReader struct {
+	*memoryStore
+	mu    sync.Mutex
+	scans map[string]int
+}
+
+func (c *countingReader) ScanCandles(ctx context.Context, q candleQuery, fn func(*candleRow) error) error {
+	c.mu.Lock()
+	c.scans[q.Table]++
+	c.mu.Unlock()
+	return c.memoryStore.ScanCandles(ctx, q, fn)
+}
+
+func graphqlFixture(t *testing.T) *countingReader {
+	ctx := context.Background()
+	store := newMemoryStore()
+	base := int64(1514937600)
+	for i, asset := range []string{"bitcoin", "ethereum", "litecoin"} {
+		cur := testTick(base, float64(100*(i+1)))
+		cur.list[0].Id, cur.list[0].Symbol = asset, strings.ToUpper(asset[:3])
+		cur.list[0].Rank.SetInt64(int64(i + 1))
+		// 其它采集程序写入 coinmarketcapcurrent 的数据不应出现在 quote 中
+		other := testTick(base, 999)
+		other.list[0].Id, other.list[0].Symbol = asset, cur.list[0].Symbol
+		other.list[0].Rank.SetInt64(int64(i + 1))
+		store.SaveCandles(ctx, coinmarketcapcurrent, "binance", other)
+		store.SaveCandles(ctx, coinmarketcapcurrent, "pricecoinmarketcap", cur)
+		for m := int64(0); m < 3; m++ {
+			x := testTick(base+m*60, float64(100*(i+1))+float64(m))
+			x.list[0].Id, x.list[0].Symbol = asset, cur.list[0].Symbol
+			store.SaveCandles(ctx, coinmarketcapmin, "", x)
+		}
+		store.SaveCandles(ctx, coinmarketcapday, "", cur)
+	}
+	return &countingReader{memoryStore: store, scans: make(map[string]int)}
+}
+
+func graphqlPost(t *testing.T, h http.Handler, query string) map[string]interface{} {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.Bytes())
+	}
+	return resp
+}
+
+func TestGraphQLBatchesCandles(t *testing.T) {
+	store := graphqlFixture(t)
+	// 不传 group 时不返回来源自己的周期
+	src := testTick(1514937600+2*60, 999)
+	store.SaveCandles(context.Background(), coinmarketcapmin, "binance", src)
+	// 不传 from 时从 now 往前取 last+1 个周期
+	now := time.Unix(1514937600+3*60, 0)
+	h := newGraphQLHandler(store, newFakeClock(now), "pricecoinmarketcap", 1000)
+	resp := graphqlPost(t, h, `{
+		assets(first: 2) {
+			id
+			symbol
+			quote { usd dayUSD { open } }
+			m1: candles(timeframe: M1, last: 2) { time usd { close } }
+			d1: candles(timeframe: D1, last: 1, group: "") { usd { close } }
+		}
+	}`)
+	if resp["errors"] != nil {
+		t.Fatal(resp["errors"])
+	}
+	b, _ := json.Marshal(resp["data"])
+	want := `{"assets":[` +
+		`{"d1":[{"usd":{"close":100}}],"id":"bitcoin","m1":[{"time":"2018-01-03T00:01:00Z","usd":{"close":101}},{"time":"2018-01-03T00:02:00Z","usd":{"close":102}}],"quote":{"dayUSD":{"open":100},"usd":100},"symbol":"BIT"},` +
+		`{"d1":[{"usd":{"close":200}}],"id":"ethereum","m1":[{"time":"2018-01-03T00:01:00Z","usd":{"close":201}},{"time":"2018-01-03T00:02:00Z","usd":{"close":202}}],"quote":{"dayUSD":{"open":200},"usd":200},"symbol":"ETH"}]}`
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+	// 每个周期只查一次, 与资产个数无关
+	for tbl, n := range map[string]int{coinmarketcapcurrent: 1, coinmarketcapmin: 1, coinmarketcapday: 1} {
+		if store.scans[tbl] != n {
+			t.Errorf("%v scanned %d times, want %d", tbl, store.scans[tbl], n)
+		}
+	}
+}
+
+func TestGraphQLCostLimit(t *testing.T) {
+	store := graphqlFixture(t)
+	h := newGraphQLHandler(store, realClock{}, "pricecoinmarketcap", 100)
+	// 3 个资产 + 3 * 50 个周期
+	resp := graphqlPost(t, h, `{ assets { id candles(timeframe: H1, last: 50) { time } } }`)
+	errs, _ := json.Marshal(resp["errors"])
+	if !strings.Contains(string(errs), "exceeds the limit of 100") {
+		t.Errorf("errors: %s", errs)
+	}
+	if store.scans[coinmarketcaphour] != 0 {
+		t.Errorf("candles loaded despite the limit")
+	}
+
+	resp = graphqlPost(t, h, `{ asset(id: "litecoin") { symbol rank candles(timeframe: M1, from: "2018-01-03T00:00:00Z", last: 50) { time } } }`)
+	if resp["errors"] != nil {
+		t.Fatal(resp["errors"])
+	}
+	b, _ := json.Marshal(resp["data"])
+	if !strings.HasPrefix(string(b), `{"asset":{"candles":[{"time":"2018-01-03T00:00:00Z"}`) || !strings.Contains(string(b), `"rank":3,"symbol":"LIT"`) {
+		t.Errorf("got %s", b)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"market/candles"
+	"market/proto/marketpb"
+
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC 服务, 消息和接口由 proto/market.proto 生成在 proto/marketpb.
+// 修改 proto 后重新生成:
+//go:generate protoc --go_out=. --go_opt=module=market --go-grpc_out=. --go-grpc_opt=module=market proto/market.proto
+
+// candleHub 把实时汇总中正在进行的周期分发给 SubscribeCandles 的订阅者
+type candleHub struct {
+	mu   sync.Mutex
+	subs map[*candleSub]struct{}
+}
+
+type candleSub struct {
+	assets map[string]bool
+	ch     chan []candles.Candle
+}
+
+func newCandleHub() *candleHub {
+	return &candleHub{subs: make(map[*candleSub]struct{})}
+}
+
+// subscribe assetIDs 为空时订阅全部资产
+func (h *candleHub) subscribe(assetIDs []string) *candleSub {
+	s := &candleSub{ch: make(chan []candles.Candle, 16)}
+	if len(assetIDs) > 0 {
+		s.assets = make(map[string]bool, len(assetIDs))
+		for _, id := range assetIDs {
+			s.assets[id] = true
+		}
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *candleHub) unsubscribe(s *candleSub) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// publish 不阻塞汇总, 订阅者跟不上时丢弃这次更新, 下一次更新带的是完整的最新状态
+func (h *candleHub) publish(rows []*candleRow) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		var cs []candles.Candle
+		for _, r := range rows {
+			if s.assets == nil || s.assets[r.AssetID] {
+				cs = append(cs, r.Candle())
+			}
+		}
+		if len(cs) == 0 {
+			continue
+		}
+		select {
+		case s.ch <- cs:
+		default:
+			grpcStreamDropped.inc()
+		}
+	}
+}
+
+// publishList 把 realTimeAggregation 的当前状态发给订阅者
+func (h *candleHub) publishList(group string, x *kPriceCoinMarketCapList) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	n := len(h.subs)
+	h.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	rows := make([]*candleRow, len(x.list))
+	for i := range x.list {
+		rows[i] = newCandleRow(&x.list[i], x.timestamp, group)
+	}
+	h.publish(rows)
+}
+
+type grpcServer struct {
+	marketpb.UnimplementedMarketServiceServer
+	client *candles.Client
+	hub    *candleHub
+	log    *slog.Logger
+}
+
+func newGRPCServer(r candles.Reader, hub *candleHub) *grpcServer {
+	return &grpcServer{client: candles.New(r), hub: hub, log: component("grpc")}
+}
+
+func (s *grpcServer) GetCandles(ctx context.Context, req *marketpb.GetCandlesRequest) (*marketpb.GetCandlesResponse, error) {
+	tf, ok := pbTimeframes[req.Timeframe]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown timeframe %d", req.Timeframe)
+	}
+	if req.AssetId == "" {
+		return nil, status.Error(codes.InvalidArgument, "asset_id and timeframe are required")
+	}
+	var from, to time.Time
+	if req.From > 0 {
+		from = time.Unix(req.From, 0)
+	}
+	if req.To > 0 {
+		to = time.Unix(req.To, 0)
+	}
+	cs, err := s.client.GetCandles(ctx, req.AssetId, tf, from, to)
+	if err != nil {
+		return nil, err
+	}
+	resp := &marketpb.GetCandlesResponse{Candles: make([]*marketpb.Candle, len(cs))}
+	for i := range cs {
+		resp.Candles[i] = pbCandle(&cs[i])
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetCurrent(ctx context.Context, req *marketpb.GetCurrentRequest) (*marketpb.GetCurrentResponse, error) {
+	qs, err := s.client.GetCurrent(ctx, req.AssetIds...)
+	if err != nil {
+		return nil, err
+	}
+	resp := &marketpb.GetCurrentResponse{Quotes: make([]*marketpb.Quote, len(qs))}
+	for i := range qs {
+		resp.Quotes[i] = pbQuote(&qs[i])
+	}
+	return resp, nil
+}
+
+// SubscribeCandles 一直推送到客户端断开
+func (s *grpcServer) SubscribeCandles(req *marketpb.SubscribeCandlesRequest, stream grpc.ServerStreamingServer[marketpb.Candle]) error {
+	sub := s.hub.subscribe(req.AssetIds)
+	defer s.hub.unsubscribe(sub)
+	// 先发响应头, 客户端不用等到第一次更新才知道订阅成功
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case cs := <-sub.ch:
+			for i := range cs {
+				if err := stream.Send(pbCandle(&cs[i])); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// done 按方法和状态码计数, 不是 status 的错误记日志后按 Internal 返回
+func (s *grpcServer) done(fullMethod string, err error) error {
+	method := path.Base(fullMethod)
+	if _, ok := status.FromError(err); !ok {
+		s.log.Error("call failed", "method", method, "err", err)
+		err = status.Error(codes.Internal, err.Error())
+	}
+	grpcRequests.inc(method, strconv.Itoa(int(status.Code(err))))
+	return err
+}
+
+// newServer 注册好服务, 只接受 plaintext 连接
+func (s *grpcServer) newServer() *grpc.Server {
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			resp, err := handler(ctx, req)
+			return resp, s.done(info.FullMethod, err)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return s.done(info.FullMethod, handler(srv, ss))
+		}),
+	)
+	marketpb.RegisterMarketServiceServer(gs, s)
+	return gs
+}
+
+func serveGRPC(addr string, s *grpcServer) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.newServer().Serve(lis)
+}
+
+// pollCurrent 独立运行时没有 realTimeAggregation, 改为轮询 coinmarketcapcurrent,
+// 只推送有变化的资产
+func pollCurrent(ctx context.Context, clk clock, store candles.Reader, hub *candleHub, every time.Duration) {
+	plog := component("grpc", "table", coinmarketcapcurrent)
+	last := make(map[string]candleRow)
+	ticker := clk.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		var changed []*candleRow
+		err := store.ScanCandles(ctx, candleQuery{Table: coinmarketcapcurrent}, func(r *candleRow) error {
+			key := r.Group + "\xff" + r.AssetID
+			if prev, ok := last[key]; !ok || prev != *r {
+				last[key] = *r
+				changed = append(changed, r)
+			}
+			return nil
+		})
+		if err != nil {
+			plog.Error("poll failed", "err", err)
+			continue
+		}
+		hub.publish(changed)
+	}
+}
+
+var grpcCommand = cli.Command{
+	Name:  "grpc",
+	Usage: "serve candle queries over gRPC from the database; to stream from the collector itself use --grpcaddr instead",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "addr",
+			Value: ":9090",
+			Usage: "listen address, plaintext HTTP/2",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Value: time.Second,
+			Usage: "how often coinmarketcapcurrent is read for SubscribeCandles",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		store, err := openStorage(c)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		hub := newCandleHub()
+		go pollCurrent(context.Background(), realClock{}, store, hub, c.Duration("poll"))
+		component("grpc").Info("listening", "addr", c.String("addr"))
+		return serveGRPC(c.String("addr"), newGRPCServer(store, hub))
+	},
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"market/proto/marketpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func startGRPC(t *testing.T, store *memoryStore, hub *candleHub) marketpb.MarketServiceClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := newGRPCServer(store, hub).newServer()
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return marketpb.NewMarketServiceClient(conn)
+}
+
+func TestGRPCUnary(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	for i := int64(0); i < 3; i++ {
+		store.SaveCandles(ctx, coinmarketcapmin, "", testTick(1514937600+i*60, float64(10+i)))
+	}
+	store.ReplaceCurrent(ctx, "pricecoinmarketcap", testTick(1514937600, 42))
+	client := startGRPC(t, store, newCandleHub())
+
+	resp, err := client.GetCandles(ctx, &marketpb.GetCandlesRequest{AssetId: "bitcoin", Timeframe: marketpb.Timeframe_TIMEFRAME_1M, From: 1514937660})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs := resp.Candles; len(cs) != 2 || cs[0].Usd.Close != 11 || cs[1].Usd.Close != 12 || cs[0].Time != 1514937660 {
+		t.Errorf("candles %v", cs)
+	}
+
+	cur, err := client.GetCurrent(ctx, &marketpb.GetCurrentRequest{AssetIds: []string{"bitcoin"}})
+	if err != nil || len(cur.Quotes) != 1 || cur.Quotes[0].Group != "pricecoinmarketcap" {
+		t.Errorf("GetCurrent: %v, %v", cur, err)
+	}
+
+	_, err = client.GetCandles(ctx, &marketpb.GetCandlesRequest{AssetId: "bitcoin", Timeframe: 42})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown timeframe: %v", err)
+	}
+	_, err = client.GetCandles(ctx, &marketpb.GetCandlesRequest{Timeframe: marketpb.Timeframe_TIMEFRAME_1M})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("missing asset_id: %v", err)
+	}
+}
+
+// TestGRPCWireFormat 固定一条 Candle 的编码, proto 字段编号改动时会失败
+func TestGRPCWireFormat(t *testing.T) {
+	b, err := proto.Marshal(&marketpb.Candle{AssetId: "bitcoin", Time: 1514937600, Usd: &marketpb.OHLC{Close: 1}, Stale: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x0a, 0x07, 'b', 'i', 't', 'c', 'o', 'i', 'n', // 1: asset_id
+		0x28, 0x80, 0xba, 0xb0, 0xd2, 0x05, // 5: time
+		0x3a, 0x09, 0x21, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // 7: usd.close
+		0x58, 0x01, // 11: stale
+	}
+	if string(b) != string(want) {
+		t.Errorf("got % x\nwant % x", b, want)
+	}
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	hub := newCandleHub()
+	client := startGRPC(t, newMemoryStore(), hub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.SubscribeCandles(ctx, &marketpb.SubscribeCandlesRequest{AssetIds: []string{"bitcoin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 收到响应头时订阅已经建立
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	x := testTick(1514937600, 10)
+	eth := testTick(1514937600, 1)
+	eth.list[0].Id = "ethereum"
+	x.list = append(x.list, eth.list...)
+	for _, usd := range []float64{10, 11} {
+		x.list[0].PriceUSDLast.SetFloat64(usd)
+		hub.publishList("pricecoinmarketcap", x)
+		c, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.AssetId != "bitcoin" || c.Time != 1514937600 || c.Usd.Close != usd {
+			t.Errorf("got %v, want bitcoin close %v", c, usd)
+		}
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mu.Lock()
+		n := len(hub.subs)
+		hub.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber not removed after cancel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type checkResult struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Latest    int64  `json:"latest,omitempty"`
+	Age       string `json:"age,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks,omitempty"`
+}
+
+// health 提供 /healthz 和 /readyz
+type health struct {
+	clk   clock
+	store storage
+	// 表名 -> 最新一行允许的最大延迟
+	freshness map[string]time.Duration
+	timeout   time.Duration
+}
+
+func newHealth(clk clock, store storage, current, min time.Duration) *health {
+	return &health{
+		clk:   clk,
+		store: store,
+		freshness: map[string]time.Duration{
+			coinmarketcapcurrent: current,
+			coinmarketcapmin:     min,
+		},
+		timeout: 3 * time.Second,
+	}
+}
+
+func (h *health) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.live)
+	mux.HandleFunc("/readyz", h.ready)
+}
+
+func (h *health) live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, healthReport{Status: "ok"})
+}
+
+func (h *health) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok"}
+	db := checkResult{Name: "db", OK: true}
+	if err := h.store.Ping(ctx); err != nil {
+		db.OK = false
+		db.Error = err.Error()
+	}
+	report.Checks = append(report.Checks, db)
+	if db.OK {
+		for _, tbl := range []string{coinmarketcapcurrent, coinmarketcapmin} {
+			report.Checks = append(report.Checks, h.checkFresh(ctx, tbl, h.freshness[tbl]))
+		}
+	}
+	for _, c := range report.Checks {
+		if !c.OK {
+			report.Status = "fail"
+		}
+	}
+	writeReport(w, report)
+}
+
+func (h *health) checkFresh(ctx context.Context, tbl string, threshold time.Duration) checkResult {
+	ret := checkResult{Name: tbl, Threshold: threshold.String()}
+	latest, ok, err := h.store.LatestTimestamp(ctx, tbl)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	if !ok {
+		ret.Error = "no rows"
+		return ret
+	}
+	age := h.clk.Now().Sub(time.Unix(latest, 0))
+	ret.Latest = latest
+	ret.Age = age.Truncate(time.Second).String()
+	if age > threshold {
+		ret.Error = "stale"
+		return ret
+	}
+	ret.OK = true
+	return ret
+}
+
+func writeReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type pingFailStore struct {
+	*memoryStore
+}
+
+func (s pingFailStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func readyz(t *testing.T, h *health) (int, healthReport) {
+	t.Helper()
+	mux := http.NewServeMux()
+	h.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, report
+}
+
+func TestReadyz(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1514937600, 0)
+	clk := newFakeClock(start)
+	store := newMemoryStore()
+	h := newHealth(clk, store, time.Minute, 3*time.Minute)
+
+	// 空表不算就绪
+	if code, report := readyz(t, h); code != http.StatusServiceUnavailable || report.Status != "fail" || report.Checks[1].Error != "no rows" {
+		t.Errorf("empty: %v %+v", code, report)
+	}
+
+	store.ReplaceCurrent(ctx, "pricecoinmarketcap", testTick(start.Unix(), 1))
+	store.SaveCandles(ctx, coinmarketcapmin, "", testTick(start.Unix()-60, 1))
+	if code, report := readyz(t, h); code != http.StatusOK || report.Status != "ok" || len(report.Checks) != 3 {
+		t.Errorf("fresh: %v %+v", code, report)
+	}
+
+	// 2 分钟后 current 超过 1 分钟的阈值, 分钟表还在 3 分钟内
+	clk.Advance(2 * time.Minute)
+	code, report := readyz(t, h)
+	if code != http.StatusServiceUnavailable || report.Status != "fail" {
+		t.Fatalf("stale current: %v %+v", code, report)
+	}
+	if c := report.Checks[1]; c.Name != coinmarketcapcurrent || c.OK || c.Error != "stale" || c.Age != "2m0s" || c.Threshold != "1m0s" {
+		t.Errorf("current check %+v", c)
+	}
+	if c := report.Checks[2]; c.Name != coinmarketcapmin || !c.OK {
+		t.Errorf("min check %+v", c)
+	}
+
+	// current 恢复, 分钟表超过 3 分钟
+	store.ReplaceCurrent(ctx, "pricecoinmarketcap", testTick(clk.Now().Unix(), 1))
+	clk.Advance(time.Minute)
+	if code, report := readyz(t, h); code != http.StatusServiceUnavailable || !report.Checks[1].OK || report.Checks[2].OK || report.Checks[2].Error != "stale" {
+		t.Errorf("stale min: %v %+v", code, report)
+	}
+
+	// 数据库不通时不再检查表
+	h = newHealth(clk, pingFailStore{store}, time.Minute, 3*time.Minute)
+	code, report = readyz(t, h)
+	if code != http.StatusServiceUnavailable || len(report.Checks) != 1 || report.Checks[0].Name != "db" || report.Checks[0].Error != "connection refused" {
+		t.Errorf("ping failure: %v %+v", code, report)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// 每个事务写入的行数
+const importBatch = 1000
+
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "import historical OHLC candles from a csv file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "table",
+			Usage: "candle table, e.g. coinmarketcapday",
+		},
+		&cli.StringFlag{
+			Name:  "interval",
+			Usage: "candle interval instead of --table: 1m, 5m, 10m, 15m, 30m, 1h, 1d or 1w",
+		},
+		&cli.StringFlag{
+			Name:  "file",
+			Value: "-",
+			Usage: "csv file with a header row, - for stdin",
+		},
+		&cli.StringFlag{
+			Name:  "map",
+			Usage: "field=header pairs, fields: timestamp, symbol, asset_id, open, high, low, close, <currency>_open, <currency>_high, <currency>_low, <currency>_close, name, rank, volume, quote_volume, trades; unmapped fields use the header of the same name",
+		},
+		&cli.StringFlag{
+			Name:  "quote",
+			Value: "usd",
+			Usage: "quote currency of the open, high, low and close columns: usd, btc or cny; the other two currencies are required as <currency>_open, <currency>_high, <currency>_low and <currency>_close",
+		},
+		&cli.StringFlag{
+			Name:  "group",
+			Usage: "_group written for every row, usually the data vendor",
+		},
+		&cli.StringFlag{
+			Name:  "timeformat",
+			Value: "auto",
+			Usage: "timestamp format: auto (unix seconds, RFC 3339 or 2006-01-02), unixms or a Go time layout",
+		},
+		&cli.BoolFlag{
+			Name:  "skipinvalid",
+			Usage: "skip rows failing validation instead of stopping",
+		},
+	},
+	Action: importAction,
+}
+
+var importFields = []string{"timestamp", "symbol", "asset_id", "open", "high", "low", "close", "name", "rank", "volume", "quote_volume", "trades"}
+
+// importRequired 必须能在表头中找到的字段. asset_id 不能从 symbol 推出, 同一 symbol 可能是不同资产
+var importRequired = map[string]bool{"timestamp": true, "symbol": true, "asset_id": true, "open": true, "high": true, "low": true, "close": true}
+
+var importQuotes = []string{"usd", "btc", "cny"}
+
+// ohlcFields 币种的开高低收字段, --quote 的币种用不带前缀的 open, high, low, close
+func ohlcFields(quote, currency string) []string {
+	if quote == currency {
+		return []string{"open", "high", "low", "close"}
+	}
+	return []string{currency + "_open", currency + "_high", currency + "_low", currency + "_close"}
+}
+
+// csvImporter 把一行 csv 转成 candleRow
+type csvImporter struct {
+	index      map[string]int
+	quote      string
+	group      string
+	timeformat string
+}
+
+func newCSVImporter(header []string, mapping, quote, group, timeformat string) (*csvImporter, error) {
+	switch quote {
+	case "usd", "btc", "cny":
+	default:
+		return nil, fmt.Errorf("unsupported quote %q", quote)
+	}
+	if group == "" {
+		return nil, fmt.Errorf("--group is required")
+	}
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	// 周期表每一行都有三种币种的价格, 缺少的币种不能写成 0
+	fields := append([]string(nil), importFields...)
+	required := make(map[string]bool, len(importRequired))
+	for f := range importRequired {
+		required[f] = true
+	}
+	for _, c := range importQuotes {
+		if c != quote {
+			for _, f := range ohlcFields(quote, c) {
+				fields = append(fields, f)
+				required[f] = true
+			}
+		}
+	}
+	names := make(map[string]string, len(fields))
+	for _, f := range fields {
+		names[f] = f
+	}
+	for _, kv := range strings.Split(mapping, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return nil, fmt.Errorf("map %q: want field=header", kv)
+		}
+		if _, ok := names[p[0]]; !ok {
+			return nil, fmt.Errorf("map %q: unknown field %q", kv, p[0])
+		}
+		names[p[0]] = p[1]
+	}
+	im := &csvImporter{index: make(map[string]int), quote: quote, group: group, timeformat: timeformat}
+	for _, f := range fields {
+		i, ok := columns[strings.ToLower(strings.TrimSpace(names[f]))]
+		if !ok {
+			if required[f] {
+				return nil, fmt.Errorf("no column %q for %v", names[f], f)
+			}
+			continue
+		}
+		im.index[f] = i
+	}
+	return im, nil
+}
+
+func (im *csvImporter) field(rec []string, f string) (string, bool) {
+	i, ok := im.index[f]
+	if !ok || i >= len(rec) {
+		return "", false
+	}
+	return strings.TrimSpace(rec[i]), true
+}
+
+var numberReplacer = strings.NewReplacer(",", "", "$", "")
+
+func (im *csvImporter) number(rec []string, f string) (float64, error) {
+	s, _ := im.field(rec, f)
+	v, err := strconv.ParseFloat(numberReplacer.Replace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v: invalid number %q", f, s)
+	}
+	return v, nil
+}
+
+func (im *csvImporter) timestamp(s string) (int64, error) {
+	switch im.timeformat {
+	case "auto":
+		return parseTime(s)
+	case "unixms":
+		ms, err := strconv.ParseInt(s, 10, 64)
+		return ms / 1000, err
+	default:
+		t, err := time.ParseInLocation(im.timeformat, s, time.UTC)
+		return t.Unix(), err
+	}
+}
+
+// row 解析并校验一行
+func (im *csvImporter) row(rec []string) (*candleRow, error) {
+	r := &candleRow{Group: im.group}
+	s, _ := im.field(rec, "timestamp")
+	ts, err := im.timestamp(s)
+	if err != nil || ts <= 0 {
+		return nil, fmt.Errorf("timestamp: invalid time %q", s)
+	}
+	r.Timestamp, r.LastUpdated = ts, ts
+	if r.Symbol, _ = im.field(rec, "symbol"); r.Symbol == "" {
+		return nil, fmt.Errorf("symbol: empty")
+	}
+	if r.AssetID, _ = im.field(rec, "asset_id"); r.AssetID == "" {
+		return nil, fmt.Errorf("asset_id: empty")
+	}
+	if r.Name, _ = im.field(rec, "name"); r.Name == "" {
+		r.Name = r.Symbol
+	}
+	if s, ok := im.field(rec, "rank"); ok && s != "" {
+		if r.Rank, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("rank: invalid number %q", s)
+		}
+	}
+	for _, c := range importQuotes {
+		var ohlc [4]float64
+		for i, f := range ohlcFields(im.quote, c) {
+			if ohlc[i], err = im.number(rec, f); err != nil {
+				return nil, err
+			}
+		}
+		open, high, low, cls := ohlc[0], ohlc[1], ohlc[2], ohlc[3]
+		if low < 0 || high < open || high < cls || low > open || low > cls {
+			return nil, fmt.Errorf("%v: want high >= open/close >= low >= 0, got open %v high %v low %v close %v", c, open, high, low, cls)
+		}
+		switch c {
+		case "usd":
+			r.PriceUSDFirst, r.PriceUSDHigh, r.PriceUSDLow, r.PriceUSDLast = open, high, low, cls
+		case "btc":
+			r.PriceBTCFirst, r.PriceBTCHigh, r.PriceBTCLow, r.PriceBTCLast = open, high, low, cls
+		case "cny":
+			r.PriceCNYFirst, r.PriceCNYHigh, r.PriceCNYLow, r.PriceCNYLast = open, high, low, cls
+		}
+	}
+	// 成交量可选, 成交额按 USD 计, --quote 不是 usd 时忽略 quote_volume
+	for _, f := range []string{"volume", "quote_volume"} {
+		if s, ok := im.field(rec, f); !ok || s == "" || (f == "quote_volume" && im.quote != "usd") {
+			continue
+		}
+		v, err := im.number(rec, f)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("%v: invalid volume", f)
+		}
+		if f == "volume" {
+			r.VolumeBase = v
+		} else {
+			r.VolumeQuote = v
+		}
+	}
+	if s, ok := im.field(rec, "trades"); ok && s != "" {
+		if r.Trades, err = strconv.ParseInt(s, 10, 64); err != nil || r.Trades < 0 {
+			return nil, fmt.Errorf("trades: invalid number %q", s)
+		}
+	}
+	if r.VolumeBase > 0 {
+		r.VWAP = r.VolumeQuote / r.VolumeBase
+	}
+	return r, nil
+}
+
+func importAction(c *cli.Context) error {
+	q, err := candleQueryFlags(c)
+	if err != nil {
+		return err
+	}
+	if q.Table == coinmarketcapcurrent {
+		return fmt.Errorf("%v is written by the collector only", q.Table)
+	}
+	in := io.Reader(os.Stdin)
+	if path := c.String("file"); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	store, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Init(context.Background(), c.GlobalString("tblname")); err != nil {
+		return err
+	}
+	n, skipped, err := importCSV(context.Background(), store, q.Table, in, c.String("map"), c.String("quote"),
+		c.String("group"), c.String("timeformat"), c.Bool("skipinvalid"))
+	component("import").Info("done", "table", q.Table, "rows", n, "skipped", skipped)
+	return err
+}
+
+// importCSV 逐行读取, 每 importBatch 行提交一次.
+// 出错时已提交的批次保留, 因为写入是 upsert, 修正文件后可以重新导入
+func importCSV(ctx context.Context, store CandleStore, tbl string, in io.Reader, mapping, quote, group, timeformat string, skipInvalid bool) (n, skipped int, err error) {
+	r := csv.NewReader(in)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("header: %v", err)
+	}
+	im, err := newCSVImporter(header, mapping, quote, group, timeformat)
+	if err != nil {
+		return 0, 0, err
+	}
+	ilog := component("import", "table", tbl)
+	batch := make([]*candleRow, 0, importBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.UpsertCandles(ctx, tbl, batch...); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, skipped, err
+		}
+		line, _ := r.FieldPos(0)
+		row, err := im.row(rec)
+		if err != nil {
+			if !skipInvalid {
+				return n, skipped, fmt.Errorf("line %d: %v", line, err)
+			}
+			ilog.Warn("skip invalid row", "line", line, "err", err)
+			skipped++
+			continue
+		}
+		if batch = append(batch, row); len(batch) == importBatch {
+			if err := flush(); err != nil {
+				return n, skipped, err
+			}
+		}
+	}
+	return n, skipped, flush()
+}
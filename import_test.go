@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const vendorCSV = `Date,Ticker,Id,Open,High,Low,Close,Volume,BTC Open,BTC High,BTC Low,BTC Close,CNY Open,CNY High,CNY Low,CNY Close
+2018-01-01,BTC,bitcoin,"13,850.49","13,921.50","12,877.67","13,444.88",10291200000,1,1,1,1,90000,91000,84000,88000
+2018-01-02,BTC,bitcoin,13444.88,15306.13,12934.16,14754.13,16846600192,1,1,1,1,88000,100000,84500,96000
+2018-01-02,ETH,ethereum,755.76,907.02,755.76,861.97,2994970112,0.05,0.06,0.05,0.058,4900,5900,4900,5600
+`
+
+const vendorMap = "timestamp=Date,symbol=Ticker,asset_id=Id,btc_open=BTC Open,btc_high=BTC High,btc_low=BTC Low,btc_close=BTC Close," +
+	"cny_open=CNY Open,cny_high=CNY High,cny_low=CNY Low,cny_close=CNY Close"
+
+func TestImportCSV(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	n, skipped, err := importCSV(ctx, store, coinmarketcapday, strings.NewReader(vendorCSV),
+		vendorMap, "usd", "vendor", "auto", false)
+	if err != nil || n != 3 || skipped != 0 {
+		t.Fatalf("import: %v rows, %v skipped, %v", n, skipped, err)
+	}
+
+	// 重复导入不产生重复行
+	if _, _, err := importCSV(ctx, store, coinmarketcapday, strings.NewReader(vendorCSV),
+		vendorMap, "usd", "vendor", "auto", false); err != nil {
+		t.Fatal(err)
+	}
+	var rows []*candleRow
+	store.ScanCandles(ctx, candleQuery{Table: coinmarketcapday}, func(r *candleRow) error {
+		rows = append(rows, r)
+		return nil
+	})
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows after re-import, want 3", len(rows))
+	}
+	r := rows[0]
+	if r.Symbol != "BTC" || r.AssetID != "bitcoin" || r.Group != "vendor" || r.Timestamp != 1514764800 ||
+		r.PriceUSDFirst != 13850.49 || r.PriceUSDHigh != 13921.5 || r.PriceUSDLow != 12877.67 || r.PriceUSDLast != 13444.88 ||
+		r.PriceBTCFirst != 1 || r.PriceCNYHigh != 91000 || r.PriceCNYLast != 88000 {
+		t.Errorf("unexpected row %+v", r)
+	}
+
+	// 缺少其他币种的价格或 asset_id 时拒绝导入, 不写成 0 或从 symbol 猜
+	for _, mapping := range []string{"timestamp=Date,symbol=Ticker,asset_id=Id", strings.Replace(vendorMap, ",asset_id=Id", "", 1)} {
+		if _, _, err := importCSV(ctx, store, coinmarketcapday, strings.NewReader(vendorCSV), mapping, "usd", "vendor", "auto", false); err == nil {
+			t.Errorf("map %v: expected error", mapping)
+		}
+	}
+}
+
+func TestImportCSVValidation(t *testing.T) {
+	bad := "timestamp,symbol,asset_id,open,high,low,close,usd_open,usd_high,usd_low,usd_close,cny_open,cny_high,cny_low,cny_close\n" +
+		"1514764800,BTC,bitcoin,10,12,9,11,1,1,1,1,7,7,7,7\n" +
+		"1514851200,BTC,bitcoin,10,9,8,9,1,1,1,1,7,7,7,7\n" +
+		"1514937600,BTC,bitcoin,10,12,9,13,1,1,1,1,7,7,7,7\n" +
+		"1515024000,BTC,bitcoin,x,12,9,11,1,1,1,1,7,7,7,7\n" +
+		"1515110400,BTC,,10,12,9,11,1,1,1,1,7,7,7,7\n"
+	store := newMemoryStore()
+	_, _, err := importCSV(context.Background(), store, coinmarketcapday, strings.NewReader(bad), "", "btc", "vendor", "auto", false)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Fatalf("expected error on line 3, got %v", err)
+	}
+	n, skipped, err := importCSV(context.Background(), store, coinmarketcapday, strings.NewReader(bad), "", "btc", "vendor", "auto", true)
+	if err != nil || n != 1 || skipped != 4 {
+		t.Fatalf("skipinvalid: %v rows, %v skipped, %v", n, skipped, err)
+	}
+
+	if _, _, err := importCSV(context.Background(), store, coinmarketcapday, strings.NewReader(bad), "close=Last", "usd", "vendor", "auto", false); err == nil {
+		t.Error("expected error for missing mapped column")
+	}
+}
+
+// 同一 symbol 的两个资产互不覆盖, 一批里同键的行只写最后一个
+func TestUpsertCandles(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	if err := sqlite.Init(ctx, "ticks"); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id string, usd float64) *candleRow {
+		x := testTick(1514937600, usd)
+		x.list[0].Id, x.list[0].Symbol = id, "UNI"
+		return newCandleRow(&x.list[0], x.timestamp, "vendor")
+	}
+	for _, store := range []CandleStore{newMemoryStore(), sqlite} {
+		if err := store.UpsertCandles(ctx, coinmarketcapday, row("uniswap", 1), row("universe", 2), row("uniswap", 3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpsertCandles(ctx, coinmarketcapday, row("universe", 4)); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]float64)
+		store.ScanCandles(ctx, candleQuery{Table: coinmarketcapday}, func(r *candleRow) error {
+			got[r.AssetID] = append(got[r.AssetID], r.PriceUSDLast)
+			return nil
+		})
+		if len(got) != 2 || len(got["uniswap"]) != 1 || got["uniswap"][0] != 3 || len(got["universe"]) != 1 || got["universe"][0] != 4 {
+			t.Errorf("%T: %v", store, got)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"market/candles"
+	"market/indicators"
+)
+
+// 单次 /indicators 最多返回的点数
+const maxIndicatorPoints = 1000
+
+// indicatorRow 物化后的一个值, 多字段的指标 (macd, bollinger) 每个字段一行
+type indicatorRow struct {
+	AssetID   string
+	Symbol    string
+	Timeframe string
+	Indicator string
+	Field     string
+	Timestamp int64
+	Value     float64
+}
+
+var indicatorColumns = []string{"asset_id", "symbol", "timeframe", "indicator", "field", "timestamp", "value"}
+
+func (r *indicatorRow) values() []interface{} {
+	return []interface{}{r.AssetID, r.Symbol, r.Timeframe, r.Indicator, r.Field, r.Timestamp, r.Value}
+}
+
+// IndicatorStore 保存物化的指标
+type IndicatorStore interface {
+	InitIndicators(ctx context.Context) error
+	SaveIndicators(ctx context.Context, rows ...*indicatorRow) error
+	// UpsertIndicators 替换资产, 周期, 指标, 字段和 timestamp 相同的行
+	UpsertIndicators(ctx context.Context, rows ...*indicatorRow) error
+}
+
+// parseIndicatorSpecs 解析 "rsi:14,sma:20,macd", 参数之间的逗号属于前一个指标
+func parseIndicatorSpecs(s string) ([]indicators.Spec, error) {
+	var items []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(part, 64); err == nil && len(items) > 0 {
+			items[len(items)-1] += "," + part
+			continue
+		}
+		items = append(items, part)
+	}
+	var specs []indicators.Spec
+	for _, item := range items {
+		spec, err := indicators.Parse(item)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// indicatorMaterializer 周期表写入后按 USD 收盘价更新每个资产的指标并保存.
+// 每张表的状态只由该表的写队列访问
+type indicatorMaterializer struct {
+	src    CandleStore
+	dst    IndicatorStore
+	specs  []indicators.Spec
+	tables map[string]*tableIndicators
+	log    *slog.Logger
+}
+
+type tableIndicators struct {
+	seeded  bool
+	streams map[string][]*indicators.Stream
+	last    map[string]int64
+}
+
+func newIndicatorMaterializer(src CandleStore, dst IndicatorStore, specs []indicators.Spec) *indicatorMaterializer {
+	m := &indicatorMaterializer{src: src, dst: dst, specs: specs, tables: make(map[string]*tableIndicators), log: component("indicators")}
+	for tbl := range candleWidths {
+		m.tables[tbl] = &tableIndicators{}
+		m.tables[tbl].reset()
+	}
+	return m
+}
+
+func (t *tableIndicators) reset() {
+	t.seeded = false
+	t.streams = make(map[string][]*indicators.Stream)
+	t.last = make(map[string]int64)
+}
+
+func (m *indicatorMaterializer) name() string {
+	return "indicators"
+}
+
+func (m *indicatorMaterializer) lookback() int {
+	n := 0
+	for _, s := range m.specs {
+		if s.Lookback() > n {
+			n = s.Lookback()
+		}
+	}
+	return n
+}
+
+// push 送入一个资产一个周期的收盘价, 返回产生的值
+func (t *tableIndicators) push(specs []indicators.Spec, tbl string, r *candleRow) []*indicatorRow {
+	if r.Timestamp <= t.last[r.AssetID] {
+		return nil
+	}
+	t.last[r.AssetID] = r.Timestamp
+	streams := t.streams[r.AssetID]
+	if streams == nil {
+		for _, s := range specs {
+			streams = append(streams, s.Stream())
+		}
+		t.streams[r.AssetID] = streams
+	}
+	var rows []*indicatorRow
+	for i, st := range streams {
+		v, ok := st.Add(r.PriceUSDLast)
+		if !ok {
+			continue
+		}
+		for j, field := range specs[i].Fields() {
+			rows = append(rows, &indicatorRow{AssetID: r.AssetID, Symbol: r.Symbol, Timeframe: timeframes[tbl],
+				Indicator: specs[i].String(), Field: field, Timestamp: r.Timestamp, Value: v[j]})
+		}
+	}
+	return rows
+}
+
+// closed 在写队列落库之后调用, 第一次调用时先用表中已有的数据预热
+func (m *indicatorMaterializer) closed(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error {
+	if m == nil || len(batch) == 0 {
+		return nil
+	}
+	t := m.tables[tbl]
+	if t == nil {
+		return nil
+	}
+	if !t.seeded {
+		m.warm(ctx, t, tbl, batch[0].timestamp)
+	}
+	var rows []*indicatorRow
+	for _, dat := range batch {
+		for i := range dat.list {
+			rows = append(rows, t.push(m.specs, tbl, newCandleRow(&dat.list[i], dat.timestamp, ""))...)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return m.dst.SaveIndicators(ctx, rows...)
+}
+
+// warm 用表中 to 之前的数据预热
+func (m *indicatorMaterializer) warm(ctx context.Context, t *tableIndicators, tbl string, to int64) {
+	t.seeded = true
+	q := candleQuery{Table: tbl, Groups: []string{""}, From: to - int64(m.lookback()+1)*candleWidths[tbl], To: to}
+	err := m.src.ScanCandles(ctx, q, func(r *candleRow) error {
+		t.push(m.specs, tbl, r)
+		return nil
+	})
+	if err != nil {
+		m.log.Warn("warm up failed", "table", tbl, "err", err)
+	}
+}
+
+// amended 修正的周期已经写入周期表. 指标带有状态, 从最早的修正周期开始按表中数据重新计算,
+// 替换之前保存的值
+func (m *indicatorMaterializer) amended(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error {
+	if m == nil || len(batch) == 0 {
+		return nil
+	}
+	t := m.tables[tbl]
+	if t == nil {
+		return nil
+	}
+	from := batch[0].timestamp
+	for _, dat := range batch {
+		if dat.timestamp < from {
+			from = dat.timestamp
+		}
+	}
+	t.reset()
+	m.warm(ctx, t, tbl, from)
+	var rows []*indicatorRow
+	err := m.src.ScanCandles(ctx, candleQuery{Table: tbl, Groups: []string{""}, From: from}, func(r *candleRow) error {
+		rows = append(rows, t.push(m.specs, tbl, r)...)
+		return nil
+	})
+	if err != nil {
+		// 状态不完整, 下次重新预热
+		t.reset()
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return m.dst.UpsertIndicators(ctx, rows...)
+}
+
+// indicatorHandler /indicators?symbol=BTC&interval=1h&type=rsi&period=14,
+// 在周期表上实时计算, 不依赖物化的结果
+type indicatorHandler struct {
+	store candles.Reader
+	clk   clock
+}
+
+type indicatorSeries struct {
+	AssetID string                   `json:"asset_id"`
+	Symbol  string                   `json:"symbol"`
+	Points  []map[string]interface{} `json:"points"`
+}
+
+type indicatorResponse struct {
+	Indicator string            `json:"indicator"`
+	Interval  string            `json:"interval"`
+	Currency  string            `json:"currency"`
+	Fields    []string          `json:"fields"`
+	Series    []indicatorSeries `json:"series"`
+}
+
+// indicatorRequest 解析后的查询参数
+type indicatorRequest struct {
+	q        candleQuery
+	spec     indicators.Spec
+	interval string
+	currency string
+	closeOf  func(*candleRow) float64
+	group    string
+	from     int64
+	limit    int
+}
+
+var indicatorCloses = map[string]func(*candleRow) float64{
+	"usd": func(r *candleRow) float64 { return r.PriceUSDLast },
+	"btc": func(r *candleRow) float64 { return r.PriceBTCLast },
+	"cny": func(r *candleRow) float64 { return r.PriceCNYLast },
+}
+
+func (h *indicatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := h.parse(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.compute(r.Context(), req)
+	if err != nil {
+		component("indicators").Error("compute failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *indicatorHandler) parse(r *http.Request) (*indicatorRequest, error) {
+	p := r.URL.Query()
+	req := &indicatorRequest{interval: p.Get("interval"), group: p.Get("group"), limit: 100}
+	if s := p.Get("symbol"); s != "" {
+		req.q.Symbols = strings.Split(s, ",")
+	}
+	if s := p.Get("asset_id"); s != "" {
+		req.q.AssetIDs = strings.Split(s, ",")
+	}
+	if req.q.Symbols == nil && req.q.AssetIDs == nil {
+		return nil, fmt.Errorf("symbol or asset_id is required")
+	}
+	for tbl, tf := range timeframes {
+		if tf == req.interval && tbl != coinmarketcapcurrent {
+			req.q.Table = tbl
+		}
+	}
+	if req.q.Table == "" {
+		return nil, fmt.Errorf("unknown interval %q", req.interval)
+	}
+	spec := p.Get("type")
+	if period := p.Get("period"); period != "" {
+		spec += ":" + period
+	}
+	var err error
+	if req.spec, err = indicators.Parse(spec); err != nil {
+		return nil, err
+	}
+	req.currency = strings.ToLower(p.Get("currency"))
+	if req.currency == "" {
+		req.currency = "usd"
+	}
+	if req.closeOf = indicatorCloses[req.currency]; req.closeOf == nil {
+		return nil, fmt.Errorf("unknown currency %q", req.currency)
+	}
+	if s := p.Get("limit"); s != "" {
+		if req.limit, err = strconv.Atoi(s); err != nil || req.limit <= 0 || req.limit > maxIndicatorPoints {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxIndicatorPoints)
+		}
+	}
+	if req.from, err = parseTime(p.Get("from")); err != nil {
+		return nil, err
+	}
+	to, err := parseTime(p.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		to = h.clk.Now().Unix()
+	}
+	// 多取 Lookback 个周期预热, 只返回 from 之后的点
+	width := candleWidths[req.q.Table]
+	req.q.To = to
+	if req.from == 0 {
+		req.q.From = to - int64(req.limit+req.spec.Lookback()+1)*width
+	} else {
+		req.q.From = req.from - int64(req.spec.Lookback()+1)*width
+	}
+	return req, nil
+}
+
+// compute 每个资产一个序列, 每个序列最多 limit 个点
+func (h *indicatorHandler) compute(ctx context.Context, req *indicatorRequest) (*indicatorResponse, error) {
+	resp := &indicatorResponse{Indicator: req.spec.String(), Interval: req.interval, Currency: req.currency,
+		Fields: req.spec.Fields(), Series: []indicatorSeries{}}
+	streams := make(map[string]*indicators.Stream)
+	index := make(map[string]int)
+	err := h.store.ScanCandles(ctx, req.q, func(row *candleRow) error {
+		if row.Group != req.group {
+			return nil
+		}
+		st := streams[row.AssetID]
+		if st == nil {
+			st = req.spec.Stream()
+			streams[row.AssetID] = st
+			index[row.AssetID] = len(resp.Series)
+			resp.Series = append(resp.Series, indicatorSeries{AssetID: row.AssetID, Symbol: row.Symbol, Points: []map[string]interface{}{}})
+		}
+		v, ok := st.Add(req.closeOf(row))
+		if !ok || row.Timestamp < req.from {
+			return nil
+		}
+		point := map[string]interface{}{"time": row.Timestamp}
+		for i, f := range resp.Fields {
+			point[f] = v[i]
+		}
+		s := &resp.Series[index[row.AssetID]]
+		s.Points = append(s.Points, point)
+		if len(s.Points) > req.limit {
+			s.Points = s.Points[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
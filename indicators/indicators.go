@@ -0,0 +1,298 @@
+// Package indicators 在收盘价序列上计算常用技术指标,
+// 既可以一次算完整个序列, 也可以随周期结束逐个送入.
+package indicators
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kind 指标类型
+type Kind string
+
+const (
+	SMA       Kind = "sma"
+	EMA       Kind = "ema"
+	RSI       Kind = "rsi"
+	MACD      Kind = "macd"
+	Bollinger Kind = "bollinger"
+)
+
+// 省略的参数按常用值补齐
+var defaults = map[Kind][]float64{
+	SMA:       {20},
+	EMA:       {20},
+	RSI:       {14},
+	MACD:      {12, 26, 9},
+	Bollinger: {20, 2},
+}
+
+// Spec 指标和参数, 字符串形式为 rsi:14, macd:12,26,9, bollinger:20,2
+type Spec struct {
+	Kind   Kind
+	Params []float64
+}
+
+// New 检查参数, 缺少的参数用默认值
+func New(kind Kind, params ...float64) (Spec, error) {
+	def, ok := defaults[kind]
+	if !ok {
+		return Spec{}, fmt.Errorf("indicators: unknown indicator %q", kind)
+	}
+	if len(params) > len(def) {
+		return Spec{}, fmt.Errorf("indicators: %v takes at most %d parameters", kind, len(def))
+	}
+	s := Spec{Kind: kind, Params: append(append([]float64(nil), params...), def[len(params):]...)}
+	for i, p := range s.Params {
+		// bollinger 的第二个参数是标准差倍数, 其余都是周期数
+		if kind == Bollinger && i == 1 {
+			if !(p > 0) {
+				return Spec{}, fmt.Errorf("indicators: %v: width must be positive", kind)
+			}
+			continue
+		}
+		if p < 1 || p != math.Trunc(p) || p > 10000 {
+			return Spec{}, fmt.Errorf("indicators: %v: invalid period %v", kind, p)
+		}
+	}
+	if kind == MACD && s.Params[0] >= s.Params[1] {
+		return Spec{}, fmt.Errorf("indicators: macd: fast period must be shorter than slow")
+	}
+	return s, nil
+}
+
+// Parse 解析 String 的结果
+func Parse(s string) (Spec, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(s), ":")
+	var params []float64
+	if args != "" {
+		for _, a := range strings.Split(args, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+			if err != nil {
+				return Spec{}, fmt.Errorf("indicators: invalid parameter %q in %q", a, s)
+			}
+			params = append(params, p)
+		}
+	}
+	return New(Kind(strings.ToLower(name)), params...)
+}
+
+func (s Spec) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return string(s.Kind) + ":" + strings.Join(params, ",")
+}
+
+func (s Spec) period(i int) int {
+	return int(s.Params[i])
+}
+
+// Fields 每个值包含的字段
+func (s Spec) Fields() []string {
+	switch s.Kind {
+	case MACD:
+		return []string{"macd", "signal", "histogram"}
+	case Bollinger:
+		return []string{"middle", "upper", "lower"}
+	}
+	return []string{string(s.Kind)}
+}
+
+// Warmup 送入多少个价格后开始出值
+func (s Spec) Warmup() int {
+	switch s.Kind {
+	case RSI:
+		return s.period(0) + 1
+	case MACD:
+		return s.period(1) + s.period(2) - 1
+	}
+	return s.period(0)
+}
+
+// Lookback 需要的历史长度. 指数平滑的结果和起点有关,
+// 多取几倍的周期后起点的影响可以忽略
+func (s Spec) Lookback() int {
+	switch s.Kind {
+	case EMA, RSI, MACD:
+		return 4 * s.Warmup()
+	}
+	return s.Warmup()
+}
+
+// Compute 按顺序计算 closes 上的指标, 结果和 closes 一一对应, 预热期内为 nil
+func (s Spec) Compute(closes []float64) [][]float64 {
+	st := s.Stream()
+	ret := make([][]float64, len(closes))
+	for i, x := range closes {
+		if v, ok := st.Add(x); ok {
+			ret[i] = v
+		}
+	}
+	return ret
+}
+
+// Stream 逐个送入收盘价的计算状态, 不是并发安全的
+type Stream struct {
+	add func(x float64) ([]float64, bool)
+}
+
+func (s Spec) Stream() *Stream {
+	st := &Stream{}
+	switch s.Kind {
+	case SMA:
+		w := newWindow(s.period(0))
+		st.add = func(x float64) ([]float64, bool) {
+			if !w.push(x) {
+				return nil, false
+			}
+			return []float64{w.mean()}, true
+		}
+	case EMA:
+		e := newEMA(s.period(0))
+		st.add = func(x float64) ([]float64, bool) {
+			v, ok := e.push(x)
+			return []float64{v}, ok
+		}
+	case RSI:
+		r := newRSI(s.period(0))
+		st.add = func(x float64) ([]float64, bool) {
+			v, ok := r.push(x)
+			return []float64{v}, ok
+		}
+	case MACD:
+		fast, slow, signal := newEMA(s.period(0)), newEMA(s.period(1)), newEMA(s.period(2))
+		st.add = func(x float64) ([]float64, bool) {
+			f, _ := fast.push(x)
+			sl, ok := slow.push(x)
+			if !ok {
+				return nil, false
+			}
+			m := f - sl
+			sig, ok := signal.push(m)
+			if !ok {
+				return nil, false
+			}
+			return []float64{m, sig, m - sig}, true
+		}
+	case Bollinger:
+		w := newWindow(s.period(0))
+		k := s.Params[1]
+		st.add = func(x float64) ([]float64, bool) {
+			if !w.push(x) {
+				return nil, false
+			}
+			mean, sd := w.mean(), w.stddev()
+			return []float64{mean, mean + k*sd, mean - k*sd}, true
+		}
+	}
+	return st
+}
+
+// Add 送入下一个收盘价, 预热期内 ok 为 false
+func (st *Stream) Add(x float64) (v []float64, ok bool) {
+	return st.add(x)
+}
+
+// window 最近 n 个值
+type window struct {
+	buf  []float64
+	next int
+	full bool
+}
+
+func newWindow(n int) *window {
+	return &window{buf: make([]float64, n)}
+}
+
+func (w *window) push(x float64) bool {
+	w.buf[w.next] = x
+	w.next++
+	if w.next == len(w.buf) {
+		w.next, w.full = 0, true
+	}
+	return w.full
+}
+
+func (w *window) mean() float64 {
+	var sum float64
+	for _, x := range w.buf {
+		sum += x
+	}
+	return sum / float64(len(w.buf))
+}
+
+// stddev 总体标准差, 和常见的布林带定义一致
+func (w *window) stddev() float64 {
+	mean := w.mean()
+	var sum float64
+	for _, x := range w.buf {
+		sum += (x - mean) * (x - mean)
+	}
+	return math.Sqrt(sum / float64(len(w.buf)))
+}
+
+// ema 前 n 个值的简单平均作为初值
+type ema struct {
+	n     int
+	count int
+	k     float64
+	v     float64
+}
+
+func newEMA(n int) *ema {
+	return &ema{n: n, k: 2 / float64(n+1)}
+}
+
+func (e *ema) push(x float64) (float64, bool) {
+	e.count++
+	if e.count <= e.n {
+		e.v += (x - e.v) / float64(e.count)
+		return e.v, e.count == e.n
+	}
+	e.v += e.k * (x - e.v)
+	return e.v, true
+}
+
+// rsi Wilder 平滑
+type rsi struct {
+	n          int
+	count      int
+	prev       float64
+	gain, loss float64
+}
+
+func newRSI(n int) *rsi {
+	return &rsi{n: n}
+}
+
+func (r *rsi) push(x float64) (float64, bool) {
+	r.count++
+	if r.count == 1 {
+		r.prev = x
+		return 0, false
+	}
+	d := x - r.prev
+	r.prev = x
+	gain, loss := math.Max(d, 0), math.Max(-d, 0)
+	if r.count <= r.n+1 {
+		r.gain += gain / float64(r.n)
+		r.loss += loss / float64(r.n)
+		if r.count <= r.n {
+			return 0, false
+		}
+	} else {
+		r.gain = (r.gain*float64(r.n-1) + gain) / float64(r.n)
+		r.loss = (r.loss*float64(r.n-1) + loss) / float64(r.n)
+	}
+	switch {
+	case r.loss == 0 && r.gain == 0:
+		return 50, true
+	case r.loss == 0:
+		return 100, true
+	}
+	return 100 - 100/(1+r.gain/r.loss), true
+}
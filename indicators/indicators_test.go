@@ -0,0 +1,91 @@
+package indicators
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]string{
+		"rsi":            "rsi:14",
+		"RSI:7":          "rsi:7",
+		"macd:5":         "macd:5,26,9",
+		"bollinger:10,1": "bollinger:10,1",
+		"sma:20":         "sma:20",
+	} {
+		s, err := Parse(in)
+		if err != nil || s.String() != want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", in, s, err, want)
+		}
+	}
+	for _, in := range []string{"vwap", "sma:0", "sma:2.5", "ema:1,2", "macd:26,12", "bollinger:20,0", "rsi:x"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) should fail", in)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	closes := []float64{1, 2, 3, 4, 5}
+	sma, _ := New(SMA, 3)
+	ema, _ := New(EMA, 3)
+	got := sma.Compute(closes)
+	if want := [][]float64{nil, nil, {2}, {3}, {4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sma %v, want %v", got, want)
+	}
+	// 初值为前 3 个的平均, 之后 k = 0.5
+	if got := ema.Compute(closes); got[1] != nil || got[2][0] != 2 || got[3][0] != 3 || got[4][0] != 4 {
+		t.Errorf("ema %v", got)
+	}
+
+	rsi, _ := New(RSI, 2)
+	got = rsi.Compute([]float64{1, 2, 1, 2})
+	if got[1] != nil || !near(got[2][0], 50) || !near(got[3][0], 75) {
+		t.Errorf("rsi %v", got)
+	}
+	if got := rsi.Compute([]float64{3, 3, 3}); got[2][0] != 50 {
+		t.Errorf("flat rsi %v", got)
+	}
+	if got := rsi.Compute([]float64{1, 2, 3}); got[2][0] != 100 {
+		t.Errorf("rising rsi %v", got)
+	}
+
+	bb, _ := New(Bollinger, 3, 2)
+	got = bb.Compute([]float64{1, 2, 3})
+	sd := math.Sqrt(2.0 / 3)
+	if v := got[2]; !near(v[0], 2) || !near(v[1], 2+2*sd) || !near(v[2], 2-2*sd) {
+		t.Errorf("bollinger %v", got)
+	}
+}
+
+func TestMACD(t *testing.T) {
+	var closes []float64
+	for i := 0; i < 60; i++ {
+		closes = append(closes, 100+10*math.Sin(float64(i)/5))
+	}
+	spec, _ := New(MACD, 3, 6, 4)
+	got := spec.Compute(closes)
+	if first := spec.Warmup() - 1; got[first-1] != nil || got[first] == nil {
+		t.Fatalf("warmup %d: %v", spec.Warmup(), got[:first+1])
+	}
+	fast, _ := New(EMA, 3)
+	slow, _ := New(EMA, 6)
+	f, s := fast.Compute(closes), slow.Compute(closes)
+	var line []float64
+	for i := 5; i < len(closes); i++ {
+		line = append(line, f[i][0]-s[i][0])
+	}
+	sig, _ := New(EMA, 4)
+	signal := sig.Compute(line)
+	for i := spec.Warmup() - 1; i < len(closes); i++ {
+		m, sg := line[i-5], signal[i-5][0]
+		if v := got[i]; !near(v[0], m) || !near(v[1], sg) || !near(v[2], m-sg) {
+			t.Fatalf("%d: got %v, want %v %v", i, v, m, sg)
+		}
+	}
+}
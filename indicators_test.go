@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndicatorEndpoint(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	base := int64(1514937600)
+	for i := int64(0); i < 30; i++ {
+		store.SaveCandles(ctx, coinmarketcapmin, "", testTick(base+i*60, float64(i+1)))
+	}
+	// 其他来源的数据不参与计算
+	store.SaveCandles(ctx, coinmarketcapmin, "import", testTick(base+29*60, 1000))
+	h := &indicatorHandler{store: store, clk: newFakeClock(time.Unix(base+30*60, 0))}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/indicators?symbol=BTC&interval=1m&type=sma&period=3&limit=2", nil))
+	var resp indicatorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.Bytes())
+	}
+	if resp.Indicator != "sma:3" || len(resp.Series) != 1 || len(resp.Series[0].Points) != 2 {
+		t.Fatalf("%s", w.Body.Bytes())
+	}
+	for i, want := range []float64{28, 29} {
+		p := resp.Series[0].Points[i]
+		if p["sma"] != want || p["time"] != float64(base+int64(28+i)*60) {
+			t.Errorf("point %d: %v, want sma %v", i, p, want)
+		}
+	}
+
+	// from 之前的数据只用来预热
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/indicators?asset_id=bitcoin&interval=1m&type=bollinger&period=5,1&currency=cny&from=1514938800", nil))
+	resp = indicatorResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if pts := resp.Series[0].Points; len(pts) != 10 || pts[0]["middle"] != float64(19*7) || pts[0]["time"] != float64(1514938800) {
+		t.Errorf("%s", w.Body.Bytes())
+	}
+
+	for _, q := range []string{
+		"interval=1m&type=sma",
+		"symbol=BTC&interval=2m&type=sma",
+		"symbol=BTC&interval=1m&type=vwap",
+		"symbol=BTC&interval=1m&type=rsi&currency=eur",
+		"symbol=BTC&interval=1m&type=rsi&limit=0",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/indicators?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %d", q, w.Code)
+		}
+	}
+}
+
+func TestIndicatorMaterializer(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	base := int64(1514937600)
+	for i := int64(0); i < 5; i++ {
+		store.SaveCandles(ctx, coinmarketcapmin, "", testTick(base+i*60, float64(i+1)))
+	}
+	specs, err := parseIndicatorSpecs("sma:3, bollinger:3,2")
+	if err != nil || len(specs) != 2 {
+		t.Fatal(specs, err)
+	}
+	m := newIndicatorMaterializer(store, store, specs)
+
+	// 第一次用表中已有的 5 个周期预热, 写队列落库后才会调用 closed
+	next := testTick(base+5*60, 6)
+	store.SaveCandles(ctx, coinmarketcapmin, "", next)
+	if err := m.closed(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{next}); err != nil {
+		t.Fatal(err)
+	}
+	next = testTick(base+6*60, 10)
+	store.SaveCandles(ctx, coinmarketcapmin, "", next)
+	m.closed(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{next})
+	rows := store.Indicators()
+	if len(rows) != 8 {
+		t.Fatalf("%d rows: %+v", len(rows), rows)
+	}
+	if r := rows[0]; r.Indicator != "sma:3" || r.Field != "sma" || r.Timeframe != "1m" || r.Timestamp != base+5*60 || r.Value != 5 {
+		t.Errorf("first row %+v", r)
+	}
+	if r := rows[4]; r.Indicator != "sma:3" || r.Value != 7 || r.Timestamp != base+6*60 {
+		t.Errorf("second sma %+v", r)
+	}
+	if r := rows[7]; r.Indicator != "bollinger:3,2" || r.Field != "lower" {
+		t.Errorf("last row %+v", r)
+	}
+
+	// 迟到的数据修正了 5 号周期, 之后的指标按修正后的值重新计算并替换
+	amended := testTick(base+5*60, 9)
+	store.UpsertCandles(ctx, coinmarketcapmin, newCandleRow(&amended.list[0], amended.timestamp, ""))
+	if err := m.amended(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{amended}); err != nil {
+		t.Fatal(err)
+	}
+	m.closed(ctx, coinmarketcapmin, []*kPriceCoinMarketCapList{testTick(base+7*60, 11)})
+	sma := make(map[int64][]float64)
+	for _, r := range store.Indicators() {
+		if r.Field == "sma" {
+			sma[r.Timestamp] = append(sma[r.Timestamp], r.Value)
+		}
+	}
+	want := map[int64][]float64{base + 5*60: {6}, base + 6*60: {8}, base + 7*60: {10}}
+	if !reflect.DeepEqual(sma, want) {
+		t.Errorf("sma after amend %v, want %v", sma, want)
+	}
+}
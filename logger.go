@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var logger = newLogger(os.Stderr, slog.LevelInfo)
+
+// 参数名包含这些字样的一律脱敏
+var secretKeys = []string{"password", "passwd", "secret", "token", "apikey", "api_key"}
+
+// 连接串里的 password=xxx 或 url 中的 user:pass@
+var secretValue = regexp.MustCompile(`(?i)(password=)\S+|(://[^:/@\s]+:)[^@\s]+(@)`)
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range secretKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(s string) string {
+	return secretValue.ReplaceAllString(s, "${1}${2}"+redacted+"${3}")
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSecretKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redact(err.Error()))
+		}
+	}
+	return a
+}
+
+func newLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("--loglevel: %v", err)
+	}
+	return l, nil
+}
+
+func component(name string, args ...any) *slog.Logger {
+	return logger.With(append([]any{"component", name}, args...)...)
+}
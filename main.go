@@ -1,21 +1,22 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/big"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
-	"github.com/lib/pq"
 	"github.com/urfave/cli"
 )
 
 const (
-	coinMarketCapURL = "https://api.coinmarketcap.com/v1/ticker/?convert=CNY&timestamp=%s"
+	coinMarketCapSource = "coinmarketcap"
+	coinMarketCapURL    = "https://api.coinmarketcap.com/v1/ticker/?convert=CNY&timestamp=%d"
 )
 
 var (
@@ -43,58 +44,18 @@ const (
 
 var table = "coinmarketcap"
 
-// CREATE INDEX IF NOT EXISTS  pg 9.5 才支持
-const tblCoinMarketCapXmin = `
-	CREATE TABLE IF NOT EXISTS %s (
-		id SERIAL PRIMARY KEY,
-		asset_id character varying(32) NOT NULL,  
-		name character varying(32) NOT NULL, 
-		symbol character varying(32) NOT NULL, 
-		rank integer  NOT NULL, 
-		price_usd_first real  NOT NULL, 
-		price_usd_last real NOT NULL, 
-		price_usd_low real  NOT NULL, 
-		price_usd_high  real NOT NULL, 
-		price_btc_first real  NOT NULL, 
-		price_btc_last real  NOT NULL, 
-		price_btc_low real  NOT NULL, 
-		price_btc_high real  NOT NULL, 
-		price_cny_first real  NOT NULL, 
-		price_cny_last real  NOT NULL, 
-		price_cny_low real  NOT NULL, 
-		price_cny_high real  NOT NULL, 
-		last_updated bigint NOT NULL,
-		timestamp bigint NOT NULL,
-        _group character varying(32) 
-	); 
-
-   CREATE INDEX IF NOT EXISTS index_timestamp_%s ON %s (timestamp);
-   CREATE INDEX IF NOT EXISTS index_last_updated_%s ON %s (last_updated);
-   CREATE INDEX IF NOT EXISTS index_symbol_%s ON %s USING hash (symbol);
-`
-
-const tblCoinMarketCap = `
-	CREATE TABLE IF NOT EXISTS %s (
-		id SERIAL PRIMARY KEY,
-		asset_id  character varying(32) NOT NULL,  
-		name character varying(32) NOT NULL,
-		symbol character varying(32) NOT NULL,
-		rank character varying(32) NOT NULL,
-		price_usd character varying(64) NOT NULL,
-		price_btc character varying(64) NOT NULL,
-		volume_usd_24h character varying(64) NOT NULL,
-		market_cap_usd character varying(64) NOT NULL,
-		available_supply character varying(64) NOT NULL,
-		total_supply character varying(64) NOT NULL,
-		percent_change_1h character varying(64) NOT NULL,
-		percent_change_24h character varying(64) NOT NULL,
-		percent_change_7d character varying(64) NOT NULL,
-		last_updated character varying(64) NOT NULL,
-		price_cny character varying(64) NOT NULL,
-		volume_cny_24h character varying(64) NOT NULL,
-		market_cap_cny character varying(64) NOT NULL 
-	);
-`
+// 表对应的周期名, 用于日志字段
+var timeframes = map[string]string{
+	coinmarketcapmin:     "1m",
+	coinmarketcap5min:    "5m",
+	coinmarketcap10min:   "10m",
+	coinmarketcap15min:   "15m",
+	coinmarketcap30min:   "30m",
+	coinmarketcaphour:    "1h",
+	coinmarketcapday:     "1d",
+	coinmarketcapweek:    "1w",
+	coinmarketcapcurrent: "current",
+}
 
 type priceCoinMarketCap struct {
 	Id                string `json:"id"`
@@ -134,20 +95,76 @@ type kPriceCoinMarketCap struct {
 	PriceCNYLow   big.Float `json:"price_cny_low"`
 	PriceCNYHigh  big.Float `json:"price_cny_high"`
 	LastUpdated   big.Int   `json:"last_updated"`
+	// VolumeUSD24H 最近一次抓取的 24 小时成交额, 不写入周期表
+	VolumeUSD24H big.Float `json:"volume_usd_24h"`
+	// Stale 上游的 last_updated 没有前进, 汇总后只有周期内全部数据都是旧的才为 true
+	Stale bool `json:"stale"`
+	// 成交数据汇总的成交量 (USD 计价), 汇总时相加, 只抓取价格的来源为 0
+	VolumeBase  float64 `json:"volume_base"`
+	VolumeQuote float64 `json:"volume_quote"`
+	Trades      int64   `json:"trades"`
+}
+
+// vwap 成交量加权均价, 没有成交时为 0
+func (k *kPriceCoinMarketCap) vwap() float64 {
+	if k.VolumeBase <= 0 {
+		return 0
+	}
+	return k.VolumeQuote / k.VolumeBase
 }
 
 type kPriceCoinMarketCapList struct {
 	list      []kPriceCoinMarketCap
 	timestamp int64
+	// last 已汇总的最新一条数据的 timestamp, 单条数据为 0
+	last int64
+	// amended 已经写入过的周期因迟到数据被修正, 写入时替换原来的行
+	amended bool
+}
+
+// lastAt 汇总的数据中最新的 timestamp
+func (this *kPriceCoinMarketCapList) lastAt() int64 {
+	if this.last > this.timestamp {
+		return this.last
+	}
+	return this.timestamp
+}
+
+func (this *kPriceCoinMarketCapList) resetVolume() {
+	for i := range this.list {
+		this.list[i].VolumeBase, this.list[i].VolumeQuote, this.list[i].Trades = 0, 0, 0
+	}
 }
 
 func (this *kPriceCoinMarketCapList) Copy() *kPriceCoinMarketCapList {
 	ret := new(kPriceCoinMarketCapList)
 	ret.timestamp = this.timestamp
+	ret.last, ret.amended = this.last, this.amended
 	ret.list = append(ret.list, this.list...)
 	return ret
 }
 
+type kPriceCoinMarketCapListJSON struct {
+	List      []kPriceCoinMarketCap `json:"list"`
+	Timestamp int64                 `json:"timestamp"`
+	Amended   bool                  `json:"amended,omitempty"`
+}
+
+func (this *kPriceCoinMarketCapList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(kPriceCoinMarketCapListJSON{List: this.list, Timestamp: this.timestamp, Amended: this.amended})
+}
+
+func (this *kPriceCoinMarketCapList) UnmarshalJSON(b []byte) error {
+	var v kPriceCoinMarketCapListJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	this.list = v.List
+	this.timestamp = v.Timestamp
+	this.amended = v.Amended
+	return nil
+}
+
 func newKPriceCoinMarketCapList(list []priceCoinMarketCap, now int64) *kPriceCoinMarketCapList {
 	ret := new(kPriceCoinMarketCapList)
 	ret.timestamp = now
@@ -170,6 +187,7 @@ func newKPriceCoinMarketCapList(list []priceCoinMarketCap, now int64) *kPriceCoi
 		tmp.PriceBTCHigh.SetString(v.PriceBTC)
 		tmp.PriceCNYHigh.SetString(v.PriceCNY)
 		tmp.LastUpdated.SetString(v.LastUpdated, 10)
+		tmp.VolumeUSD24H.SetString(v.VolumeUSD24H)
 		ret.list = append(ret.list, tmp)
 	}
 	return ret
@@ -182,7 +200,7 @@ func main() {
 			&cli.StringFlag{
 				Name:  "drivername",
 				Value: "postgres",
-				Usage: "database type default postgres",
+				Usage: "storage backend: postgres, timescaledb, sqlite3 or memory",
 			},
 			&cli.StringFlag{
 				Name:  "dbport",
@@ -192,7 +210,7 @@ func main() {
 			&cli.StringFlag{
 				Name:  "dbname",
 				Value: "postgres",
-				Usage: "database name default postgres",
+				Usage: "database name default postgres, file path for sqlite3",
 			},
 			&cli.StringFlag{
 				Name:  "dbusername",
@@ -219,66 +237,581 @@ func main() {
 				Value: 10 * time.Second,
 				Usage: "price query duration",
 			},
+			&cli.DurationFlag{
+				Name:  "fetchtimeout",
+				Value: 8 * time.Second,
+				Usage: "upstream request timeout",
+			},
+			&cli.IntFlag{
+				Name:  "fetchretries",
+				Value: 3,
+				Usage: "upstream request retries before giving up a tick",
+			},
+			&cli.IntFlag{
+				Name:  "breakerfailures",
+				Value: 5,
+				Usage: "consecutive upstream failures that open the circuit breaker",
+			},
+			&cli.DurationFlag{
+				Name:  "breakercooldown",
+				Value: time.Minute,
+				Usage: "how long the circuit breaker stays open",
+			},
+			&cli.StringFlag{
+				Name:  "httpaddr",
+				Value: ":8080",
+				Usage: "listen address of the metrics and health endpoints",
+			},
+			&cli.DurationFlag{
+				Name:  "freshcurrent",
+				Value: time.Minute,
+				Usage: "max age of the latest coinmarketcapcurrent row before /readyz fails",
+			},
+			&cli.DurationFlag{
+				Name:  "freshmin",
+				Value: 3 * time.Minute,
+				Usage: "max age of the latest coinmarketcapmin bucket before /readyz fails",
+			},
+			&cli.StringFlag{
+				Name:  "loglevel",
+				Value: "info",
+				Usage: "log level: debug, info, warn or error",
+			},
+			&cli.IntFlag{
+				Name:  "queuesize",
+				Value: 100,
+				Usage: "items buffered per table before the queue policy applies",
+			},
+			&cli.IntFlag{
+				Name:  "queuebatch",
+				Value: 20,
+				Usage: "max items written per database transaction",
+			},
+			&cli.DurationFlag{
+				Name:  "lategrace",
+				Value: 3 * time.Second,
+				Usage: "keep a bucket open this long after its end for ticks still in flight; ticks are assigned to buckets by their own fetch time",
+			},
+			&cli.StringFlag{
+				Name:  "latepolicy",
+				Value: "amend",
+				Usage: "ticks arriving after their bucket closed: amend rewrites the stored candle, drop discards them",
+			},
+			&cli.StringFlag{
+				Name:  "queuepolicy",
+				Value: "block",
+				Usage: "full write queue policy: block, dropoldest or spill",
+			},
+			&cli.StringFlag{
+				Name:  "spilldir",
+				Value: filepath.Join(os.TempDir(), "market-spill"),
+				Usage: "directory for spilled queue items",
+			},
+			&cli.DurationFlag{
+				Name:  "compressafter",
+				Value: 7 * 24 * time.Hour,
+				Usage: "timescaledb: compress chunks older than this, 0 disables compression",
+			},
+			&cli.BoolFlag{
+				Name:  "continuousaggs",
+				Usage: "timescaledb: build 5min and longer tables as continuous aggregates over coinmarketcapmin",
+			},
+			&cli.BoolFlag{
+				Name:  "partitioned",
+				Usage: "postgres: create new raw and candle tables range partitioned by timestamp, expired partitions are dropped by retention",
+			},
+			&cli.IntFlag{
+				Name:  "partitionsahead",
+				Value: 3,
+				Usage: "postgres: future partitions created ahead of time per table",
+			},
+			&cli.StringFlag{
+				Name:  "retention",
+				Value: "raw=7d,1m=30d,5m=180d",
+				Usage: "per table retention, e.g. raw=7d,1m=30d,1h=forever; tables not listed are kept forever",
+			},
+			&cli.IntFlag{
+				Name:  "retentionbatch",
+				Value: 10000,
+				Usage: "rows deleted per statement by the retention job, 0 deletes in one statement",
+			},
+			&cli.StringFlag{
+				Name:  "archive",
+				Usage: "archive raw ticks per day before retention deletes them: a local directory or s3://bucket/prefix",
+			},
+			&cli.StringFlag{
+				Name:  "archivecompression",
+				Value: "gzip",
+				Usage: "archive compression: gzip or zstd",
+			},
+			&cli.BoolFlag{
+				Name:  "archiveresponses",
+				Usage: "also archive the raw upstream responses",
+			},
+			&cli.StringFlag{
+				Name:  "s3endpoint",
+				Value: "https://s3.amazonaws.com",
+				Usage: "endpoint of the S3 compatible archive store",
+			},
+			&cli.StringFlag{
+				Name:  "s3region",
+				Value: "us-east-1",
+				Usage: "region used to sign S3 requests",
+			},
+			&cli.StringFlag{
+				Name:   "s3accesskey",
+				EnvVar: "AWS_ACCESS_KEY_ID",
+				Usage:  "S3 access key",
+			},
+			&cli.StringFlag{
+				Name:   "s3secretkey",
+				EnvVar: "AWS_SECRET_ACCESS_KEY",
+				Usage:  "S3 secret key",
+			},
+			&cli.BoolFlag{
+				Name:  "s3pathstyle",
+				Usage: "address buckets as endpoint/bucket instead of bucket.endpoint, e.g. for minio",
+			},
+			&cli.StringFlag{
+				Name:  "grpcaddr",
+				Usage: "serve the gRPC API, including SubscribeCandles fed by the collector, on this address (plaintext HTTP/2)",
+			},
+			&cli.IntFlag{
+				Name:  "graphqlcomplexity",
+				Value: 50000,
+				Usage: "max cost of one /graphql request: one per asset plus one per candle requested for each asset",
+			},
+			&cli.StringFlag{
+				Name:  "indicators",
+				Usage: "materialize these indicators on the USD close into the indicators table as each candle is written, e.g. rsi:14,sma:20,macd:12,26,9",
+			},
+			&cli.BoolFlag{
+				Name:  "alerts",
+				Usage: "evaluate alert rules (see the alerts command) on every price update",
+			},
+			&cli.StringFlag{
+				Name:  "alertwebhook",
+				Usage: "URL alerts are POSTed to as JSON when their rule has no webhook of its own",
+			},
+			&cli.DurationFlag{
+				Name:  "alertreload",
+				Value: 30 * time.Second,
+				Usage: "how often alert rules are re-read from the database",
+			},
+			&cli.IntFlag{
+				Name:  "filterwindow",
+				Usage: "drop ticks that deviate from the median of each asset's last N accepted prices, e.g. 30; 0 disables the filter",
+			},
+			&cli.Float64Flag{
+				Name:  "filterdeviation",
+				Value: 0.5,
+				Usage: "max relative deviation from the rolling median, 0.5 accepts prices within ±50%",
+			},
+			&cli.StringFlag{
+				Name:  "filtermode",
+				Value: "quarantine",
+				Usage: "reject drops outliers until --filterwindow consecutive ones reset the window, quarantine holds them until --filterconfirm consecutive ticks agree on the new price",
+			},
+			&cli.IntFlag{
+				Name:  "filterconfirm",
+				Value: 5,
+				Usage: "consecutive consistent ticks needed to accept a new price level in quarantine mode",
+			},
+			&cli.DurationFlag{
+				Name:  "staleexclude",
+				Value: 24 * time.Hour,
+				Usage: "leave an asset out of aggregation once its upstream last_updated has not advanced for this long, 0 only flags stale candles",
+			},
+			&cli.StringFlag{
+				Name:  "sources",
+				Usage: "fetch these sources concurrently, each name=url returning the coinmarketcap v1 ticker format (%d is replaced with the unix time); candles are stored per source with _group = name and a composite series with an empty _group; each source's raw ticks go to <tblname>_<name> and the composite to --tblname",
+			},
+			&cli.StringFlag{
+				Name:  "tradestreams",
+				Usage: "name=url pairs of HTTP streams sending one JSON trade per line ({\"asset_id\", \"symbol\", \"price\", \"amount\", \"time\"}, USD prices); candles with volume, trade count and VWAP are stored with _group = name",
+			},
+			&cli.StringFlag{
+				Name:  "consensus",
+				Value: "median",
+				Usage: "how the composite price is computed from the sources: median, or volume for the mean weighted by 24h volume",
+			},
+			&cli.Float64Flag{
+				Name:  "consensusdeviation",
+				Value: 0.05,
+				Usage: "leave a source out of the composite when its USD price deviates from the median of all sources by more than this",
+			},
+			&cli.IntFlag{
+				Name:  "quorum",
+				Value: 2,
+				Usage: "minimum number of agreeing sources for a composite price; assets below it are skipped for that tick",
+			},
+			&cli.StringFlag{
+				Name:  "fx",
+				Usage: "fiat exchange rates for --fxquotes: file:path of timestamp,currency,rate lines, or an http url returning {\"timestamp\", \"rates\": {\"EUR\": 0.83}}; rates are units of the currency per USD",
+			},
+			&cli.DurationFlag{
+				Name:  "fxinterval",
+				Value: time.Minute,
+				Usage: "how often --fx is read",
+			},
+			&cli.DurationFlag{
+				Name:  "fxmaxage",
+				Value: 72 * time.Hour,
+				Usage: "skip the conversion of a candle when the latest rate before its end is older than this",
+			},
+			&cli.StringFlag{
+				Name:  "fxquotes",
+				Usage: "convert USD candles into these fiat currencies with --fx rates and store them in the converted_candles table, e.g. EUR,JPY",
+			},
+			&cli.StringFlag{
+				Name:  "crossquotes",
+				Usage: "store candles of every asset quoted in these assets (by asset id, e.g. ethereum) in the converted_candles table, derived from the USD prices of both",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "save every upstream response with its time to daily jsonl files in this directory",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "feed recorded responses (a file or directory written by --record or --archiveresponses) through the pipeline instead of fetching; refused when the database already has data from the first recording on, use a separate database",
+			},
+			&cli.Float64Flag{
+				Name:  "replayspeed",
+				Value: 1,
+				Usage: "replay speed, 1 keeps the recorded intervals, 60 replays an hour per minute",
+			},
+			&cli.BoolFlag{
+				Name:  "retentionpartitions",
+				Usage: "drop expired partitions or chunks before deleting rows, when the storage supports it",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			level, err := parseLevel(c.String("loglevel"))
+			if err != nil {
+				return err
+			}
+			logger = newLogger(os.Stderr, level)
+			return nil
+		},
+		Commands: []cli.Command{
+			exportCommand,
+			importCommand,
+			archiveReplayCommand,
+			grpcCommand,
+			alertCommand,
 		},
 		Action: func(c *cli.Context) error {
 			// db config
 			drivername := c.String("drivername")
 			dbusername := c.String("dbusername")
 			dbname := c.String("dbname")
-			dbpassword := c.String("dbpassword")
 			dbhost := c.String("dbhost")
 			dbport := c.String("dbport")
 			interval := c.Duration("interval")
 			tblname := c.String("tblname")
 
-			log.Println("drivername:", drivername)
-			log.Println("dbusername:", dbusername)
-			log.Println("dbhost:", dbhost)
-			log.Println("dbname:", dbname)
-			log.Println("dbpassword:", dbpassword)
-			log.Println("dbport:", dbport)
-			log.Println("dbusername", dbusername)
-			log.Println("interval", interval)
-			log.Println("tblname", tblname)
+			logger.Info("config",
+				"drivername", drivername,
+				"dbusername", dbusername,
+				"dbhost", dbhost,
+				"dbname", dbname,
+				"dbport", dbport,
+				"interval", interval.String(),
+				"tblname", tblname)
 			table = tblname
 
-			db, err := sql.Open(drivername, fmt.Sprintf("user=%v password=%v host=%v dbname=%v port=%v sslmode=disable", dbusername, dbpassword, dbhost, dbname, dbport))
+			store, err := openStorage(c)
 			checkErr(err)
+			checkErr(store.Init(context.Background(), tblname))
+			policy, err := parseQueuePolicy(c.String("queuepolicy"))
+			checkErr(err)
+			qc := queueConfig{
+				size:     c.Int("queuesize"),
+				batch:    c.Int("queuebatch"),
+				policy:   policy,
+				spillDir: c.String("spilldir"),
+			}
 			ch := make(chan *kPriceCoinMarketCapList, 100)
 			rt := make(chan *kPriceCoinMarketCapList, 100)
-			timer := time.NewTicker(interval)
-			checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCap, tblname)))
-			go dispatch(db, ch, 100)
-			go realTimeAggregation(db, tblname, time.Now().Unix(), int64(interval), rt)
-			for t := range timer.C {
-				if resp, err := http.Get(fmt.Sprintf(coinMarketCapURL, t.Unix())); err == nil {
+			watchChannel("ch", ch)
+			watchChannel("rt", rt)
+			late, err := parseLatePolicy(c.String("latepolicy"))
+			checkErr(err)
+			lc := lateConfig{grace: int64(c.Duration("lategrace") / time.Second), policy: late}
+			// 回放时整个流水线都跑在录制时间上
+			var clk clock = realClock{}
+			var replay *scaledClock
+			var recordings []string
+			if path := c.String("replay"); path != "" {
+				if c.Float64("replayspeed") <= 0 {
+					return fmt.Errorf("--replayspeed must be positive")
+				}
+				recordings, err = recordingFiles(path)
+				checkErr(err)
+				first, err := firstRecording(recordings, coinMarketCapSource)
+				checkErr(err)
+				checkErr(checkReplayTarget(context.Background(), store, first, append([]string{tblname}, candleTables...)...))
+				replay = newScaledClock(first, c.Float64("replayspeed"))
+				clk = replay
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics)
+			newHealth(clk, store, c.Duration("freshcurrent"), c.Duration("freshmin")).register(mux)
+			mux.Handle("/graphql", newGraphQLHandler(store, clk, tblname, c.Int("graphqlcomplexity")))
+			mux.Handle("/indicators", &indicatorHandler{store: store, clk: clk})
+			go func() {
+				component("http").Error("listen", "err", http.ListenAndServe(c.String("httpaddr"), mux))
+			}()
+			var hooks []candleHook
+			if list := c.String("indicators"); list != "" {
+				specs, err := parseIndicatorSpecs(list)
+				checkErr(err)
+				dst, ok := store.(IndicatorStore)
+				if !ok {
+					return fmt.Errorf("--indicators is not supported by %v", drivername)
+				}
+				checkErr(dst.InitIndicators(context.Background()))
+				hooks = append(hooks, newIndicatorMaterializer(store, dst, specs))
+			}
+			if fiat, cross := splitList(c.String("fxquotes")), splitList(c.String("crossquotes")); len(fiat) > 0 || len(cross) > 0 {
+				dst, ok := store.(ConvertStore)
+				if !ok {
+					return fmt.Errorf("--fxquotes and --crossquotes are not supported by %v", drivername)
+				}
+				checkErr(dst.InitConverted(context.Background()))
+				conv := newQuoteConverter(newFXRates(c.Duration("fxmaxage"), c.Duration("fxmaxage")+time.Duration(week)*time.Second), fiat, cross, dst)
+				if len(fiat) > 0 {
+					if c.String("fx") == "" {
+						return fmt.Errorf("--fxquotes needs --fx")
+					}
+					brk := newBreaker(c.Int("breakerfailures"), c.Duration("breakercooldown"))
+					fetcher := newFetchClient("fx", c.Duration("fetchtimeout"), c.Int("fetchretries"), brk)
+					fetcher.maxWait = c.Duration("fxinterval")
+					p, err := parseFXProvider(c.String("fx"), fetcher)
+					checkErr(err)
+					go conv.poll(clk, p, c.Duration("fxinterval"))
+				}
+				hooks = append(hooks, conv)
+			}
+			go dispatch(clk, store, "", ch, 100, qc, lc, hooks)
+			feeds := []*sourceFeed{{name: coinMarketCapSource, url: coinMarketCapURL}}
+			if list := c.String("sources"); list != "" {
+				feeds, err = parseSources(list)
+				checkErr(err)
+				if len(feeds) == 0 {
+					return fmt.Errorf("--sources: no source given")
+				}
+			}
+			keep, err := parseRetention(c.String("retention"), tblname)
+			checkErr(err)
+			// 每个来源的原始数据表和 raw 一样保留. 归档只覆盖 raw, 来源的原始响应由 --archiveresponses 归档
+			if d, ok := keep[tblname]; ok && len(feeds) > 1 {
+				for _, f := range feeds {
+					keep[sourceRawTable(tblname, f.name)] = d
+				}
+			}
+			rp := retentionPolicy{
+				keep:           keep,
+				batch:          c.Int("retentionbatch"),
+				dropPartitions: c.Bool("retentionpartitions") || c.Bool("partitioned"),
+			}
+			if c.String("archive") != "" {
+				rp.archive, err = newArchiver(c, tblname)
+				checkErr(err)
+			}
+			if replay == nil {
+				go runRetention(realClock{}, store, rp, time.Hour)
+			} else {
+				// 保留期按当前时间计算, 会删掉刚回放的历史数据
+				logger.Info("retention disabled while replaying")
+			}
+			var hub *candleHub
+			if addr := c.String("grpcaddr"); addr != "" {
+				hub = newCandleHub()
+				go func() {
+					component("grpc").Error("listen", "err", serveGRPC(addr, newGRPCServer(store, hub)))
+				}()
+			}
+			var alerts *alertEngine
+			if c.Bool("alerts") && replay != nil {
+				// 回放的是历史价格, 不能发出真实的告警
+				logger.Info("alerts disabled while replaying")
+			} else if c.Bool("alerts") {
+				as, ok := store.(AlertStore)
+				if !ok {
+					return fmt.Errorf("--alerts is not supported by %v", drivername)
+				}
+				checkErr(as.InitAlerts(context.Background()))
+				alerts = newAlertEngine(as, c.String("alertwebhook"))
+				checkErr(alerts.reload(context.Background()))
+				go alerts.run(context.Background(), realClock{}, c.Duration("alertreload"))
+				go alerts.deliver(context.Background())
+			}
+			go realTimeAggregation(clk, store, tblname, clk.Now().Unix(), int64(interval), rt, hub, alerts)
+			// 单来源时和原来一样, 来源的数据就是 _group 为空的周期数据
+			source := feeds[0].name
+			if len(feeds) > 1 {
+				source = compositeSource
+				if replay != nil {
+					return fmt.Errorf("--replay supports a single source")
+				}
+			}
+			var recorders []*responseLog
+			if dir := c.String("record"); dir != "" {
+				l, err := newResponseLog(dir)
+				checkErr(err)
+				recorders = append(recorders, l)
+			}
+			if rp.archive != nil && rp.archive.responses != nil {
+				recorders = append(recorders, rp.archive.responses)
+			}
+			// newFilter 综合价格和每个来源各用一个, 价格窗口互不影响, 被过滤的数据写入同一张表
+			newFilter := func() *tickFilter { return nil }
+			if n := c.Int("filterwindow"); n > 0 {
+				mode, err := parseFilterMode(c.String("filtermode"))
+				checkErr(err)
+				var record func(*rejectedTick)
+				if rs, ok := store.(RejectStore); ok {
+					checkErr(rs.InitRejected(context.Background()))
+					q := newWriteQueue("rejected_ticks", qc, func(batch []*rejectedTick) error {
+						return rs.SaveRejected(context.Background(), batch...)
+					})
+					go q.run()
+					record = q.push
+				}
+				newFilter = func() *tickFilter {
+					f := newTickFilter(n, c.Float64("filterdeviation"), mode, c.Int("filterconfirm"))
+					f.record = record
+					return f
+				}
+			}
+			newRawQueue := func(tbl string) *writeQueue[*rawTicks] {
+				q := newWriteQueue(tbl, qc, func(batch []*rawTicks) error {
+					if err := store.InsertTicks(context.Background(), tbl, batch...); err != nil {
+						dbErrors.inc("insert")
+						return err
+					}
+					return nil
+				})
+				go q.run()
+				return q
+			}
+			for _, f := range feeds {
+				brk := newBreaker(c.Int("breakerfailures"), c.Duration("breakercooldown"))
+				fetcher := newFetchClient(f.name, c.Duration("fetchtimeout"), c.Int("fetchretries"), brk)
+				fetcher.maxWait = interval
+				flog, name := fetcher.log, f.name
+				if len(recorders) > 0 {
+					fetcher.record = func(r *responseRecord) {
+						for _, l := range recorders {
+							if err := l.record(r); err != nil {
+								flog.Error("record response failed", "err", err)
+							}
+						}
+					}
+				}
+				brk.onTransition = func(from, to breakerState) {
+					flog.Warn("breaker transition", "from", from.String(), "to", to.String())
+					breakerTransitions.inc(name, to.String())
+				}
+				f.fetcher = fetcher
+				if len(feeds) > 1 {
+					f.ch = make(chan *kPriceCoinMarketCapList, 100)
+					f.stale = newStaleTracker(f.name, 0)
+					f.filter = newFilter()
+					checkErr(store.Init(context.Background(), sourceRawTable(tblname, f.name)))
+					f.raw = newRawQueue(sourceRawTable(tblname, f.name))
+					watchChannel("ch_"+f.name, f.ch)
+					go dispatch(clk, store, f.name, f.ch, 100, qc, lc, nil)
+				}
+			}
+			if list := c.String("tradestreams"); list != "" {
+				streams, err := parseSources(list)
+				checkErr(err)
+				for _, f := range streams {
+					for _, g := range feeds {
+						if len(feeds) > 1 && g.name == f.name {
+							return fmt.Errorf("--tradestreams: %v is also a price source", f.name)
+						}
+					}
+					tch := make(chan *kPriceCoinMarketCapList, 100)
+					watchChannel("trades_"+f.name, tch)
+					go dispatch(clk, store, f.name, tch, 100, qc, lc, nil)
+					go newTradeStream(f.name, f.url).run(clk, interval, tch)
+				}
+			}
+			flog := component("fetch", "source", source)
+			raw := newRawQueue(tblname)
+			filter := newFilter()
+			stale := newStaleTracker(source, c.Duration("staleexclude"))
+			emit := func(ts int64, list []priceCoinMarketCap) {
+				flog.Debug("fetched", "assets", len(list))
+				assetsPerTick.set(float64(len(list)), source)
+				// 原始数据照常写入 ticks 表, 过滤后的才参与聚合. 多来源时这里是综合价格,
+				// 每个来源返回的数据在 fetchTick 中写入各自的表
+				x := newKPriceCoinMarketCapList(filter.apply(source, ts, list), ts)
+				stale.apply(x)
+				ch <- x.Copy()
+				rt <- x.Copy()
+				// 更新实时数据
+				raw.push(&rawTicks{List: list, Timestamp: ts})
+			}
+			if replay != nil {
+				checkErr(replayResponses(context.Background(), replay, recordings, source, emit))
+				// 回放时钟继续走, 之后结束的周期照常写入, 用 Ctrl-C 退出
+				select {}
+			}
+			var cons *consensus
+			if len(feeds) > 1 {
+				method, err := parseConsensusMethod(c.String("consensus"))
+				checkErr(err)
+				cons = newConsensus(method, c.Float64("consensusdeviation"), c.Int("quorum"))
+			}
+			fetchTick := func(ctx context.Context, ts int64) {
+				if cons == nil {
 					var list []priceCoinMarketCap
-					dec := json.NewDecoder(resp.Body)
-					if err := dec.Decode(&list); err != nil {
-						log.Println("decode error ", err)
+					if err := feeds[0].fetcher.getJSON(ctx, feeds[0].urlAt(ts), &list); err != nil {
+						flog.Error("fetch failed", "err", err)
+						return
+					}
+					emit(ts, list)
+					return
+				}
+				lists := fetchAll(ctx, feeds, ts)
+				names := make([]string, len(feeds))
+				for i, f := range feeds {
+					names[i] = f.name
+					if lists[i] == nil {
 						continue
 					}
-					x := newKPriceCoinMarketCapList(list, t.Unix())
-					ch <- x.Copy()
-					rt <- x.Copy()
-					// 更新实时数据
-					go func(x *kPriceCoinMarketCapList, list []priceCoinMarketCap) {
-						if err := insert(db, tblname, list); err != nil {
-							log.Println(err)
-						}
-					}(x, list)
-
-				} else {
-					log.Println(err)
+					assetsPerTick.set(float64(len(lists[i])), f.name)
+					f.raw.push(&rawTicks{List: lists[i], Timestamp: ts})
+					x := newKPriceCoinMarketCapList(f.filter.apply(f.name, ts, lists[i]), ts)
+					f.stale.apply(x)
+					f.ch <- x
+				}
+				if list := cons.combine(names, lists); len(list) > 0 {
+					emit(ts, list)
 				}
 			}
+			timer := time.NewTicker(interval)
+			for t := range timer.C {
+				// 一次抓取连同重试不超过一个抓取周期, 不耽误下一次抓取和实时数据
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				fetchTick(ctx, t.Unix())
+				cancel()
+			}
 			return nil
 		},
 	}
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		logger.Error("exit", "err", err)
+		os.Exit(1)
+	}
 }
 
-func dispatch(db *sql.DB, ch <-chan *kPriceCoinMarketCapList, size int) {
+// dispatch 启动各周期的汇总, group 为写入周期表的 _group, 采集程序自己的数据为空
+func dispatch(clk clock, store storage, group string, ch <-chan *kPriceCoinMarketCapList, size int, qc queueConfig, lc lateConfig, hooks []candleHook) {
 	var ch1 = make(chan *kPriceCoinMarketCapList, size)
 	var ch2 = make(chan *kPriceCoinMarketCapList, size)
 	var ch3 = make(chan *kPriceCoinMarketCapList, size)
@@ -286,6 +819,13 @@ func dispatch(db *sql.DB, ch <-chan *kPriceCoinMarketCapList, size int) {
 	var ch5 = make(chan *kPriceCoinMarketCapList, size)
 	var ch6 = make(chan *kPriceCoinMarketCapList, size)
 	var ch7 = make(chan *kPriceCoinMarketCapList, size)
+	watchChannel(groupName(coinmarketcap5min, group), ch1)
+	watchChannel(groupName(coinmarketcap10min, group), ch2)
+	watchChannel(groupName(coinmarketcap15min, group), ch3)
+	watchChannel(groupName(coinmarketcap30min, group), ch4)
+	watchChannel(groupName(coinmarketcaphour, group), ch5)
+	watchChannel(groupName(coinmarketcapday, group), ch6)
+	watchChannel(groupName(coinmarketcapweek, group), ch7)
 
 	/*
 		var r1 = make(chan *kPriceCoinMarketCapList, size)
@@ -298,102 +838,138 @@ func dispatch(db *sql.DB, ch <-chan *kPriceCoinMarketCapList, size int) {
 		var r8 = make(chan *kPriceCoinMarketCapList, size)
 	*/
 
-	tm := time.Now()
-	now := tm.Unix()
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcapmin,
-		coinmarketcapmin, coinmarketcapmin,
-		coinmarketcapmin, coinmarketcapmin,
-		coinmarketcapmin, coinmarketcapmin)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcap5min,
-		coinmarketcap5min, coinmarketcap5min,
-		coinmarketcap5min, coinmarketcap5min,
-		coinmarketcap5min, coinmarketcap5min)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcap10min,
-		coinmarketcap10min, coinmarketcap10min,
-		coinmarketcap10min, coinmarketcap10min,
-		coinmarketcap10min, coinmarketcap10min)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcap30min,
-		coinmarketcap30min, coinmarketcap30min,
-		coinmarketcap30min, coinmarketcap30min,
-		coinmarketcap30min, coinmarketcap30min)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcap15min,
-		coinmarketcap15min, coinmarketcap15min,
-		coinmarketcap15min, coinmarketcap15min,
-		coinmarketcap15min, coinmarketcap15min)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcapday,
-		coinmarketcapday, coinmarketcapday,
-		coinmarketcapday, coinmarketcapday,
-		coinmarketcapday, coinmarketcapday)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcaphour,
-		coinmarketcaphour, coinmarketcaphour,
-		coinmarketcaphour, coinmarketcaphour,
-		coinmarketcaphour, coinmarketcaphour)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcapweek,
-		coinmarketcapweek, coinmarketcapweek,
-		coinmarketcapweek, coinmarketcapweek,
-		coinmarketcapweek, coinmarketcapweek)))
-	checkErr(createTable(db, fmt.Sprintf(tblCoinMarketCapXmin, coinmarketcapcurrent,
-		coinmarketcapcurrent, coinmarketcapcurrent,
-		coinmarketcapcurrent, coinmarketcapcurrent,
-		coinmarketcapcurrent, coinmarketcapcurrent)))
-
+	// 其他周期的数据来自一分钟周期, 要等一分钟周期先关闭, 多等一个 grace 和一次心跳
+	up := lateConfig{grace: 2*lc.grace + 1, policy: lc.policy}
 	//  数据全部由一分钟数据出减少等待误差
-	go aggregation(db, now-now%min+min, min, coinmarketcapmin, ch, ch1, ch2, ch3, ch4, ch5, ch6, ch7) // r1, r2, r3, r4, r5, r6, r7, r8)
+	go aggregation(clk, candleQueue(store, coinmarketcapmin, group, qc, hooks), coinmarketcapmin, lc, ch, ch1, ch2, ch3, ch4, ch5, ch6, ch7) // r1, r2, r3, r4, r5, r6, r7, r8)
 
 	// go realTimeAggregation(db, coinmarketcapmin, now-now%min+min, min, r1)
 
-	go aggregation(db, now+min5-now%min5, min5, coinmarketcap5min, ch1)
+	go aggregation(clk, candleQueue(store, coinmarketcap5min, group, qc, hooks), coinmarketcap5min, up, ch1)
 	// go realTimeAggregation(db, coinmarketcap5min, now-now%min5+min5, min5, r2)
 
-	go aggregation(db, now+min10-now%min10, min10, coinmarketcap10min, ch2)
+	go aggregation(clk, candleQueue(store, coinmarketcap10min, group, qc, hooks), coinmarketcap10min, up, ch2)
 	// go realTimeAggregation(db, coinmarketcap10min, now-now%min10+min10, min10, r3)
 
-	go aggregation(db, now+min15-now%min15, min15, coinmarketcap15min, ch3)
+	go aggregation(clk, candleQueue(store, coinmarketcap15min, group, qc, hooks), coinmarketcap15min, up, ch3)
 	// go realTimeAggregation(db, coinmarketcap15min, now-now%min15+min15, min15, r4)
 
-	go aggregation(db, now+min30-now%min30, min30, coinmarketcap30min, ch4)
+	go aggregation(clk, candleQueue(store, coinmarketcap30min, group, qc, hooks), coinmarketcap30min, up, ch4)
 	// go realTimeAggregation(db, coinmarketcap30min, now-now%min30+min30, min30, r5)
 
-	go aggregation(db, now+hour-now%hour, hour, coinmarketcaphour, ch5)
+	go aggregation(clk, candleQueue(store, coinmarketcaphour, group, qc, hooks), coinmarketcaphour, up, ch5)
 	// go realTimeAggregation(db, coinmarketcaphour, now-now%hour+hour, hour, r6)
 
-	go aggregation(db, now+day-now%day, day, coinmarketcapday, ch6)
+	go aggregation(clk, candleQueue(store, coinmarketcapday, group, qc, hooks), coinmarketcapday, up, ch6)
 	// go realTimeAggregation(db, coinmarketcapday, now-now%day+day, day, r7)
 
-	weekday := tm.Weekday()
-	if weekday == time.Sunday {
-		// 星期天统计星期天这天的就行了
-		go aggregation(db, now+(day-now%day), week, coinmarketcapweek, ch7)
-		//	go realTimeAggregation(db, coinmarketcapweek, now-now%day+day, week, r7)
-	} else {
-		x := int64(time.Saturday-weekday) + 2
-		go aggregation(db, now+day-(now%day)+x*day, week, coinmarketcapweek, ch7)
-		//	go realTimeAggregation(db, coinmarketcapweek, now-now%day+day+x*day, week, r7)
-	}
+	go aggregation(clk, candleQueue(store, coinmarketcapweek, group, qc, hooks), coinmarketcapweek, up, ch7)
+	//	go realTimeAggregation(db, coinmarketcapweek, weekBase(now), week, r7)
+}
 
-	go func() {
-		ticker := time.NewTicker(time.Hour)
-		for {
-			select {
-			case now := <-ticker.C:
-				exec(db, fmt.Sprintf("delete from %v where last_updated < $1;", table), fmt.Sprint(now.Unix()-7*24*3600))
+// weekBase 周线从星期一 0 点 (UTC) 开始, 返回 now 之后的第一个星期一
+func weekBase(now int64) int64 {
+	next := now - now%day + day
+	// 1970-01-01 是星期四
+	weekday := time.Weekday((next/day + int64(time.Thursday)) % 7)
+	return next + int64((7+time.Monday-weekday)%7)*day
+}
+
+// candleHook 周期表写入成功后处理 _group 为空的数据, 如物化指标, 换算计价货币
+type candleHook interface {
+	name() string
+	closed(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error
+	// amended 迟到数据修正的周期替换之后调用, 同一周期只传最后一次修正
+	amended(ctx context.Context, tbl string, batch []*kPriceCoinMarketCapList) error
+}
+
+// candleQueue 为周期表建立写队列并启动 writer, 写入成功后依次调用 hooks.
+// 修正的周期在同一队列里排在原来的周期之后, 用 UpsertCandles 替换后调用 hooks 的 amended
+func candleQueue(store CandleStore, tbl, group string, qc queueConfig, hooks []candleHook) *writeQueue[*kPriceCoinMarketCapList] {
+	q := newWriteQueue(groupName(tbl, group), qc, func(batch []*kPriceCoinMarketCapList) error {
+		var closed, amended []*kPriceCoinMarketCapList
+		for _, dat := range batch {
+			if dat.amended {
+				amended = append(amended, dat)
+			} else {
+				closed = append(closed, dat)
 			}
 		}
-	}()
+		if len(closed) > 0 {
+			start := time.Now()
+			if err := store.SaveCandles(context.Background(), tbl, group, closed...); err != nil {
+				dbErrors.inc("save_candle")
+				return err
+			}
+			candleWrite.since(start, tbl)
+		}
+		// 修正只尽力而为, 失败时不重试, 否则整批重写会重复插入已经写入的周期
+		amended = latestAmendments(amended)
+		if err := amendCandles(store, tbl, group, amended); err != nil {
+			dbErrors.inc("amend_candle")
+			component("aggregation", "table", tbl).Error("amend failed", "group", group, "err", err)
+			amended = nil
+		}
+		if group != "" {
+			return nil
+		}
+		if len(closed) > 0 {
+			lastBucket.set(float64(closed[len(closed)-1].timestamp), tbl)
+		}
+		for _, h := range hooks {
+			if len(closed) > 0 {
+				if err := h.closed(context.Background(), tbl, closed); err != nil {
+					dbErrors.inc("save_" + h.name())
+					component(h.name()).Error("save failed", "table", tbl, "err", err)
+				}
+			}
+			if len(amended) > 0 {
+				if err := h.amended(context.Background(), tbl, amended); err != nil {
+					dbErrors.inc("amend_" + h.name())
+					component(h.name()).Error("amend failed", "table", tbl, "err", err)
+				}
+			}
+		}
+		return nil
+	})
+	go q.run()
+	return q
 }
 
-func exec(db *sql.DB, sql string, args ...interface{}) error {
-	stmt, err := db.Prepare(sql)
-	if err != nil {
-		log.Println("Prepare err:", err)
-		return err
+// latestAmendments 同一周期修正多次时只保留最后一次, 按第一次出现的顺序排列
+func latestAmendments(dats []*kPriceCoinMarketCapList) []*kPriceCoinMarketCapList {
+	latest := make(map[int64]int)
+	var ret []*kPriceCoinMarketCapList
+	for _, dat := range dats {
+		if i, ok := latest[dat.timestamp]; ok {
+			ret[i] = dat
+			continue
+		}
+		latest[dat.timestamp] = len(ret)
+		ret = append(ret, dat)
 	}
-	defer stmt.Close()
-	if _, err = stmt.Exec(args...); err != nil {
-		log.Println("del err:", err)
-		return err
+	return ret
+}
+
+func amendCandles(store CandleStore, tbl, group string, dats []*kPriceCoinMarketCapList) error {
+	if len(dats) == 0 {
+		return nil
 	}
-	return nil
+	var rows []*candleRow
+	for _, dat := range dats {
+		for i := range dat.list {
+			rows = append(rows, newCandleRow(&dat.list[i], dat.timestamp, group))
+		}
+	}
+	return store.UpsertCandles(context.Background(), tbl, rows...)
+}
+
+// groupName 队列和指标中区分不同 _group 的同一张表
+func groupName(tbl, group string) string {
+	if group == "" {
+		return tbl
+	}
+	return tbl + "_" + group
 }
 
 func summary(x, y *kPriceCoinMarketCapList) {
@@ -405,118 +981,84 @@ func summary(x, y *kPriceCoinMarketCapList) {
 				x.list[i].PriceBTCLast = v.PriceBTCLast
 				x.list[i].PriceUSDLast = v.PriceUSDLast
 				x.list[i].LastUpdated = v.LastUpdated
+				x.list[i].VolumeUSD24H = v.VolumeUSD24H
 				x.list[i].Rank = v.Rank
+				summaryRange(&x.list[i], &v)
+			}
+		}
+	}
+}
 
-				if x.list[i].PriceBTCLow.Cmp(&v.PriceBTCLow) > 0 {
-					x.list[i].PriceBTCLow = v.PriceBTCLow
-				}
-
-				if x.list[i].PriceBTCHigh.Cmp(&v.PriceBTCHigh) < 0 {
-					x.list[i].PriceBTCHigh = v.PriceBTCHigh
+// summaryEarlier 汇总比 x 中已有数据更早的 y, 不改变收盘价. y 早于 x 的第一次抓取时更新开盘价,
+// moveFirst 时 x.timestamp 也改为 y 的
+func summaryEarlier(x, y *kPriceCoinMarketCapList, moveFirst bool) {
+	first := y.timestamp < x.timestamp
+	for i := range x.list {
+		for _, v := range y.list {
+			if x.list[i].Symbol == v.Symbol {
+				if first {
+					x.list[i].PriceCNYFirst = v.PriceCNYFirst
+					x.list[i].PriceBTCFirst = v.PriceBTCFirst
+					x.list[i].PriceUSDFirst = v.PriceUSDFirst
 				}
+				summaryRange(&x.list[i], &v)
+			}
+		}
+	}
+	if first && moveFirst {
+		x.timestamp = y.timestamp
+	}
+}
 
-				if x.list[i].PriceCNYLow.Cmp(&v.PriceCNYLow) > 0 {
-					x.list[i].PriceCNYLow = v.PriceCNYLow
-				}
+// summaryRange 和先后无关的部分: 高低价, 成交量, Stale
+func summaryRange(x, v *kPriceCoinMarketCap) {
+	x.Stale = x.Stale && v.Stale
+	x.VolumeBase += v.VolumeBase
+	x.VolumeQuote += v.VolumeQuote
+	x.Trades += v.Trades
 
-				if x.list[i].PriceCNYHigh.Cmp(&v.PriceCNYHigh) < 0 {
-					x.list[i].PriceCNYHigh = v.PriceCNYHigh
-				}
+	if x.PriceBTCLow.Cmp(&v.PriceBTCLow) > 0 {
+		x.PriceBTCLow = v.PriceBTCLow
+	}
 
-				if x.list[i].PriceUSDLow.Cmp(&v.PriceUSDLow) > 0 {
-					x.list[i].PriceUSDLow = v.PriceUSDLow
-				}
+	if x.PriceBTCHigh.Cmp(&v.PriceBTCHigh) < 0 {
+		x.PriceBTCHigh = v.PriceBTCHigh
+	}
 
-				if x.list[i].PriceUSDHigh.Cmp(&v.PriceUSDHigh) < 0 {
-					x.list[i].PriceUSDHigh = v.PriceUSDHigh
-				}
-			}
-		}
+	if x.PriceCNYLow.Cmp(&v.PriceCNYLow) > 0 {
+		x.PriceCNYLow = v.PriceCNYLow
 	}
-}
 
-func upsertCoinMarketCapCurrent(db *sql.DB, tblname string, dat *kPriceCoinMarketCapList) error {
-	return tx(db, func(txn *sql.Tx) error {
-		if _, err := txn.Exec(fmt.Sprintf("delete from %v where _group = $1 ;", coinmarketcapcurrent), tblname); err != nil {
-			return err
-		}
-		stmt, err := txn.Prepare(pq.CopyIn(coinmarketcapcurrent, "asset_id", "name", "symbol",
-			"rank",
-			"price_usd_first",
-			"price_usd_last",
-			"price_usd_low",
-			"price_usd_high",
-			"price_btc_first",
-			"price_btc_last",
-			"price_btc_low",
-			"price_btc_high",
-			"price_cny_first",
-			"price_cny_last",
-			"price_cny_low",
-			"price_cny_high",
-			"last_updated",
-			"timestamp",
-			"_group"))
-		if err != nil {
-			return err
-		}
+	if x.PriceCNYHigh.Cmp(&v.PriceCNYHigh) < 0 {
+		x.PriceCNYHigh = v.PriceCNYHigh
+	}
 
-		for _, v := range dat.list {
-			a1, _ := v.PriceUSDFirst.Float64()
-			a2, _ := v.PriceUSDLast.Float64()
-			a3, _ := v.PriceUSDLow.Float64()
-			a4, _ := v.PriceUSDHigh.Float64()
-			b1, _ := v.PriceBTCFirst.Float64()
-			b2, _ := v.PriceBTCLast.Float64()
-			b3, _ := v.PriceBTCLow.Float64()
-			b4, _ := v.PriceBTCHigh.Float64()
-			c1, _ := v.PriceCNYFirst.Float64()
-			c2, _ := v.PriceCNYLast.Float64()
-			c3, _ := v.PriceCNYLow.Float64()
-			c4, _ := v.PriceCNYHigh.Float64()
-			_, err := stmt.Exec(v.Id,
-				v.Name,
-				v.Symbol,
-				v.Rank.Int64(),
-				a1, a2, a3, a4,
-				b1, b2, b3, b4,
-				c1, c2, c3, c4,
-				v.LastUpdated.Int64(),
-				dat.timestamp,
-				tblname)
-			if err != nil {
-				return err
-			}
-		}
-		_, err = stmt.Exec()
-		if err != nil {
-			return err
-		}
-		err = stmt.Close()
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	if x.PriceUSDLow.Cmp(&v.PriceUSDLow) > 0 {
+		x.PriceUSDLow = v.PriceUSDLow
+	}
 
+	if x.PriceUSDHigh.Cmp(&v.PriceUSDHigh) < 0 {
+		x.PriceUSDHigh = v.PriceUSDHigh
+	}
 }
 
 // 实时数据汇总
-func realTimeAggregation(db *sql.DB, tblname string, base, interval int64, in <-chan *kPriceCoinMarketCapList) {
+func realTimeAggregation(clk clock, store CandleStore, tblname string, base, interval int64, in <-chan *kPriceCoinMarketCapList, hub *candleHub, alerts *alertEngine) {
 	var current *kPriceCoinMarketCapList
 	var tmp *kPriceCoinMarketCapList
 	var resetCurrent = base
 	var resetCurrentInterval = interval
+	var rlog = component("realtime", "table", coinmarketcapcurrent, "group", tblname)
 	// 实时数据重置 至少单位为一天
 	if interval < day {
 		resetCurrent = base - base%day + day
 		resetCurrentInterval = day
 	}
 
-	ticker := time.NewTicker(time.Second)
+	ticker := clk.NewTicker(time.Second)
 	for {
 		select {
-		case t := <-ticker.C:
+		case t := <-ticker.C():
 			// 更新first
 			if tmp == nil {
 				continue
@@ -528,6 +1070,8 @@ func realTimeAggregation(db *sql.DB, tblname string, base, interval int64, in <-
 
 			if t.Unix() >= base {
 				base += interval
+				// tmp 已经汇总进 current, 成交量不能再加一次
+				tmp.resetVolume()
 				summary(tmp, current)
 				current = tmp
 			}
@@ -538,208 +1082,123 @@ func realTimeAggregation(db *sql.DB, tblname string, base, interval int64, in <-
 			resetCurrent += resetCurrentInterval
 			current = nil
 		case x := <-in: // 一旦有数据变动更新
+			alerts.evaluate(x)
 			tmp = x.Copy()
 			if current == nil {
 				current = x
 			} else {
 				summary(current, x)
 			}
-			if err := upsertCoinMarketCapCurrent(db, tblname, current); err != nil {
-				log.Println("tx error ", err)
+			if err := store.ReplaceCurrent(context.Background(), tblname, current); err != nil {
+				dbErrors.inc("upsert_current")
+				rlog.Error("upsert failed", "err", err)
 			}
+			hub.publishList(tblname, current)
 		}
 	}
 }
 
-func aggregation(db *sql.DB, base, interval int64, tbl string, in <-chan *kPriceCoinMarketCapList, outs ...chan<- *kPriceCoinMarketCapList) {
-	var tmp *kPriceCoinMarketCapList
-	// 实时数据
-	ticker := time.NewTicker(time.Second)
-	next := base
-	// 把误差控制在0s内
-	for {
-		select {
-		case t := <-ticker.C: // beatheart
-			if now := t.Unix(); now < next {
-				continue
-			}
-			next += interval
-			select {
-			case v := <-in:
-				if tmp == nil {
-					tmp = v
-				} else {
-					summary(tmp, v) // 数据汇总
-				}
-			case <-time.After(3 * time.Second):
-			}
+type latePolicy int
 
-			if tmp == nil {
-				continue
-			}
-			if len(outs) > 0 {
-				for _, ch := range outs {
-					// dump
-					ch <- tmp.Copy()
-				}
-			}
+const (
+	// lateAmend 修正已经写入的周期
+	lateAmend latePolicy = iota
+	// lateDrop 丢弃
+	lateDrop
+)
 
-			now := time.Now()
-			saveKPriceCoinMarketCap(db, tbl, "", tmp)
-			fmt.Println(tbl, ":", time.Since(now))
-			tmp = nil
-		case v := <-in:
-			if tmp == nil {
-				tmp = v
-				continue
-			}
-			summary(tmp, v)
-		}
+func parseLatePolicy(s string) (latePolicy, error) {
+	switch s {
+	case "amend":
+		return lateAmend, nil
+	case "drop":
+		return lateDrop, nil
 	}
+	return 0, fmt.Errorf("unknown late policy %q", s)
 }
 
-func tx(db *sql.DB, fn func(tx *sql.Tx) error) error {
-	txn, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	if err := fn(txn); err != nil {
-		txn.Rollback()
-		return err
-	}
-	return txn.Commit()
+// lateConfig 周期结束后再等 grace 秒才关闭, 关闭之后才到的数据按 policy 处理
+type lateConfig struct {
+	grace  int64
+	policy latePolicy
 }
 
-func saveKPriceCoinMarketCap(db *sql.DB, tblname, group string, dat *kPriceCoinMarketCapList) error {
-	txn, err := db.Begin()
-	if err != nil {
-		log.Println("save error", err)
-		return err
-	}
-	stmt, err := txn.Prepare(pq.CopyIn(tblname, "asset_id", "name", "symbol",
-		"rank",
-		"price_usd_first",
-		"price_usd_last",
-		"price_usd_low",
-		"price_usd_high",
-		"price_btc_first",
-		"price_btc_last",
-		"price_btc_low",
-		"price_btc_high",
-		"price_cny_first",
-		"price_cny_last",
-		"price_cny_low",
-		"price_cny_high",
-		"last_updated",
-		"timestamp",
-		"_group"))
-	if err != nil {
-		txn.Rollback()
-		log.Println("save error", err)
-		return err
-	}
-
-	for _, v := range dat.list {
-		a1, _ := v.PriceUSDFirst.Float64()
-		a2, _ := v.PriceUSDLast.Float64()
-		a3, _ := v.PriceUSDLow.Float64()
-		a4, _ := v.PriceUSDHigh.Float64()
-		b1, _ := v.PriceBTCFirst.Float64()
-		b2, _ := v.PriceBTCLast.Float64()
-		b3, _ := v.PriceBTCLow.Float64()
-		b4, _ := v.PriceBTCHigh.Float64()
-		c1, _ := v.PriceCNYFirst.Float64()
-		c2, _ := v.PriceCNYLast.Float64()
-		c3, _ := v.PriceCNYLow.Float64()
-		c4, _ := v.PriceCNYHigh.Float64()
-		_, err := stmt.Exec(v.Id,
-			v.Name,
-			v.Symbol,
-			v.Rank.Int64(),
-			a1, a2, a3, a4,
-			b1, b2, b3, b4,
-			c1, c2, c3, c4,
-			v.LastUpdated.Int64(),
-			dat.timestamp,
-			group)
-		if err != nil {
-			txn.Rollback()
-			log.Println(err, v)
-			return err
-		}
+// merge 按 timestamp 先后把 v 汇总进 x 并返回, x 为 nil 时返回 v. 乱序到达的数据不会覆盖收盘价,
+// 已关闭的周期 timestamp 是写入的行的键, 不再改变
+func merge(x, v *kPriceCoinMarketCapList, closed bool) *kPriceCoinMarketCapList {
+	if x == nil {
+		return v
 	}
-	_, err = stmt.Exec()
-	if err != nil {
-		txn.Rollback()
-		log.Println("save error", err)
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		txn.Rollback()
-		log.Fatal(err)
-		return err
+	if v.timestamp >= x.lastAt() {
+		summary(x, v)
+		x.last = v.lastAt()
+		return x
 	}
-	return txn.Commit()
+	summaryEarlier(x, v, !closed)
+	return x
 }
 
-func insert(db *sql.DB, tblname string, list []priceCoinMarketCap) error {
-	txn, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	// 事务
-	stmt, err := txn.Prepare(pq.CopyIn(tblname,
-		"asset_id",
-		"name",
-		"symbol",
-		"rank",
-		"price_usd",
-		"price_btc",
-		"volume_usd_24h",
-		"market_cap_usd",
-		"available_supply",
-		"total_supply",
-		"percent_change_1h",
-		"percent_change_24h",
-		"percent_change_7d",
-		"last_updated",
-		"price_cny",
-		"volume_cny_24h",
-		"market_cap_cny"))
-	if err != nil {
-		txn.Rollback()
-		return err
-	}
-
-	for _, v := range list {
-		_, err := stmt.Exec(v.Id, v.Name, v.Symbol, v.Rank, v.PriceUSD, v.PriceBTC, v.VolumeUSD24H, v.MarketCapUSD, v.AvailableSupply,
-			v.TotalSupply, v.PercentChanage1H, v.PercentChanage24H, v.PercentChanage7D, v.LastUpdated, v.PriceCNY, v.VolumeCNY24H, v.MarketCapCNY)
-
-		if err != nil {
-			txn.Rollback()
-			log.Println(err, v)
-			return err
+// aggregation 按每条数据自己的 timestamp 归入周期, 周期结束 grace 秒后关闭, 送给 outs 并写入.
+// 关闭之后才到的数据按 policy 修正已写入的周期或丢弃, 修正时把这条数据继续送给 outs,
+// 由更长的周期自己汇总或修正. 只保留最近一个周期宽度内关闭的周期, 更早的迟到数据直接丢弃
+func aggregation(clk clock, q *writeQueue[*kPriceCoinMarketCapList], tbl string, lc lateConfig, in <-chan *kPriceCoinMarketCapList, outs ...chan<- *kPriceCoinMarketCapList) {
+	var alog = component("aggregation", "timeframe", timeframes[tbl], "table", tbl)
+	width := candleWidths[tbl]
+	open := make(map[int64]*kPriceCoinMarketCapList)
+	closed := make(map[int64]*kPriceCoinMarketCapList)
+	// 心跳, 用来关闭周期
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+	now := clk.Now().Unix()
+	for {
+		select {
+		case t := <-ticker.C():
+			now = t.Unix()
+			var due []int64
+			for start := range open {
+				if start+width+lc.grace <= now {
+					due = append(due, start)
+				}
+			}
+			sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+			for _, start := range due {
+				x := open[start]
+				delete(open, start)
+				for _, ch := range outs {
+					ch <- x.Copy()
+				}
+				alog.Debug("closed", "bucket", x.timestamp, "assets", len(x.list))
+				q.push(x.Copy())
+				closed[start] = x
+			}
+			for start := range closed {
+				if start+2*width+lc.grace <= now {
+					delete(closed, start)
+				}
+			}
+		case v := <-in:
+			start := bucketStart(tbl, v.timestamp)
+			if start+width+lc.grace > now {
+				open[start] = merge(open[start], v, false)
+				continue
+			}
+			x := closed[start]
+			if lc.policy == lateDrop || x == nil {
+				lateTicks.inc(tbl, "dropped")
+				alog.Debug("late tick dropped", "timestamp", v.timestamp, "bucket", start)
+				continue
+			}
+			lateTicks.inc(tbl, "amended")
+			alog.Debug("late tick amended", "timestamp", v.timestamp, "bucket", x.timestamp)
+			for _, ch := range outs {
+				ch <- v.Copy()
+			}
+			merge(x, v, true)
+			amended := x.Copy()
+			amended.amended = true
+			q.push(amended)
 		}
 	}
-	_, err = stmt.Exec()
-	if err != nil {
-		txn.Rollback()
-		log.Fatal(err)
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		txn.Rollback()
-		return err
-	}
-	return txn.Commit()
-}
-
-func createTable(db *sql.DB, sql string) error {
-	_, err := db.Exec(sql)
-	_ = err
-	return nil
 }
 
 func checkErr(err error) {
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"market/candles"
+)
+
+func testTick(ts int64, usd float64) *kPriceCoinMarketCapList {
+	return newKPriceCoinMarketCapList([]priceCoinMarketCap{{
+		Id:          "bitcoin",
+		Name:        "Bitcoin",
+		Symbol:      "BTC",
+		Rank:        "1",
+		PriceUSD:    strconv.FormatFloat(usd, 'f', -1, 64),
+		PriceBTC:    "1",
+		PriceCNY:    strconv.FormatFloat(usd*7, 'f', -1, 64),
+		LastUpdated: strconv.FormatInt(ts, 10),
+	}}, ts)
+}
+
+type testPipeline struct {
+	clk   *fakeClock
+	store *memoryStore
+	ch    chan *kPriceCoinMarketCapList
+}
+
+func startPipeline(start time.Time) *testPipeline {
+	return startPipelineLate(start, lateConfig{grace: 3, policy: lateAmend})
+}
+
+func startPipelineLate(start time.Time, lc lateConfig) *testPipeline {
+	p := &testPipeline{
+		clk:   newFakeClock(start),
+		store: newMemoryStore(),
+		// 不带缓冲, 发送返回时 aggregation 已经取走数据
+		ch: make(chan *kPriceCoinMarketCapList),
+	}
+	dispatch(p.clk, p.store, "", p.ch, 0, queueConfig{size: 100, batch: 1}, lc, nil)
+	p.clk.waitTickers(8)
+	return p
+}
+
+// feed 每 10 秒推进一次时钟并送入一条数据, 直到 end. 最后再走 10 秒, 让 end 结束的周期过了 grace 关闭
+func (p *testPipeline) feed(end time.Time, price func(ts int64) float64) {
+	for p.clk.Now().Before(end) {
+		p.clk.Advance(10 * time.Second)
+		ts := p.clk.Now().Unix()
+		p.ch <- testTick(ts, price(ts))
+	}
+	p.clk.Advance(10 * time.Second)
+}
+
+func (p *testPipeline) wait(t *testing.T, tbl string, n int) []*kPriceCoinMarketCapList {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := p.store.Candles(tbl)
+		if len(got) >= n || time.Now().After(deadline) {
+			if len(got) != n {
+				t.Fatalf("%v: got %d candles, want %d", tbl, len(got), n)
+			}
+			return got
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type wantCandle struct {
+	timestamp              time.Time
+	first, last, low, high float64
+}
+
+func checkCandle(t *testing.T, tbl string, got *kPriceCoinMarketCapList, want wantCandle) {
+	t.Helper()
+	if got.timestamp != want.timestamp.Unix() {
+		t.Errorf("%v: timestamp %v, want %v", tbl, time.Unix(got.timestamp, 0).UTC(), want.timestamp)
+	}
+	if len(got.list) != 1 {
+		t.Fatalf("%v: %d assets, want 1", tbl, len(got.list))
+	}
+	v := got.list[0]
+	for _, c := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"first", toFloat(&v.PriceUSDFirst), want.first},
+		{"last", toFloat(&v.PriceUSDLast), want.last},
+		{"low", toFloat(&v.PriceUSDLow), want.low},
+		{"high", toFloat(&v.PriceUSDHigh), want.high},
+		{"cny high", toFloat(&v.PriceCNYHigh), want.high * 7},
+	} {
+		if c.got != c.want {
+			t.Errorf("%v %v: %v %v, want %v", tbl, want.timestamp.Format("15:04:05"), c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestDispatchMinuteAndFiveMinute(t *testing.T) {
+	start := time.Date(2018, 1, 3, 0, 0, 30, 0, time.UTC)
+	at := func(m, s int) time.Time { return time.Date(2018, 1, 3, 0, m, s, 0, time.UTC) }
+	p := startPipeline(start)
+	p.feed(at(5, 0), func(ts int64) float64 {
+		switch ts {
+		case at(1, 30).Unix():
+			return 0.5
+		case at(3, 20).Unix():
+			return 99
+		}
+		return float64(ts-start.Unix()) / 10
+	})
+
+	// 边界时刻的数据按自己的 timestamp 计入新的周期, 5:00 的数据所在的周期还没有关闭
+	mins := p.wait(t, coinmarketcapmin, 5)
+	for i, want := range []wantCandle{
+		{at(0, 40), 1, 2, 1, 2},
+		{at(1, 0), 3, 8, 0.5, 8},
+		{at(2, 0), 9, 14, 9, 14},
+		{at(3, 0), 15, 20, 15, 99},
+		{at(4, 0), 21, 26, 21, 26},
+	} {
+		checkCandle(t, coinmarketcapmin, mins[i], want)
+	}
+
+	min5s := p.wait(t, coinmarketcap5min, 1)
+	checkCandle(t, coinmarketcap5min, min5s[0], wantCandle{at(0, 40), 1, 26, 0.5, 99})
+
+	// 更长的周期还没有到边界
+	for _, tbl := range []string{coinmarketcap10min, coinmarketcap15min, coinmarketcap30min, coinmarketcaphour, coinmarketcapday, coinmarketcapweek} {
+		p.wait(t, tbl, 0)
+	}
+}
+
+func TestDispatchWeekBoundary(t *testing.T) {
+	// 2018-01-07 是星期天
+	start := time.Date(2018, 1, 7, 23, 58, 30, 0, time.UTC)
+	end := time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC)
+	p := startPipeline(start)
+	p.feed(end, func(ts int64) float64 {
+		return float64(end.Unix()-ts)/10 + 1
+	})
+
+	mins := p.wait(t, coinmarketcapmin, 2)
+	checkCandle(t, coinmarketcapmin, mins[0], wantCandle{start.Add(10 * time.Second), 9, 8, 8, 9})
+	checkCandle(t, coinmarketcapmin, mins[1], wantCandle{start.Add(30 * time.Second), 7, 2, 2, 7})
+
+	// 星期一 0 点所有周期同时结束, 0 点的数据属于下一周
+	want := wantCandle{start.Add(10 * time.Second), 9, 2, 2, 9}
+	for _, tbl := range []string{coinmarketcap5min, coinmarketcap10min, coinmarketcap15min, coinmarketcap30min, coinmarketcaphour, coinmarketcapday, coinmarketcapweek} {
+		got := p.wait(t, tbl, 1)
+		checkCandle(t, tbl, got[0], want)
+	}
+}
+
+func TestWeekBase(t *testing.T) {
+	monday := time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2018, 1, 7, 23, 59, 59, 0, time.UTC), monday},
+		{time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC), monday},
+		{time.Date(2018, 1, 6, 12, 0, 0, 0, time.UTC), monday},
+		{time.Date(2018, 1, 3, 12, 0, 0, 0, time.UTC), monday},
+		{time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), monday},
+		{monday, monday.AddDate(0, 0, 7)},
+	} {
+		if got := weekBase(c.now.Unix()); got != c.want.Unix() {
+			t.Errorf("weekBase(%v) = %v, want %v", c.now.Format(time.RFC3339), time.Unix(got, 0).UTC(), c.want)
+		}
+	}
+}
+
+func toFloat(f *big.Float) float64 {
+	v, _ := f.Float64()
+	return v
+}
+
+// candles 包直接读取流水线写入的进程内存储
+func TestCandlesClient(t *testing.T) {
+	start := time.Date(2018, 1, 3, 0, 0, 30, 0, time.UTC)
+	p := startPipeline(start)
+	p.feed(time.Date(2018, 1, 3, 0, 2, 0, 0, time.UTC), func(ts int64) float64 { return float64(ts-start.Unix()) / 10 })
+	p.wait(t, coinmarketcapmin, 2)
+
+	c := candles.New(p.store)
+	got, err := c.GetCandles(context.Background(), "bitcoin", candles.Minute, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Time != time.Date(2018, 1, 3, 0, 1, 0, 0, time.UTC) || got[1].USD != (candles.OHLC{Open: 3, High: 8, Low: 3, Close: 8}) {
+		t.Errorf("got %+v", got)
+	}
+	if got, _ := c.GetCandles(context.Background(), "ethereum", candles.Minute, time.Time{}, time.Time{}); len(got) != 0 {
+		t.Errorf("ethereum: %+v", got)
+	}
+}
+
+func TestDispatchLateTick(t *testing.T) {
+	start := time.Date(2018, 1, 3, 0, 0, 30, 0, time.UTC)
+	at := func(m, s int) time.Time { return time.Date(2018, 1, 3, 0, m, s, 0, time.UTC) }
+	for _, c := range []struct {
+		policy latePolicy
+		min    wantCandle
+		min5   wantCandle
+	}{
+		// 早于第一次抓取的更新开盘价, 修正后的行 timestamp 不变; 5 分钟周期还没关闭, 直接汇总
+		{lateAmend, wantCandle{at(0, 40), 0.5, 2, 0.5, 50}, wantCandle{at(0, 35), 0.5, 8, 0.5, 50}},
+		{lateDrop, wantCandle{at(0, 40), 1, 2, 1, 2}, wantCandle{at(0, 40), 1, 8, 1, 8}},
+	} {
+		p := startPipelineLate(start, lateConfig{grace: 3, policy: c.policy})
+		p.feed(at(1, 50), func(ts int64) float64 { return float64(ts-start.Unix()) / 10 })
+		p.wait(t, coinmarketcapmin, 1)
+		p.ch <- testTick(at(0, 45).Unix(), 50)
+		p.ch <- testTick(at(0, 35).Unix(), 0.5)
+		// 超过一个周期宽度的迟到数据总是丢弃
+		p.clk.Advance(time.Minute)
+		p.ch <- testTick(at(0, 55).Unix(), 1000)
+		p.clk.Advance(3 * time.Minute)
+
+		mins := p.wait(t, coinmarketcapmin, 2)
+		checkCandle(t, coinmarketcapmin, mins[0], c.min)
+		checkCandle(t, coinmarketcap5min, p.wait(t, coinmarketcap5min, 1)[0], c.min5)
+	}
+}
@@ -16,11 +16,13 @@ CREATE TABLE IF NOT EXISTS pricecoinmarketcap (
 	last_updated character varying(64) NOT NULL,
 	price_cny character varying(64) NOT NULL,
 	volume_cny_24h character varying(64) NOT NULL,
-	market_cap_cny character varying(64) NOT NULL 
+	market_cap_cny character varying(64) NOT NULL,
+	timestamp bigint NOT NULL DEFAULT 0
 );
 
 CREATE INDEX pricecoinmarketcap_last_updated_symbol_index ON pricecoinmarketcap USING btree(last_updated COLLATE "default" DESC NULLS FIRST, symbol COLLATE "default" ASC NULLS LAST);
 CREATE INDEX pricecoinmarketcap_symbol ON pricecoinmarketcap USING btree(symbol COLLATE "default" ASC NULLS LAST);
+CREATE INDEX index_timestamp_pricecoinmarketcap ON pricecoinmarketcap (timestamp);
 
 CREATE TABLE IF NOT EXISTS coinmarketcapmin (
 	id SERIAL PRIMARY KEY,
@@ -42,7 +44,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcapmin (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcapmin ON coinmarketcapmin (timestamp);
@@ -69,7 +76,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcap5min (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcap5min ON coinmarketcap5min (timestamp);
@@ -96,7 +108,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcap10min (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcap10min ON coinmarketcap10min (timestamp);
@@ -123,7 +140,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcap30min (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcap30min ON coinmarketcap30min (timestamp);
@@ -150,7 +172,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcap15min (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcap15min ON coinmarketcap15min (timestamp);
@@ -177,7 +204,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcapday (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcapday ON coinmarketcapday (timestamp);
@@ -204,7 +236,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcaphour (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcaphour ON coinmarketcaphour (timestamp);
@@ -231,7 +268,12 @@ CREATE TABLE IF NOT EXISTS coinmarketcapweek (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcapweek ON coinmarketcapweek (timestamp);
@@ -259,10 +301,102 @@ CREATE TABLE IF NOT EXISTS coinmarketcapcurrent (
 	price_cny_high real  NOT NULL, 
 	last_updated bigint NOT NULL,
 	timestamp bigint NOT NULL,
-       _group character varying(32) 
+       _group character varying(32),
+       stale boolean NOT NULL DEFAULT false,
+       volume_base double precision NOT NULL DEFAULT 0,
+       volume_quote double precision NOT NULL DEFAULT 0,
+       trades bigint NOT NULL DEFAULT 0,
+       vwap double precision NOT NULL DEFAULT 0
 ); 
 
 CREATE INDEX index_timestamp_coinmarketcapcurrent ON coinmarketcapcurrent (timestamp);
 CREATE INDEX index_last_updated_coinmarketcapcurrent ON coinmarketcapcurrent (last_updated);
 CREATE INDEX index_symbol_coinmarketcapcurrent ON coinmarketcapcurrent USING hash (symbol);
 CREATE INDEX index_group_coinmarketcapcurrent ON coinmarketcapcurrent USING hash (_group);
+
+-- --indicators 物化的指标, 多字段的指标每个字段一行
+CREATE TABLE IF NOT EXISTS indicators (
+	asset_id character varying(32) NOT NULL,
+	symbol character varying(32) NOT NULL,
+	timeframe character varying(8) NOT NULL,
+	indicator character varying(32) NOT NULL,
+	field character varying(16) NOT NULL,
+	timestamp bigint NOT NULL,
+	value double precision NOT NULL
+);
+
+CREATE INDEX index_asset_indicators ON indicators (asset_id, timeframe, indicator, timestamp);
+CREATE INDEX index_timestamp_indicators ON indicators (timestamp);
+
+-- --fx 读到的汇率, 1 USD 兑换的该货币数量
+CREATE TABLE IF NOT EXISTS fx_rates (
+	currency character varying(8) NOT NULL,
+	timestamp bigint NOT NULL,
+	rate double precision NOT NULL
+);
+
+CREATE INDEX index_currency_fx_rates ON fx_rates (currency, timestamp);
+
+-- --fxquotes, --crossquotes 换算成其他计价货币的周期数据
+CREATE TABLE IF NOT EXISTS converted_candles (
+	asset_id character varying(32) NOT NULL,
+	symbol character varying(32) NOT NULL,
+	timeframe character varying(8) NOT NULL,
+	quote character varying(32) NOT NULL,
+	timestamp bigint NOT NULL,
+	open double precision NOT NULL,
+	high double precision NOT NULL,
+	low double precision NOT NULL,
+	close double precision NOT NULL
+);
+
+CREATE INDEX index_asset_converted_candles ON converted_candles (asset_id, timeframe, quote, timestamp);
+CREATE INDEX index_timestamp_converted_candles ON converted_candles (timestamp);
+
+-- alerts 命令管理的告警规则, --alerts 触发的告警
+CREATE TABLE IF NOT EXISTS alert_rules (
+	id SERIAL PRIMARY KEY,
+	name character varying(64) NOT NULL DEFAULT '',
+	asset character varying(32) NOT NULL,
+	kind character varying(16) NOT NULL,
+	threshold double precision NOT NULL,
+	window_seconds bigint NOT NULL DEFAULT 0,
+	cooldown_seconds bigint NOT NULL DEFAULT 0,
+	currency character varying(8) NOT NULL DEFAULT 'usd',
+	webhook text NOT NULL DEFAULT '',
+	enabled boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS alert_history (
+	id SERIAL PRIMARY KEY,
+	rule_id integer NOT NULL,
+	rule character varying(64) NOT NULL,
+	kind character varying(16) NOT NULL,
+	asset_id character varying(32) NOT NULL,
+	symbol character varying(32) NOT NULL,
+	currency character varying(8) NOT NULL,
+	value double precision NOT NULL,
+	threshold double precision NOT NULL,
+	timestamp bigint NOT NULL,
+	message text NOT NULL,
+	delivered boolean NOT NULL,
+	error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX index_timestamp_alert_history ON alert_history (timestamp);
+
+-- 被异常值过滤器拒绝或隔离的数据
+CREATE TABLE IF NOT EXISTS rejected_ticks (
+	id SERIAL PRIMARY KEY,
+	source character varying(32) NOT NULL,
+	asset_id character varying(32) NOT NULL,
+	symbol character varying(32) NOT NULL,
+	price_usd character varying(64) NOT NULL,
+	median double precision NOT NULL,
+	deviation double precision NOT NULL,
+	reason text NOT NULL,
+	status character varying(16) NOT NULL,
+	timestamp bigint NOT NULL
+);
+
+CREATE INDEX index_timestamp_rejected_ticks ON rejected_ticks (timestamp);
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 不引入 prometheus client, 按 text exposition format 直接输出
+
+var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+var metrics = new(registry)
+
+func (r *registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	cs := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+type metricDesc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *metricDesc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+}
+
+func (d *metricDesc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelEscaper exposition format 的标签值只转义反斜杠, 双引号和换行, 不能用 %q
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelPairs(names []string, key string, extra ...string) string {
+	var pairs []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, n := range names {
+			pairs = append(pairs, n+`="`+labelEscaper.Replace(values[i])+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+// valueVec counter 和 gauge 共用
+type valueVec struct {
+	metricDesc
+	mu   sync.Mutex
+	vals map[string]float64
+}
+
+func newValueVec(typ, name, help string, labels ...string) *valueVec {
+	v := &valueVec{
+		metricDesc: metricDesc{name: name, help: help, typ: typ, labels: labels},
+		vals:       make(map[string]float64),
+	}
+	metrics.register(v)
+	return v
+}
+
+func newCounterVec(name, help string, labels ...string) *valueVec {
+	return newValueVec("counter", name, help, labels...)
+}
+
+func newGaugeVec(name, help string, labels ...string) *valueVec {
+	return newValueVec("gauge", name, help, labels...)
+}
+
+func (v *valueVec) add(delta float64, labels ...string) {
+	k := v.key(labels)
+	v.mu.Lock()
+	v.vals[k] += delta
+	v.mu.Unlock()
+}
+
+func (v *valueVec) inc(labels ...string) {
+	v.add(1, labels...)
+}
+
+func (v *valueVec) set(val float64, labels ...string) {
+	k := v.key(labels)
+	v.mu.Lock()
+	v.vals[k] = val
+	v.mu.Unlock()
+}
+
+func (v *valueVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	for _, k := range sortedKeys(v.vals) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelPairs(v.labels, k), formatFloat(v.vals[k]))
+	}
+}
+
+// gaugeFuncVec 在抓取时才取值, 用于 channel 深度这类瞬时量
+type gaugeFuncVec struct {
+	metricDesc
+	mu  sync.Mutex
+	fns map[string]func() float64
+}
+
+func newGaugeFuncVec(name, help string, labels ...string) *gaugeFuncVec {
+	g := &gaugeFuncVec{
+		metricDesc: metricDesc{name: name, help: help, typ: "gauge", labels: labels},
+		fns:        make(map[string]func() float64),
+	}
+	metrics.register(g)
+	return g
+}
+
+func (g *gaugeFuncVec) setFunc(fn func() float64, labels ...string) {
+	k := g.key(labels)
+	g.mu.Lock()
+	g.fns[k] = fn
+	g.mu.Unlock()
+}
+
+func (g *gaugeFuncVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	vals := make(map[string]float64, len(g.fns))
+	for k, fn := range g.fns {
+		vals[k] = fn()
+	}
+	g.header(w)
+	for _, k := range sortedKeys(vals) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelPairs(g.labels, k), formatFloat(vals[k]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	metricDesc
+	buckets []float64
+	mu      sync.Mutex
+	vals    map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		metricDesc: metricDesc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets:    buckets,
+		vals:       make(map[string]*histogram),
+	}
+	metrics.register(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, labels ...string) {
+	k := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	x, ok := h.vals[k]
+	if !ok {
+		x = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.vals[k] = x
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			x.counts[i]++
+		}
+	}
+	x.count++
+	x.sum += v
+}
+
+func (h *histogramVec) since(start time.Time, labels ...string) {
+	h.observe(time.Since(start).Seconds(), labels...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.vals))
+	for k := range h.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		x := h.vals[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, k, "le", formatFloat(b)), x.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, k, "le", "+Inf"), x.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, k), formatFloat(x.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, k), x.count)
+	}
+}
+
+var (
+	fetchDuration       = newHistogramVec("market_fetch_duration_seconds", "Upstream request latency.", defBuckets, "source")
+	fetchErrors         = newCounterVec("market_fetch_errors_total", "Failed upstream requests.", "source")
+	breakerTransitions  = newCounterVec("market_fetch_breaker_transitions_total", "Circuit breaker state transitions by target state.", "source", "state")
+	assetsPerTick       = newGaugeVec("market_assets_per_tick", "Assets returned by the last successful fetch.", "source")
+	channelDepth        = newGaugeFuncVec("market_channel_depth", "Buffered items waiting in pipeline channels.", "channel")
+	candleWrite         = newHistogramVec("market_candle_write_duration_seconds", "Candle write latency.", defBuckets, "table")
+	dbErrors            = newCounterVec("market_db_errors_total", "Database errors by operation.", "op")
+	lastBucket          = newGaugeVec("market_last_bucket_timestamp_seconds", "Timestamp of the last candle bucket written successfully.", "table")
+	queueDepth          = newGaugeFuncVec("market_write_queue_depth", "Items waiting in write queues, including spilled ones.", "queue")
+	queueDropped        = newCounterVec("market_write_queue_dropped_total", "Items dropped by write queues.", "queue")
+	queueSpilled        = newCounterVec("market_write_queue_spilled_total", "Items spilled to disk by write queues.", "queue")
+	retentionDeleted    = newCounterVec("market_retention_deleted_rows_total", "Rows deleted by the retention job.", "table")
+	archiveErrors       = newCounterVec("market_archive_errors_total", "Failed archive runs; rows are kept until archived.")
+	graphqlLoads        = newCounterVec("market_graphql_loads_total", "Table reads made by /graphql requests.", "table")
+	grpcRequests        = newCounterVec("market_grpc_requests_total", "gRPC calls by method and status code.", "method", "code")
+	grpcStreamDropped   = newCounterVec("market_grpc_stream_dropped_total", "Candle updates dropped for slow SubscribeCandles clients.")
+	retentionPartitions = newCounterVec("market_retention_dropped_partitions_total", "Partitions dropped by the retention job.", "table")
+	alertsFired         = newCounterVec("market_alerts_fired_total", "Alerts fired by rule kind.", "kind")
+	alertDeliveries     = newCounterVec("market_alert_deliveries_total", "Alert webhook deliveries by result: ok, failed or dropped.", "result")
+	tickFiltered        = newCounterVec("market_tick_filter_total", "Ticks rejected, quarantined or released by the outlier filter.", "source", "status")
+	staleTicks          = newCounterVec("market_stale_ticks_total", "Ticks whose upstream last_updated did not advance.", "source")
+	excludedAssets      = newGaugeVec("market_stale_excluded_assets", "Assets left out of aggregation because last_updated stopped advancing.", "source")
+	consensusExcluded   = newCounterVec("market_consensus_excluded_total", "Source prices left out of the composite as outliers.", "source")
+	lateTicks           = newCounterVec("market_late_ticks_total", "Ticks that arrived after their bucket closed, by action: amended or dropped.", "table", "action")
+	fxMissing           = newCounterVec("market_fx_missing_total", "Candle batches not converted because no recent FX rate was available.", "currency")
+	consensusNoQuorum   = newCounterVec("market_consensus_no_quorum_total", "Assets skipped because fewer sources than the quorum agreed on a price.")
+)
+
+func watchChannel(name string, ch chan *kPriceCoinMarketCapList) {
+	channelDepth.setFunc(func() float64 { return float64(len(ch)) }, name)
+}
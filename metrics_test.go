@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsExposition(t *testing.T) {
+	c := newCounterVec("test_requests_total", "Requests by path.", "path")
+	c.inc(`/a"b\c` + "\n" + "ä")
+	c.add(2, "/")
+	h := newHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		h.observe(v, "get")
+	}
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# HELP test_requests_total Requests by path.\n# TYPE test_requests_total counter\n",
+		"test_requests_total{path=\"/\"} 2\n",
+		// 只转义反斜杠, 双引号和换行, 其他字符原样输出
+		`test_requests_total{path="/a\"b\\c\nä"} 1` + "\n",
+		"# HELP test_latency_seconds Latency.\n# TYPE test_latency_seconds histogram\n",
+		// bucket 是累计的, +Inf 等于 _count
+		`test_latency_seconds_bucket{op="get",le="0.1"} 1` + "\n" +
+			`test_latency_seconds_bucket{op="get",le="1"} 3` + "\n" +
+			`test_latency_seconds_bucket{op="get",le="+Inf"} 4` + "\n" +
+			`test_latency_seconds_sum{op="get"} 4.05` + "\n" +
+			`test_latency_seconds_count{op="get"} 4` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
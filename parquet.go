@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 最小的 parquet 写入实现: 扁平 schema, 全部 REQUIRED 列,
+// PLAIN 编码, 不压缩, 每个 row group 每列一个 data page.
+
+const parquetMagic = "PAR1"
+
+// parquet 物理类型
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// parquet converted type
+const (
+	parquetNone            = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// parquetWriter 按行写入, 每 groupSize 行落一个 row group, 内存占用不随总行数增长
+type parquetWriter struct {
+	w         io.Writer
+	off       int64
+	cols      []parquetColumn
+	bufs      []bytes.Buffer
+	groupSize int
+	rows      int
+	total     int64
+	groups    [][]parquetChunk
+	groupRows []int
+}
+
+func newParquetWriter(w io.Writer, cols []parquetColumn, groupSize int) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, cols: cols, bufs: make([]bytes.Buffer, len(cols)), groupSize: groupSize}
+	return p, p.write([]byte(parquetMagic))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.off += int64(n)
+	return err
+}
+
+// writeRow 值的顺序和类型与 cols 一致: int64, float64 或 string
+func (p *parquetWriter) writeRow(vals ...interface{}) error {
+	if len(vals) != len(p.cols) {
+		return fmt.Errorf("parquet: %d values for %d columns", len(vals), len(p.cols))
+	}
+	var tmp [8]byte
+	for i, v := range vals {
+		buf := &p.bufs[i]
+		switch p.cols[i].typ {
+		case parquetBoolean:
+			x, ok := v.(bool)
+			if !ok {
+				return fmt.Errorf("parquet: column %v wants bool, got %T", p.cols[i].name, v)
+			}
+			// PLAIN 编码的布尔值按位打包, 低位在前
+			if p.rows%8 == 0 {
+				buf.WriteByte(0)
+			}
+			if x {
+				b := buf.Bytes()
+				b[len(b)-1] |= 1 << (p.rows % 8)
+			}
+		case parquetInt64:
+			x, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("parquet: column %v wants int64, got %T", p.cols[i].name, v)
+			}
+			binary.LittleEndian.PutUint64(tmp[:], uint64(x))
+			buf.Write(tmp[:])
+		case parquetDouble:
+			x, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("parquet: column %v wants float64, got %T", p.cols[i].name, v)
+			}
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(x))
+			buf.Write(tmp[:])
+		case parquetByteArray:
+			x, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("parquet: column %v wants string, got %T", p.cols[i].name, v)
+			}
+			binary.LittleEndian.PutUint32(tmp[:4], uint32(len(x)))
+			buf.Write(tmp[:4])
+			buf.WriteString(x)
+		}
+	}
+	p.rows++
+	if p.rows >= p.groupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	chunks := make([]parquetChunk, len(p.cols))
+	for i := range p.cols {
+		data := p.bufs[i].Bytes()
+		var h thriftWriter
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(p.rows))
+		h.i32(2, 0) // PLAIN
+		h.i32(3, 3) // RLE
+		h.i32(4, 3)
+		h.endStruct()
+		h.stop()
+		chunks[i] = parquetChunk{offset: p.off, size: int64(h.buf.Len() + len(data))}
+		if err := p.write(h.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		p.bufs[i].Reset()
+	}
+	p.groups = append(p.groups, chunks)
+	p.groupRows = append(p.groupRows, p.rows)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// Close 写入剩余数据和 footer, 不关闭底层 writer
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	var m thriftWriter
+	m.i32(1, 1)
+	m.listBegin(2, thriftStruct, len(p.cols)+1)
+	m.elemBegin()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(p.cols)))
+	m.elemEnd()
+	for _, c := range p.cols {
+		m.elemBegin()
+		m.i32(1, c.typ)
+		m.i32(3, 0) // REQUIRED
+		m.binary(4, c.name)
+		if c.converted != parquetNone {
+			m.i32(6, c.converted)
+		}
+		m.elemEnd()
+	}
+	m.i64(3, p.total)
+	m.listBegin(4, thriftStruct, len(p.groups))
+	for g, chunks := range p.groups {
+		m.elemBegin()
+		m.listBegin(1, thriftStruct, len(chunks))
+		var size int64
+		for i, ch := range chunks {
+			size += ch.size
+			m.elemBegin()
+			m.i64(2, ch.offset)
+			m.beginStruct(3)
+			m.i32(1, p.cols[i].typ)
+			m.listBegin(2, thriftI32, 1)
+			m.elemI32(0)
+			m.listBegin(3, thriftBinary, 1)
+			m.elemBinary(p.cols[i].name)
+			m.i32(4, 0) // UNCOMPRESSED
+			m.i64(5, int64(p.groupRows[g]))
+			m.i64(6, ch.size)
+			m.i64(7, ch.size)
+			m.i64(9, ch.offset)
+			m.endStruct()
+			m.elemEnd()
+		}
+		m.i64(2, size)
+		m.i64(3, int64(p.groupRows[g]))
+		m.elemEnd()
+	}
+	m.binary(6, "market")
+	m.stop()
+	if err := p.write(m.buf.Bytes()); err != nil {
+		return err
+	}
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(m.buf.Len()))
+	if err := p.write(n[:]); err != nil {
+		return err
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+// thrift compact protocol 类型
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter 只实现 parquet 元数据用到的部分 compact protocol
+type thriftWriter struct {
+	buf   bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.elemEnd()
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.uvarint(uint64(n))
+	}
+}
+
+// elemBegin 开始一个嵌套 struct, 字段编号重新计数
+func (t *thriftWriter) elemBegin() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
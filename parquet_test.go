@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 读回测试用的 parquet 解析, 和写入代码互相独立: thrift compact protocol 通用解码,
+// 按 parquet.thrift 的字段编号校验 footer, 再按 page header 读出每列的 PLAIN 数据.
+// 只支持写入端会产生的文件, 其他情况报错.
+
+type thriftReader struct {
+	b []byte
+	n int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.n >= len(r.b) {
+		return 0, fmt.Errorf("thrift: truncated at %d", r.n)
+	}
+	r.n++
+	return r.b[r.n-1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.n:])
+	if n <= 0 {
+		return 0, fmt.Errorf("thrift: bad varint at %d", r.n)
+	}
+	r.n += n
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case 1, 2:
+		return typ == 1, nil
+	case 3:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case 4, 5, 6:
+		return r.varint()
+	case 7:
+		if r.n+8 > len(r.b) {
+			return nil, fmt.Errorf("thrift: truncated double")
+		}
+		r.n += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.n-8:])), nil
+	case 8:
+		n, err := r.uvarint()
+		if err != nil || uint64(len(r.b)-r.n) < n {
+			return nil, fmt.Errorf("thrift: bad binary at %d", r.n)
+		}
+		r.n += int(n)
+		return string(r.b[r.n-int(n) : r.n]), nil
+	case 9, 10:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, elem := uint64(h>>4), h&0x0f
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if elem == 1 || elem == 2 {
+				b, err := r.byte()
+				list[i] = b == 1
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if list[i], err = r.value(elem); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case 12:
+		return r.structure()
+	}
+	return nil, fmt.Errorf("thrift: unsupported type %d at %d", typ, r.n)
+}
+
+// structure 按字段编号返回一个 struct
+func (r *thriftReader) structure() (map[int16]interface{}, error) {
+	s := make(map[int16]interface{})
+	var last int16
+	for {
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h == 0 {
+			return s, nil
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		if s[id], err = r.value(h & 0x0f); err != nil {
+			return nil, err
+		}
+		last = id
+	}
+}
+
+// readParquet 返回列名和全部行, 值的类型和 candleRow.Values 一致
+func readParquet(data []byte) ([]string, [][]interface{}, error) {
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return nil, nil, fmt.Errorf("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if n > len(data)-12 {
+		return nil, nil, fmt.Errorf("footer length %d", n)
+	}
+	r := &thriftReader{b: data[len(data)-8-n : len(data)-8]}
+	meta, err := r.structure()
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.n != n {
+		return nil, nil, fmt.Errorf("footer: %d trailing bytes", n-r.n)
+	}
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if root[5] != int64(len(schema)-1) {
+		return nil, nil, fmt.Errorf("schema: %v children, %d columns", root[5], len(schema)-1)
+	}
+	var names []string
+	var types []int64
+	for _, e := range schema[1:] {
+		col := e.(map[int16]interface{})
+		if col[3] != int64(0) {
+			return nil, nil, fmt.Errorf("column %v is not REQUIRED", col[4])
+		}
+		names = append(names, col[4].(string))
+		types = append(types, col[1].(int64))
+	}
+	var rows [][]interface{}
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(map[int16]interface{})
+		nrows := group[3].(int64)
+		chunks := group[1].([]interface{})
+		if len(chunks) != len(names) {
+			return nil, nil, fmt.Errorf("row group has %d chunks", len(chunks))
+		}
+		vals := make([][]interface{}, nrows)
+		for i := range vals {
+			vals[i] = make([]interface{}, len(names))
+		}
+		var total int64
+		for c, ch := range chunks {
+			md := ch.(map[int16]interface{})[3].(map[int16]interface{})
+			if md[1] != types[c] || md[4] != int64(0) || md[5] != nrows || !reflect.DeepEqual(md[3], []interface{}{names[c]}) {
+				return nil, nil, fmt.Errorf("column %v: chunk metadata %v", names[c], md)
+			}
+			off := md[9].(int64)
+			r := &thriftReader{b: data[off:]}
+			page, err := r.structure()
+			if err != nil {
+				return nil, nil, err
+			}
+			dp, _ := page[5].(map[int16]interface{})
+			if page[1] != int64(0) || page[2] != page[3] || dp[1] != nrows || dp[2] != int64(0) {
+				return nil, nil, fmt.Errorf("column %v: page header %v", names[c], page)
+			}
+			size := page[3].(int64)
+			if int64(r.n)+size != md[6] {
+				return nil, nil, fmt.Errorf("column %v: chunk size %v, page %d+%d", names[c], md[6], r.n, size)
+			}
+			total += md[6].(int64)
+			b := bytes.NewReader(data[off+int64(r.n) : off+int64(r.n)+size])
+			for i := int64(0); i < nrows; i++ {
+				vals[i][c], err = plainValue(b, types[c], i)
+				if err != nil {
+					return nil, nil, fmt.Errorf("column %v row %d: %v", names[c], i, err)
+				}
+			}
+			if types[c] == parquetBoolean {
+				b.Seek(int64((nrows+7)/8), 0)
+			}
+			if b.Len() != 0 {
+				return nil, nil, fmt.Errorf("column %v: %d bytes left in page", names[c], b.Len())
+			}
+		}
+		if group[2] != total {
+			return nil, nil, fmt.Errorf("row group size %v, chunks %d", group[2], total)
+		}
+		rows = append(rows, vals...)
+	}
+	if meta[3] != int64(len(rows)) {
+		return nil, nil, fmt.Errorf("num_rows %v, read %d", meta[3], len(rows))
+	}
+	return names, rows, nil
+}
+
+func plainValue(b *bytes.Reader, typ, i int64) (interface{}, error) {
+	var tmp [8]byte
+	switch typ {
+	case parquetBoolean:
+		if _, err := b.ReadAt(tmp[:1], i/8); err != nil {
+			return nil, err
+		}
+		return tmp[0]&(1<<(i%8)) != 0, nil
+	case parquetInt64, parquetDouble:
+		if _, err := b.Read(tmp[:]); err != nil {
+			return nil, err
+		}
+		v := binary.LittleEndian.Uint64(tmp[:])
+		if typ == parquetDouble {
+			return math.Float64frombits(v), nil
+		}
+		return int64(v), nil
+	case parquetByteArray:
+		if _, err := b.Read(tmp[:4]); err != nil {
+			return nil, err
+		}
+		s := make([]byte, binary.LittleEndian.Uint32(tmp[:4]))
+		if _, err := b.Read(s); err != nil && len(s) > 0 {
+			return nil, err
+		}
+		return string(s), nil
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	cols := []parquetColumn{
+		{name: "s", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "i", typ: parquetInt64, converted: parquetNone},
+		{name: "f", typ: parquetDouble, converted: parquetNone},
+		{name: "b", typ: parquetBoolean, converted: parquetNone},
+	}
+	var buf bytes.Buffer
+	w, err := newParquetWriter(&buf, cols, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 三个 row group, 布尔值跨字节, 最后一组不满
+	var want [][]interface{}
+	for i := int64(0); i < 20; i++ {
+		row := []interface{}{fmt.Sprint("row", i), i - 10, float64(i) / 4, i%3 == 0}
+		if i == 5 {
+			row[0] = ""
+		}
+		if err := w.writeRow(row...); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, row)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, rows, err := readParquet(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"s", "i", "f", "b"}) || !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v\n%v\nwant %v", names, rows, want)
+	}
+}
+
+var updateFixtures = flag.Bool("update", false, "rewrite testdata/candles*.parquet and candles.json")
+
+// TestParquetFixture 导出结果必须和 testdata 中的文件逐字节一致. 这些文件要用
+// testdata/check_parquet.py 以 pyarrow 读回, 和 candles.json 核对, 写入代码改动后用 -update 重新生成再核对
+func TestParquetFixture(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	eth := testTick(1514937660, 2)
+	eth.list[0].Id, eth.list[0].Name, eth.list[0].Symbol = "ethereum", "Ethereum", "ETH"
+	eth.list[0].Stale = true
+	store.SaveCandles(ctx, coinmarketcapmin, "g", testTick(1514937660, 1.5), eth, testTick(1514937600, 1))
+	q := candleQuery{Table: coinmarketcapmin}
+	if *updateFixtures {
+		var rows [][]interface{}
+		store.ScanCandles(ctx, q, func(r *candleRow) error {
+			rows = append(rows, r.Values())
+			return nil
+		})
+		b, _ := json.MarshalIndent(map[string]interface{}{"columns": candleColumns, "rows": rows}, "", "  ")
+		if err := os.WriteFile(filepath.Join("testdata", "candles.json"), append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, iso := range []bool{false, true} {
+		name := "candles.parquet"
+		if iso {
+			name = "candles_iso.parquet"
+		}
+		got := []byte(exportString(t, store, q, "parquet", iso))
+		path := filepath.Join("testdata", name)
+		if *updateFixtures {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: export differs from the fixture, regenerate with -update and check it with testdata/check_parquet.py", name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"market/candles"
+	"market/proto/marketpb"
+)
+
+// candles 包的类型和 proto/marketpb 生成的消息之间的转换
+
+// pbTimeframes Timeframe 枚举值
+var pbTimeframes = map[marketpb.Timeframe]candles.Timeframe{
+	marketpb.Timeframe_TIMEFRAME_1M:  candles.Minute,
+	marketpb.Timeframe_TIMEFRAME_5M:  candles.FiveMinutes,
+	marketpb.Timeframe_TIMEFRAME_10M: candles.TenMinutes,
+	marketpb.Timeframe_TIMEFRAME_15M: candles.FifteenMinutes,
+	marketpb.Timeframe_TIMEFRAME_30M: candles.ThirtyMinutes,
+	marketpb.Timeframe_TIMEFRAME_1H:  candles.Hour,
+	marketpb.Timeframe_TIMEFRAME_1D:  candles.Day,
+	marketpb.Timeframe_TIMEFRAME_1W:  candles.Week,
+}
+
+func pbOHLC(v candles.OHLC) *marketpb.OHLC {
+	return &marketpb.OHLC{Open: v.Open, High: v.High, Low: v.Low, Close: v.Close}
+}
+
+func pbVolume(v candles.Volume) *marketpb.Volume {
+	return &marketpb.Volume{Base: v.Base, Quote: v.Quote, Trades: v.Trades, Vwap: v.VWAP}
+}
+
+func pbCandle(c *candles.Candle) *marketpb.Candle {
+	return &marketpb.Candle{
+		AssetId:     c.AssetID,
+		Name:        c.Name,
+		Symbol:      c.Symbol,
+		Rank:        c.Rank,
+		Time:        c.Time.Unix(),
+		LastUpdated: c.LastUpdated.Unix(),
+		Usd:         pbOHLC(c.USD),
+		Btc:         pbOHLC(c.BTC),
+		Cny:         pbOHLC(c.CNY),
+		Group:       c.Group,
+		Stale:       c.Stale,
+		Volume:      pbVolume(c.Volume),
+	}
+}
+
+func pbQuote(q *candles.Quote) *marketpb.Quote {
+	return &marketpb.Quote{
+		AssetId:     q.AssetID,
+		Name:        q.Name,
+		Symbol:      q.Symbol,
+		Rank:        q.Rank,
+		Usd:         q.USD,
+		Btc:         q.BTC,
+		Cny:         q.CNY,
+		LastUpdated: q.LastUpdated.Unix(),
+		DayUsd:      pbOHLC(q.Day.USD),
+		DayBtc:      pbOHLC(q.Day.BTC),
+		DayCny:      pbOHLC(q.Day.CNY),
+		Group:       q.Group,
+		Stale:       q.Stale,
+	}
+}
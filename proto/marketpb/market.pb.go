@@ -0,0 +1,899 @@
+// 周期数据和实时价格的 gRPC 接口, 生成的代码在 proto/marketpb, 服务端实现见 grpc.go.
+// 时间均为 unix 秒.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: proto/market.proto
+
+package marketpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Timeframe int32
+
+const (
+	Timeframe_TIMEFRAME_UNSPECIFIED Timeframe = 0
+	Timeframe_TIMEFRAME_1M          Timeframe = 1
+	Timeframe_TIMEFRAME_5M          Timeframe = 2
+	Timeframe_TIMEFRAME_10M         Timeframe = 3
+	Timeframe_TIMEFRAME_15M         Timeframe = 4
+	Timeframe_TIMEFRAME_30M         Timeframe = 5
+	Timeframe_TIMEFRAME_1H          Timeframe = 6
+	Timeframe_TIMEFRAME_1D          Timeframe = 7
+	Timeframe_TIMEFRAME_1W          Timeframe = 8
+)
+
+// Enum value maps for Timeframe.
+var (
+	Timeframe_name = map[int32]string{
+		0: "TIMEFRAME_UNSPECIFIED",
+		1: "TIMEFRAME_1M",
+		2: "TIMEFRAME_5M",
+		3: "TIMEFRAME_10M",
+		4: "TIMEFRAME_15M",
+		5: "TIMEFRAME_30M",
+		6: "TIMEFRAME_1H",
+		7: "TIMEFRAME_1D",
+		8: "TIMEFRAME_1W",
+	}
+	Timeframe_value = map[string]int32{
+		"TIMEFRAME_UNSPECIFIED": 0,
+		"TIMEFRAME_1M":          1,
+		"TIMEFRAME_5M":          2,
+		"TIMEFRAME_10M":         3,
+		"TIMEFRAME_15M":         4,
+		"TIMEFRAME_30M":         5,
+		"TIMEFRAME_1H":          6,
+		"TIMEFRAME_1D":          7,
+		"TIMEFRAME_1W":          8,
+	}
+)
+
+func (x Timeframe) Enum() *Timeframe {
+	p := new(Timeframe)
+	*p = x
+	return p
+}
+
+func (x Timeframe) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Timeframe) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_market_proto_enumTypes[0].Descriptor()
+}
+
+func (Timeframe) Type() protoreflect.EnumType {
+	return &file_proto_market_proto_enumTypes[0]
+}
+
+func (x Timeframe) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Timeframe.Descriptor instead.
+func (Timeframe) EnumDescriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{0}
+}
+
+type OHLC struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Open          float64                `protobuf:"fixed64,1,opt,name=open,proto3" json:"open,omitempty"`
+	High          float64                `protobuf:"fixed64,2,opt,name=high,proto3" json:"high,omitempty"`
+	Low           float64                `protobuf:"fixed64,3,opt,name=low,proto3" json:"low,omitempty"`
+	Close         float64                `protobuf:"fixed64,4,opt,name=close,proto3" json:"close,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OHLC) Reset() {
+	*x = OHLC{}
+	mi := &file_proto_market_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OHLC) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OHLC) ProtoMessage() {}
+
+func (x *OHLC) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OHLC.ProtoReflect.Descriptor instead.
+func (*OHLC) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *OHLC) GetOpen() float64 {
+	if x != nil {
+		return x.Open
+	}
+	return 0
+}
+
+func (x *OHLC) GetHigh() float64 {
+	if x != nil {
+		return x.High
+	}
+	return 0
+}
+
+func (x *OHLC) GetLow() float64 {
+	if x != nil {
+		return x.Low
+	}
+	return 0
+}
+
+func (x *OHLC) GetClose() float64 {
+	if x != nil {
+		return x.Close
+	}
+	return 0
+}
+
+type Candle struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AssetId string                 `protobuf:"bytes,1,opt,name=asset_id,json=assetId,proto3" json:"asset_id,omitempty"`
+	Name    string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Symbol  string                 `protobuf:"bytes,3,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Rank    int64                  `protobuf:"varint,4,opt,name=rank,proto3" json:"rank,omitempty"`
+	// 周期内第一次抓取的时间
+	Time        int64 `protobuf:"varint,5,opt,name=time,proto3" json:"time,omitempty"`
+	LastUpdated int64 `protobuf:"varint,6,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
+	Usd         *OHLC `protobuf:"bytes,7,opt,name=usd,proto3" json:"usd,omitempty"`
+	Btc         *OHLC `protobuf:"bytes,8,opt,name=btc,proto3" json:"btc,omitempty"`
+	Cny         *OHLC `protobuf:"bytes,9,opt,name=cny,proto3" json:"cny,omitempty"`
+	// 数据来源, 采集程序写入的为空
+	Group string `protobuf:"bytes,10,opt,name=group,proto3" json:"group,omitempty"`
+	// 周期内上游的 last_updated 一直没有前进
+	Stale         bool    `protobuf:"varint,11,opt,name=stale,proto3" json:"stale,omitempty"`
+	Volume        *Volume `protobuf:"bytes,12,opt,name=volume,proto3" json:"volume,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Candle) Reset() {
+	*x = Candle{}
+	mi := &file_proto_market_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Candle) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Candle) ProtoMessage() {}
+
+func (x *Candle) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Candle.ProtoReflect.Descriptor instead.
+func (*Candle) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Candle) GetAssetId() string {
+	if x != nil {
+		return x.AssetId
+	}
+	return ""
+}
+
+func (x *Candle) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Candle) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Candle) GetRank() int64 {
+	if x != nil {
+		return x.Rank
+	}
+	return 0
+}
+
+func (x *Candle) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Candle) GetLastUpdated() int64 {
+	if x != nil {
+		return x.LastUpdated
+	}
+	return 0
+}
+
+func (x *Candle) GetUsd() *OHLC {
+	if x != nil {
+		return x.Usd
+	}
+	return nil
+}
+
+func (x *Candle) GetBtc() *OHLC {
+	if x != nil {
+		return x.Btc
+	}
+	return nil
+}
+
+func (x *Candle) GetCny() *OHLC {
+	if x != nil {
+		return x.Cny
+	}
+	return nil
+}
+
+func (x *Candle) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Candle) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+func (x *Candle) GetVolume() *Volume {
+	if x != nil {
+		return x.Volume
+	}
+	return nil
+}
+
+// 由成交数据汇总, quote 为 USD 金额, 只抓取价格的来源为 0
+type Volume struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Base          float64                `protobuf:"fixed64,1,opt,name=base,proto3" json:"base,omitempty"`
+	Quote         float64                `protobuf:"fixed64,2,opt,name=quote,proto3" json:"quote,omitempty"`
+	Trades        int64                  `protobuf:"varint,3,opt,name=trades,proto3" json:"trades,omitempty"`
+	Vwap          float64                `protobuf:"fixed64,4,opt,name=vwap,proto3" json:"vwap,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Volume) Reset() {
+	*x = Volume{}
+	mi := &file_proto_market_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Volume) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Volume) ProtoMessage() {}
+
+func (x *Volume) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Volume.ProtoReflect.Descriptor instead.
+func (*Volume) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Volume) GetBase() float64 {
+	if x != nil {
+		return x.Base
+	}
+	return 0
+}
+
+func (x *Volume) GetQuote() float64 {
+	if x != nil {
+		return x.Quote
+	}
+	return 0
+}
+
+func (x *Volume) GetTrades() int64 {
+	if x != nil {
+		return x.Trades
+	}
+	return 0
+}
+
+func (x *Volume) GetVwap() float64 {
+	if x != nil {
+		return x.Vwap
+	}
+	return 0
+}
+
+type Quote struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	AssetId     string                 `protobuf:"bytes,1,opt,name=asset_id,json=assetId,proto3" json:"asset_id,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Symbol      string                 `protobuf:"bytes,3,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Rank        int64                  `protobuf:"varint,4,opt,name=rank,proto3" json:"rank,omitempty"`
+	Usd         float64                `protobuf:"fixed64,5,opt,name=usd,proto3" json:"usd,omitempty"`
+	Btc         float64                `protobuf:"fixed64,6,opt,name=btc,proto3" json:"btc,omitempty"`
+	Cny         float64                `protobuf:"fixed64,7,opt,name=cny,proto3" json:"cny,omitempty"`
+	LastUpdated int64                  `protobuf:"varint,8,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
+	// 当天 (UTC) 到目前为止的开高低收
+	DayUsd        *OHLC  `protobuf:"bytes,9,opt,name=day_usd,json=dayUsd,proto3" json:"day_usd,omitempty"`
+	DayBtc        *OHLC  `protobuf:"bytes,10,opt,name=day_btc,json=dayBtc,proto3" json:"day_btc,omitempty"`
+	DayCny        *OHLC  `protobuf:"bytes,11,opt,name=day_cny,json=dayCny,proto3" json:"day_cny,omitempty"`
+	Group         string `protobuf:"bytes,12,opt,name=group,proto3" json:"group,omitempty"`
+	Stale         bool   `protobuf:"varint,13,opt,name=stale,proto3" json:"stale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Quote) Reset() {
+	*x = Quote{}
+	mi := &file_proto_market_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Quote) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quote) ProtoMessage() {}
+
+func (x *Quote) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quote.ProtoReflect.Descriptor instead.
+func (*Quote) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Quote) GetAssetId() string {
+	if x != nil {
+		return x.AssetId
+	}
+	return ""
+}
+
+func (x *Quote) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Quote) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Quote) GetRank() int64 {
+	if x != nil {
+		return x.Rank
+	}
+	return 0
+}
+
+func (x *Quote) GetUsd() float64 {
+	if x != nil {
+		return x.Usd
+	}
+	return 0
+}
+
+func (x *Quote) GetBtc() float64 {
+	if x != nil {
+		return x.Btc
+	}
+	return 0
+}
+
+func (x *Quote) GetCny() float64 {
+	if x != nil {
+		return x.Cny
+	}
+	return 0
+}
+
+func (x *Quote) GetLastUpdated() int64 {
+	if x != nil {
+		return x.LastUpdated
+	}
+	return 0
+}
+
+func (x *Quote) GetDayUsd() *OHLC {
+	if x != nil {
+		return x.DayUsd
+	}
+	return nil
+}
+
+func (x *Quote) GetDayBtc() *OHLC {
+	if x != nil {
+		return x.DayBtc
+	}
+	return nil
+}
+
+func (x *Quote) GetDayCny() *OHLC {
+	if x != nil {
+		return x.DayCny
+	}
+	return nil
+}
+
+func (x *Quote) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Quote) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+type GetCandlesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AssetId       string                 `protobuf:"bytes,1,opt,name=asset_id,json=assetId,proto3" json:"asset_id,omitempty"`
+	Timeframe     Timeframe              `protobuf:"varint,2,opt,name=timeframe,proto3,enum=market.v1.Timeframe" json:"timeframe,omitempty"`
+	From          int64                  `protobuf:"varint,3,opt,name=from,proto3" json:"from,omitempty"`
+	To            int64                  `protobuf:"varint,4,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCandlesRequest) Reset() {
+	*x = GetCandlesRequest{}
+	mi := &file_proto_market_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCandlesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCandlesRequest) ProtoMessage() {}
+
+func (x *GetCandlesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCandlesRequest.ProtoReflect.Descriptor instead.
+func (*GetCandlesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetCandlesRequest) GetAssetId() string {
+	if x != nil {
+		return x.AssetId
+	}
+	return ""
+}
+
+func (x *GetCandlesRequest) GetTimeframe() Timeframe {
+	if x != nil {
+		return x.Timeframe
+	}
+	return Timeframe_TIMEFRAME_UNSPECIFIED
+}
+
+func (x *GetCandlesRequest) GetFrom() int64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *GetCandlesRequest) GetTo() int64 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+type GetCandlesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Candles       []*Candle              `protobuf:"bytes,1,rep,name=candles,proto3" json:"candles,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCandlesResponse) Reset() {
+	*x = GetCandlesResponse{}
+	mi := &file_proto_market_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCandlesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCandlesResponse) ProtoMessage() {}
+
+func (x *GetCandlesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCandlesResponse.ProtoReflect.Descriptor instead.
+func (*GetCandlesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetCandlesResponse) GetCandles() []*Candle {
+	if x != nil {
+		return x.Candles
+	}
+	return nil
+}
+
+type GetCurrentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AssetIds      []string               `protobuf:"bytes,1,rep,name=asset_ids,json=assetIds,proto3" json:"asset_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCurrentRequest) Reset() {
+	*x = GetCurrentRequest{}
+	mi := &file_proto_market_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCurrentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCurrentRequest) ProtoMessage() {}
+
+func (x *GetCurrentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCurrentRequest.ProtoReflect.Descriptor instead.
+func (*GetCurrentRequest) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetCurrentRequest) GetAssetIds() []string {
+	if x != nil {
+		return x.AssetIds
+	}
+	return nil
+}
+
+type GetCurrentResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Quotes        []*Quote               `protobuf:"bytes,1,rep,name=quotes,proto3" json:"quotes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCurrentResponse) Reset() {
+	*x = GetCurrentResponse{}
+	mi := &file_proto_market_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCurrentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCurrentResponse) ProtoMessage() {}
+
+func (x *GetCurrentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCurrentResponse.ProtoReflect.Descriptor instead.
+func (*GetCurrentResponse) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetCurrentResponse) GetQuotes() []*Quote {
+	if x != nil {
+		return x.Quotes
+	}
+	return nil
+}
+
+type SubscribeCandlesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// 为空时推送全部资产
+	AssetIds      []string `protobuf:"bytes,1,rep,name=asset_ids,json=assetIds,proto3" json:"asset_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeCandlesRequest) Reset() {
+	*x = SubscribeCandlesRequest{}
+	mi := &file_proto_market_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeCandlesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeCandlesRequest) ProtoMessage() {}
+
+func (x *SubscribeCandlesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_market_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeCandlesRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeCandlesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_market_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SubscribeCandlesRequest) GetAssetIds() []string {
+	if x != nil {
+		return x.AssetIds
+	}
+	return nil
+}
+
+var File_proto_market_proto protoreflect.FileDescriptor
+
+const file_proto_market_proto_rawDesc = "" +
+	"\n" +
+	"\x12proto/market.proto\x12\tmarket.v1\"V\n" +
+	"\x04OHLC\x12\x12\n" +
+	"\x04open\x18\x01 \x01(\x01R\x04open\x12\x12\n" +
+	"\x04high\x18\x02 \x01(\x01R\x04high\x12\x10\n" +
+	"\x03low\x18\x03 \x01(\x01R\x03low\x12\x14\n" +
+	"\x05close\x18\x04 \x01(\x01R\x05close\"\xda\x02\n" +
+	"\x06Candle\x12\x19\n" +
+	"\basset_id\x18\x01 \x01(\tR\aassetId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06symbol\x18\x03 \x01(\tR\x06symbol\x12\x12\n" +
+	"\x04rank\x18\x04 \x01(\x03R\x04rank\x12\x12\n" +
+	"\x04time\x18\x05 \x01(\x03R\x04time\x12!\n" +
+	"\flast_updated\x18\x06 \x01(\x03R\vlastUpdated\x12!\n" +
+	"\x03usd\x18\a \x01(\v2\x0f.market.v1.OHLCR\x03usd\x12!\n" +
+	"\x03btc\x18\b \x01(\v2\x0f.market.v1.OHLCR\x03btc\x12!\n" +
+	"\x03cny\x18\t \x01(\v2\x0f.market.v1.OHLCR\x03cny\x12\x14\n" +
+	"\x05group\x18\n" +
+	" \x01(\tR\x05group\x12\x14\n" +
+	"\x05stale\x18\v \x01(\bR\x05stale\x12)\n" +
+	"\x06volume\x18\f \x01(\v2\x11.market.v1.VolumeR\x06volume\"^\n" +
+	"\x06Volume\x12\x12\n" +
+	"\x04base\x18\x01 \x01(\x01R\x04base\x12\x14\n" +
+	"\x05quote\x18\x02 \x01(\x01R\x05quote\x12\x16\n" +
+	"\x06trades\x18\x03 \x01(\x03R\x06trades\x12\x12\n" +
+	"\x04vwap\x18\x04 \x01(\x01R\x04vwap\"\xe5\x02\n" +
+	"\x05Quote\x12\x19\n" +
+	"\basset_id\x18\x01 \x01(\tR\aassetId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06symbol\x18\x03 \x01(\tR\x06symbol\x12\x12\n" +
+	"\x04rank\x18\x04 \x01(\x03R\x04rank\x12\x10\n" +
+	"\x03usd\x18\x05 \x01(\x01R\x03usd\x12\x10\n" +
+	"\x03btc\x18\x06 \x01(\x01R\x03btc\x12\x10\n" +
+	"\x03cny\x18\a \x01(\x01R\x03cny\x12!\n" +
+	"\flast_updated\x18\b \x01(\x03R\vlastUpdated\x12(\n" +
+	"\aday_usd\x18\t \x01(\v2\x0f.market.v1.OHLCR\x06dayUsd\x12(\n" +
+	"\aday_btc\x18\n" +
+	" \x01(\v2\x0f.market.v1.OHLCR\x06dayBtc\x12(\n" +
+	"\aday_cny\x18\v \x01(\v2\x0f.market.v1.OHLCR\x06dayCny\x12\x14\n" +
+	"\x05group\x18\f \x01(\tR\x05group\x12\x14\n" +
+	"\x05stale\x18\r \x01(\bR\x05stale\"\x86\x01\n" +
+	"\x11GetCandlesRequest\x12\x19\n" +
+	"\basset_id\x18\x01 \x01(\tR\aassetId\x122\n" +
+	"\ttimeframe\x18\x02 \x01(\x0e2\x14.market.v1.TimeframeR\ttimeframe\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\x03R\x04from\x12\x0e\n" +
+	"\x02to\x18\x04 \x01(\x03R\x02to\"A\n" +
+	"\x12GetCandlesResponse\x12+\n" +
+	"\acandles\x18\x01 \x03(\v2\x11.market.v1.CandleR\acandles\"0\n" +
+	"\x11GetCurrentRequest\x12\x1b\n" +
+	"\tasset_ids\x18\x01 \x03(\tR\bassetIds\">\n" +
+	"\x12GetCurrentResponse\x12(\n" +
+	"\x06quotes\x18\x01 \x03(\v2\x10.market.v1.QuoteR\x06quotes\"6\n" +
+	"\x17SubscribeCandlesRequest\x12\x1b\n" +
+	"\tasset_ids\x18\x01 \x03(\tR\bassetIds*\xb9\x01\n" +
+	"\tTimeframe\x12\x19\n" +
+	"\x15TIMEFRAME_UNSPECIFIED\x10\x00\x12\x10\n" +
+	"\fTIMEFRAME_1M\x10\x01\x12\x10\n" +
+	"\fTIMEFRAME_5M\x10\x02\x12\x11\n" +
+	"\rTIMEFRAME_10M\x10\x03\x12\x11\n" +
+	"\rTIMEFRAME_15M\x10\x04\x12\x11\n" +
+	"\rTIMEFRAME_30M\x10\x05\x12\x10\n" +
+	"\fTIMEFRAME_1H\x10\x06\x12\x10\n" +
+	"\fTIMEFRAME_1D\x10\a\x12\x10\n" +
+	"\fTIMEFRAME_1W\x10\b2\xf8\x01\n" +
+	"\rMarketService\x12K\n" +
+	"\n" +
+	"GetCandles\x12\x1c.market.v1.GetCandlesRequest\x1a\x1d.market.v1.GetCandlesResponse\"\x00\x12K\n" +
+	"\n" +
+	"GetCurrent\x12\x1c.market.v1.GetCurrentRequest\x1a\x1d.market.v1.GetCurrentResponse\"\x00\x12M\n" +
+	"\x10SubscribeCandles\x12\".market.v1.SubscribeCandlesRequest\x1a\x11.market.v1.Candle\"\x000\x01B\x17Z\x15market/proto/marketpbb\x06proto3"
+
+var (
+	file_proto_market_proto_rawDescOnce sync.Once
+	file_proto_market_proto_rawDescData []byte
+)
+
+func file_proto_market_proto_rawDescGZIP() []byte {
+	file_proto_market_proto_rawDescOnce.Do(func() {
+		file_proto_market_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_market_proto_rawDesc), len(file_proto_market_proto_rawDesc)))
+	})
+	return file_proto_market_proto_rawDescData
+}
+
+var file_proto_market_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_market_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_proto_market_proto_goTypes = []any{
+	(Timeframe)(0),                  // 0: market.v1.Timeframe
+	(*OHLC)(nil),                    // 1: market.v1.OHLC
+	(*Candle)(nil),                  // 2: market.v1.Candle
+	(*Volume)(nil),                  // 3: market.v1.Volume
+	(*Quote)(nil),                   // 4: market.v1.Quote
+	(*GetCandlesRequest)(nil),       // 5: market.v1.GetCandlesRequest
+	(*GetCandlesResponse)(nil),      // 6: market.v1.GetCandlesResponse
+	(*GetCurrentRequest)(nil),       // 7: market.v1.GetCurrentRequest
+	(*GetCurrentResponse)(nil),      // 8: market.v1.GetCurrentResponse
+	(*SubscribeCandlesRequest)(nil), // 9: market.v1.SubscribeCandlesRequest
+}
+var file_proto_market_proto_depIdxs = []int32{
+	1,  // 0: market.v1.Candle.usd:type_name -> market.v1.OHLC
+	1,  // 1: market.v1.Candle.btc:type_name -> market.v1.OHLC
+	1,  // 2: market.v1.Candle.cny:type_name -> market.v1.OHLC
+	3,  // 3: market.v1.Candle.volume:type_name -> market.v1.Volume
+	1,  // 4: market.v1.Quote.day_usd:type_name -> market.v1.OHLC
+	1,  // 5: market.v1.Quote.day_btc:type_name -> market.v1.OHLC
+	1,  // 6: market.v1.Quote.day_cny:type_name -> market.v1.OHLC
+	0,  // 7: market.v1.GetCandlesRequest.timeframe:type_name -> market.v1.Timeframe
+	2,  // 8: market.v1.GetCandlesResponse.candles:type_name -> market.v1.Candle
+	4,  // 9: market.v1.GetCurrentResponse.quotes:type_name -> market.v1.Quote
+	5,  // 10: market.v1.MarketService.GetCandles:input_type -> market.v1.GetCandlesRequest
+	7,  // 11: market.v1.MarketService.GetCurrent:input_type -> market.v1.GetCurrentRequest
+	9,  // 12: market.v1.MarketService.SubscribeCandles:input_type -> market.v1.SubscribeCandlesRequest
+	6,  // 13: market.v1.MarketService.GetCandles:output_type -> market.v1.GetCandlesResponse
+	8,  // 14: market.v1.MarketService.GetCurrent:output_type -> market.v1.GetCurrentResponse
+	2,  // 15: market.v1.MarketService.SubscribeCandles:output_type -> market.v1.Candle
+	13, // [13:16] is the sub-list for method output_type
+	10, // [10:13] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_proto_market_proto_init() }
+func file_proto_market_proto_init() {
+	if File_proto_market_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_market_proto_rawDesc), len(file_proto_market_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_market_proto_goTypes,
+		DependencyIndexes: file_proto_market_proto_depIdxs,
+		EnumInfos:         file_proto_market_proto_enumTypes,
+		MessageInfos:      file_proto_market_proto_msgTypes,
+	}.Build()
+	File_proto_market_proto = out.File
+	file_proto_market_proto_goTypes = nil
+	file_proto_market_proto_depIdxs = nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type queuePolicy int
+
+const (
+	// policyBlock 队列满时阻塞生产者
+	policyBlock queuePolicy = iota
+	// policyDropOldest 队列满时丢弃最早的一条
+	policyDropOldest
+	// policySpill 队列满时写入磁盘, 内存排空后再回放
+	policySpill
+)
+
+func parseQueuePolicy(s string) (queuePolicy, error) {
+	switch s {
+	case "block":
+		return policyBlock, nil
+	case "dropoldest":
+		return policyDropOldest, nil
+	case "spill":
+		return policySpill, nil
+	}
+	return 0, fmt.Errorf("unknown queue policy %q", s)
+}
+
+type queueConfig struct {
+	size     int
+	batch    int
+	policy   queuePolicy
+	spillDir string
+}
+
+// writeQueue 有界写队列, 每个表一个, 由唯一的 writer 批量落库
+type writeQueue[T any] struct {
+	name     string
+	cfg      queueConfig
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []T
+	spilled  int
+	flush    func([]T) error
+	// backoff, maxBackoff 写入失败后重试的等待时间
+	backoff    time.Duration
+	maxBackoff time.Duration
+	log        *slog.Logger
+}
+
+func newWriteQueue[T any](name string, cfg queueConfig, flush func([]T) error) *writeQueue[T] {
+	if cfg.size <= 0 {
+		cfg.size = 1
+	}
+	if cfg.batch <= 0 {
+		cfg.batch = 1
+	}
+	q := &writeQueue[T]{
+		name:       name,
+		cfg:        cfg,
+		flush:      flush,
+		backoff:    time.Second,
+		maxBackoff: 30 * time.Second,
+		log:        component("queue", "table", name),
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	if cfg.policy == policySpill {
+		// 上次退出时残留的数据
+		q.spilled = countLines(q.spillPath())
+	}
+	queueDepth.setFunc(func() float64 {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return float64(len(q.items) + q.spilled)
+	}, name)
+	return q
+}
+
+func (q *writeQueue[T]) spillPath() string {
+	return filepath.Join(q.cfg.spillDir, q.name+".jsonl")
+}
+
+// push 磁盘上还有数据时新数据也落盘, 保证写入顺序和 push 的顺序一致
+func (q *writeQueue[T]) push(v T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) >= q.cfg.size || q.spilled > 0 {
+		switch q.cfg.policy {
+		case policyDropOldest:
+			q.items = q.items[1:]
+			queueDropped.inc(q.name)
+		case policySpill:
+			if err := q.spill(v); err != nil {
+				// 磁盘也写不进去只能丢弃
+				queueDropped.inc(q.name)
+				q.log.Error("spill failed", "err", err)
+				return
+			}
+			q.notEmpty.Signal()
+			return
+		default:
+			q.notFull.Wait()
+		}
+	}
+	q.items = append(q.items, v)
+	q.notEmpty.Signal()
+}
+
+// spill 持锁调用
+func (q *writeQueue[T]) spill(v T) error {
+	if err := os.MkdirAll(q.cfg.spillDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(q.spillPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		return err
+	}
+	q.spilled++
+	queueSpilled.inc(q.name)
+	return nil
+}
+
+func (q *writeQueue[T]) run() {
+	// 上次退出时没有回放完的数据, 已经写入的部分会再写一次
+	if _, err := os.Stat(q.spillPath() + ".draining"); err == nil {
+		q.drain(q.spillPath() + ".draining")
+	}
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && q.spilled == 0 {
+			q.notEmpty.Wait()
+		}
+		if len(q.items) > 0 {
+			n := len(q.items)
+			if n > q.cfg.batch {
+				n = q.cfg.batch
+			}
+			batch := append([]T(nil), q.items[:n]...)
+			q.items = q.items[n:]
+			q.notFull.Broadcast()
+			q.mu.Unlock()
+			q.write(batch)
+			continue
+		}
+		// 内存排空后回放磁盘上的数据
+		path := q.spillPath() + ".draining"
+		err := os.Rename(q.spillPath(), path)
+		q.spilled = 0
+		q.mu.Unlock()
+		if err != nil {
+			q.log.Error("spill rename failed", "err", err)
+			continue
+		}
+		q.drain(path)
+	}
+}
+
+// drain 回放 path 中的数据, 全部写入之后才删除文件
+func (q *writeQueue[T]) drain(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		q.log.Error("spill open failed", "err", err)
+		return
+	}
+	defer f.Close()
+	var batch []T
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var v T
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			queueDropped.inc(q.name)
+			q.log.Error("spill decode failed", "err", err)
+			continue
+		}
+		batch = append(batch, v)
+		if len(batch) >= q.cfg.batch {
+			q.write(batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		q.write(batch)
+	}
+	if err := scanner.Err(); err != nil {
+		// 留着文件, 下次启动时再回放
+		q.log.Error("spill read failed", "err", err)
+		return
+	}
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		q.log.Error("spill remove failed", "err", err)
+	}
+}
+
+// write 失败时按指数退避重试直到写入成功. 数据库故障期间队列积压,
+// 生产者按 policy 阻塞, 丢弃最早的数据或落盘, 已经取出的数据不会丢
+func (q *writeQueue[T]) write(batch []T) {
+	wait := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := q.flush(batch)
+		if err == nil {
+			return
+		}
+		q.log.Error("write failed", "err", err, "items", len(batch), "attempt", attempt, "retry_in", wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > q.maxBackoff {
+			wait = q.maxBackoff
+		}
+	}
+}
+
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
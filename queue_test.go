@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSink 记录写入的数据, fail 为 true 时写入失败
+type testSink struct {
+	mu   sync.Mutex
+	fail bool
+	got  []int
+	errs int
+}
+
+func (s *testSink) flush(batch []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		s.errs++
+		return errors.New("database down")
+	}
+	s.got = append(s.got, batch...)
+	return nil
+}
+
+func (s *testSink) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+// wait 等到收到 n 条数据或出错 errs 次
+func (s *testSink) wait(t *testing.T, n, errs int) []int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		got, e := append([]int(nil), s.got...), s.errs
+		s.mu.Unlock()
+		if len(got) >= n && e >= errs {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v after %d errors, want %d items and %d errors", got, e, n, errs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestQueue(cfg queueConfig, s *testSink) *writeQueue[int] {
+	q := newWriteQueue("test", cfg, s.flush)
+	q.backoff, q.maxBackoff = time.Millisecond, 5*time.Millisecond
+	return q
+}
+
+func TestWriteQueueRetry(t *testing.T) {
+	s := &testSink{fail: true}
+	q := newTestQueue(queueConfig{size: 10, batch: 2}, s)
+	go q.run()
+	for i := 1; i <= 5; i++ {
+		q.push(i)
+	}
+	// 数据库故障期间数据留在队列里, 恢复后按顺序写入
+	s.wait(t, 0, 3)
+	s.setFail(false)
+	if got := s.wait(t, 5, 0); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestWriteQueuePolicies(t *testing.T) {
+	// 没有 writer, 只看 push 的行为
+	q := newTestQueue(queueConfig{size: 2, batch: 1, policy: policyDropOldest}, &testSink{})
+	for i := 1; i <= 3; i++ {
+		q.push(i)
+	}
+	if !reflect.DeepEqual(q.items, []int{2, 3}) {
+		t.Errorf("dropoldest: %v", q.items)
+	}
+
+	q = newTestQueue(queueConfig{size: 1, batch: 1}, &testSink{})
+	q.push(1)
+	pushed := make(chan bool)
+	go func() {
+		q.push(2)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("block: push returned on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.mu.Lock()
+	q.items = q.items[1:]
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+	<-pushed
+}
+
+func TestWriteQueueSpill(t *testing.T) {
+	dir := t.TempDir()
+	s := &testSink{fail: true}
+	q := newTestQueue(queueConfig{size: 1, batch: 2, policy: policySpill, spillDir: dir}, s)
+	q.push(1)
+	q.push(2)
+	go q.run()
+	// writer 取走 1 之后内存有空位, 但 2 还在磁盘上, 之后的数据也要落盘
+	s.wait(t, 0, 1)
+	for i := 3; i <= 6; i++ {
+		q.push(i)
+	}
+	// 故障期间放不下的数据落盘, 恢复后按顺序全部写入, 回放完才删除文件
+	s.wait(t, 0, 5)
+	s.setFail(false)
+	if got := s.wait(t, 6, 0); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("got %v, want 1..6 in order", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, _ := os.ReadDir(dir)
+		if len(files) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spill files left: %v", files)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 上次没有回放完的文件在启动时回放
+	os.WriteFile(q.spillPath()+".draining", []byte("7\n8\n"), 0o644)
+	s = &testSink{}
+	go newTestQueue(queueConfig{size: 1, batch: 2, policy: policySpill, spillDir: dir}, s).run()
+	if got := s.wait(t, 2, 0); !reflect.DeepEqual(got, []int{7, 8}) {
+		t.Errorf("restart: %v", got)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scaledClock 回放用的时钟, 从 origin 开始按 speed 倍速前进
+type scaledClock struct {
+	origin time.Time
+	start  time.Time
+	speed  float64
+}
+
+func newScaledClock(origin time.Time, speed float64) *scaledClock {
+	return &scaledClock{origin: origin, start: time.Now(), speed: speed}
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.origin.Add(time.Duration(float64(time.Since(c.start)) * c.speed))
+}
+
+// wall 模拟时间 d 对应的实际时间
+func (c *scaledClock) wall(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
+
+func (c *scaledClock) NewTicker(d time.Duration) ticker {
+	w := c.wall(d)
+	if w <= 0 {
+		w = 1
+	}
+	t := &scaledTicker{t: time.NewTicker(w), c: make(chan time.Time, 1), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-t.t.C:
+				// 和 time.Ticker 一样, 接收方跟不上时丢弃
+				select {
+				case t.c <- c.Now():
+				default:
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+type scaledTicker struct {
+	t    *time.Ticker
+	c    chan time.Time
+	done chan struct{}
+	once sync.Once
+}
+
+func (t *scaledTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *scaledTicker) Stop() {
+	t.once.Do(func() {
+		t.t.Stop()
+		close(t.done)
+	})
+}
+
+// checkReplayTarget 回放写入的是和实时采集相同的表, 库里已经有 from 之后的数据时拒绝,
+// 避免回放的数据和实时数据混在一起, 也避免覆盖 coinmarketcapcurrent
+func checkReplayTarget(ctx context.Context, store CandleStore, from time.Time, tables ...string) error {
+	for _, tbl := range tables {
+		latest, ok, err := store.LatestTimestamp(ctx, tbl)
+		if err != nil {
+			return err
+		}
+		if ok && latest >= from.Unix() {
+			return fmt.Errorf("--replay: %v already has data at %v, the recording starts at %v; replay into a separate database",
+				tbl, time.Unix(latest, 0).UTC().Format(time.RFC3339), from.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// recordingFiles path 为单个录制文件或目录, 目录按文件名顺序读取其中的
+// .jsonl, .jsonl.gz 和 .jsonl.zst, 即 --record 和 --archiveresponses 的输出
+func recordingFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if !fi.IsDir() && (strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz") || strings.HasSuffix(name, ".jsonl.zst")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings in %v", path)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	return files, nil
+}
+
+var errStopReplay = errors.New("stop replay")
+
+// firstRecording 返回第一条 source 的记录时间, 用作回放时钟的起点
+func firstRecording(files []string, source string) (time.Time, error) {
+	var first time.Time
+	for _, f := range files {
+		err := readArchive(context.Background(), localArchive{dir: filepath.Dir(f)}, filepath.Base(f), func(m json.RawMessage) error {
+			var r responseRecord
+			if err := json.Unmarshal(m, &r); err != nil {
+				return err
+			}
+			if r.Source == source {
+				first = r.Time
+				return errStopReplay
+			}
+			return nil
+		})
+		if err != nil && err != errStopReplay {
+			return first, fmt.Errorf("%v: %v", f, err)
+		}
+		if !first.IsZero() {
+			return first, nil
+		}
+	}
+	return first, fmt.Errorf("no %v responses recorded", source)
+}
+
+// maxLoggedBody 解析失败时日志中保留的响应长度
+const maxLoggedBody = 1 << 10
+
+// replayResponses 按录制时的间隔把 source 的响应依次交给 emit, clk 为 scaledClock 时按倍速回放.
+// 非 200 和解析失败的响应只记录日志, 和在线抓取时一样跳过
+func replayResponses(ctx context.Context, clk *scaledClock, files []string, source string, emit func(ts int64, list []priceCoinMarketCap)) error {
+	rlog := component("replay", "source", source)
+	var n, failed int
+	for _, f := range files {
+		err := readArchive(ctx, localArchive{dir: filepath.Dir(f)}, filepath.Base(f), func(m json.RawMessage) error {
+			var r responseRecord
+			if err := json.Unmarshal(m, &r); err != nil {
+				return err
+			}
+			if r.Source != source {
+				return nil
+			}
+			if d := r.Time.Sub(clk.Now()); d > 0 {
+				select {
+				case <-time.After(clk.wall(d)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			n++
+			if r.Status != 200 {
+				failed++
+				rlog.Warn("unexpected status", "time", r.Time, "status", r.Status)
+				return nil
+			}
+			var list []priceCoinMarketCap
+			if err := json.Unmarshal([]byte(r.Body), &list); err != nil {
+				failed++
+				// 完整的响应在录制文件里, 日志中只保留开头
+				body := r.Body
+				if len(body) > maxLoggedBody {
+					body = body[:maxLoggedBody] + "..."
+				}
+				rlog.Error("decode error", "file", f, "time", r.Time, "url", r.URL, "err", err, "body", body, "body_bytes", len(r.Body))
+				return nil
+			}
+			emit(r.Time.Unix(), list)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%v: %v", f, err)
+		}
+	}
+	rlog.Info("replay finished", "responses", n, "failed", failed)
+	return nil
+}
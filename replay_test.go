@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 录制一次成功, 一次解析失败和一次 500, 回放时只有成功的响应进入流水线
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	log, err := newResponseLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := []string{
+		`[{"id":"bitcoin","symbol":"BTC","price_usd":"100"}]`,
+		`[{"id":"bitcoin",`,
+		`rate limited`,
+		`[{"id":"bitcoin","symbol":"BTC","price_usd":"101"}]`,
+	}
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, bodies[calls])
+		calls++
+	}))
+	defer srv.Close()
+
+	f := newFetchClient(coinMarketCapSource, time.Second, 0, newBreaker(10, time.Second))
+	f.record = func(r *responseRecord) {
+		if err := log.record(r); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := range bodies {
+		var list []priceCoinMarketCap
+		err := f.getJSON(context.Background(), srv.URL, &list)
+		if want := i == 0 || i == 3; (err == nil) != want {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		if i < len(bodies)-1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	files, err := recordingFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := firstRecording(files, coinMarketCapSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := newScaledClock(first, 2)
+	type tick struct {
+		ts  int64
+		usd string
+		at  time.Time
+	}
+	var got []tick
+	err = replayResponses(context.Background(), clk, files, coinMarketCapSource, func(ts int64, list []priceCoinMarketCap) {
+		got = append(got, tick{ts, list[0].PriceUSD, clk.Now()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].usd != "100" || got[1].usd != "101" {
+		t.Fatalf("replayed %+v", got)
+	}
+	if got[0].ts != first.Unix() {
+		t.Errorf("timestamp %v, want %v", got[0].ts, first.Unix())
+	}
+	// 录制间隔约 60ms, 回放时按模拟时间不能提前
+	if d := got[1].at.Sub(got[0].at); d < 55*time.Millisecond {
+		t.Errorf("replay interval %v, want about 60ms", d)
+	}
+}
+
+func TestCheckReplayTarget(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	from := time.Unix(1514937600, 0)
+	if err := checkReplayTarget(ctx, store, from, "raw", coinmarketcapmin); err != nil {
+		t.Fatalf("empty store: %v", err)
+	}
+	// 回放范围之前的数据不冲突
+	store.SaveCandles(ctx, coinmarketcapmin, "", testTick(from.Unix()-60, 1))
+	if err := checkReplayTarget(ctx, store, from, "raw", coinmarketcapmin); err != nil {
+		t.Fatalf("older data: %v", err)
+	}
+	store.InsertTicks(ctx, "raw", &rawTicks{Timestamp: from.Unix() + 60})
+	if err := checkReplayTarget(ctx, store, from, "raw", coinmarketcapmin); err == nil || !strings.Contains(err.Error(), "raw already has data") {
+		t.Errorf("expected error, got %v", err)
+	}
+}
+
+func TestScaledClock(t *testing.T) {
+	origin := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newScaledClock(origin, 3600)
+	tk := clk.NewTicker(time.Minute)
+	defer tk.Stop()
+	// 一分钟的心跳实际约 17ms
+	at := <-tk.C()
+	if at.Before(origin.Add(time.Minute)) || at.After(origin.Add(time.Hour)) {
+		t.Errorf("tick at %v", at)
+	}
+	if !strings.HasPrefix(clk.Now().Format(time.RFC3339), "2018-01-01T00:") {
+		t.Errorf("now %v", clk.Now())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partitionDropper 支持按分区清理的存储实现, 返回删除的分区数
+type partitionDropper interface {
+	DropPartitionsBefore(ctx context.Context, tbl string, ts int64) (int, error)
+}
+
+// partitionMaintainer 需要提前创建分区的存储实现
+type partitionMaintainer interface {
+	MaintainPartitions(ctx context.Context, now time.Time) error
+}
+
+// retentionPolicy 每张表的保留时长, 没有配置的表永久保留
+type retentionPolicy struct {
+	keep  map[string]time.Duration
+	batch int
+	// dropPartitions 存储支持时先整块删除过期分区, 剩下的再按批删除
+	dropPartitions bool
+	// archive 不为空时原始表按整天归档后才删除
+	archive *archiver
+}
+
+// parseRetention 解析 "raw=7d,1m=30d,5m=180d,1h=forever",
+// 表可以写表名, 周期 (1m, 5m ... 1w) 或 raw (原始行情表)
+func parseRetention(s, rawTable string) (map[string]time.Duration, error) {
+	byTimeframe := make(map[string]string, len(timeframes))
+	for tbl, tf := range timeframes {
+		byTimeframe[tf] = tbl
+	}
+	keep := make(map[string]time.Duration)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("retention %q: want table=duration", item)
+		}
+		name := strings.TrimSpace(kv[0])
+		tbl := name
+		switch {
+		case name == "raw":
+			tbl = rawTable
+		case byTimeframe[name] != "":
+			tbl = byTimeframe[name]
+		case name == rawTable || timeframes[name] != "":
+		default:
+			return nil, fmt.Errorf("retention %q: unknown table %q", item, name)
+		}
+		if tbl == coinmarketcapcurrent {
+			return nil, fmt.Errorf("retention %q: %v only holds the latest snapshot", item, tbl)
+		}
+		d, err := parseKeep(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("retention %q: %v", item, err)
+		}
+		if d > 0 {
+			keep[tbl] = d
+		} else {
+			delete(keep, tbl)
+		}
+	}
+	return keep, nil
+}
+
+// parseKeep 在 time.ParseDuration 基础上支持天 (7d) 和 forever
+func parseKeep(s string) (time.Duration, error) {
+	switch s {
+	case "forever", "0":
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// runRetention 每隔 every 建好后续分区并按策略清理一次
+func runRetention(clk clock, store storage, p retentionPolicy, every time.Duration) {
+	ticker := clk.NewTicker(every)
+	defer ticker.Stop()
+	for now := range ticker.C() {
+		if m, ok := store.(partitionMaintainer); ok {
+			if err := m.MaintainPartitions(context.Background(), now); err != nil {
+				dbErrors.inc("partition")
+				component("partition").Error("create partitions failed", "err", err)
+			}
+		}
+		p.apply(context.Background(), store, now)
+	}
+}
+
+func (p retentionPolicy) apply(ctx context.Context, store storage, now time.Time) {
+	if p.archive != nil && p.archive.responses != nil {
+		if err := p.archive.archiveResponses(ctx, now); err != nil {
+			archiveErrors.inc()
+			component("archive").Error("archive responses failed", "err", err)
+		}
+	}
+	tables := make([]string, 0, len(p.keep))
+	for tbl := range p.keep {
+		tables = append(tables, tbl)
+	}
+	sort.Strings(tables)
+	for _, tbl := range tables {
+		rlog := component("retention", "table", tbl)
+		before := now.Add(-p.keep[tbl]).Unix()
+		if p.archive != nil && tbl == p.archive.table {
+			// 只删除已经整天归档的数据
+			before -= before % day
+			if err := p.archive.archiveTicks(ctx, store, before); err != nil {
+				archiveErrors.inc()
+				rlog.Error("archive failed, keeping rows", "err", err)
+				continue
+			}
+		}
+		if d, ok := store.(partitionDropper); ok && p.dropPartitions {
+			n, err := d.DropPartitionsBefore(ctx, tbl, before)
+			if err != nil {
+				dbErrors.inc("retention")
+				rlog.Error("drop partitions failed", "err", err)
+				continue
+			}
+			retentionPartitions.add(float64(n), tbl)
+		}
+		n, err := store.DeleteBefore(ctx, tbl, before, p.batch)
+		retentionDeleted.add(float64(n), tbl)
+		if err != nil {
+			dbErrors.inc("retention")
+			rlog.Error("delete failed", "err", err, "deleted", n)
+			continue
+		}
+		rlog.Debug("done", "before", before, "deleted", n)
+	}
+}
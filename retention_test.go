@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	got, err := parseRetention("raw=7d, 1m=30d,coinmarketcap5min=180d,1h=forever,1d=12h", "pricecoinmarketcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{
+		"pricecoinmarketcap": 7 * 24 * time.Hour,
+		coinmarketcapmin:     30 * 24 * time.Hour,
+		coinmarketcap5min:    180 * 24 * time.Hour,
+		coinmarketcapday:     12 * time.Hour,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for tbl, d := range want {
+		if got[tbl] != d {
+			t.Errorf("%v: got %v, want %v", tbl, got[tbl], d)
+		}
+	}
+
+	for _, s := range []string{"2m=1d", "1m", "1m=-1d", "1m=xd", "current=1d"} {
+		if _, err := parseRetention(s, "pricecoinmarketcap"); err == nil {
+			t.Errorf("parseRetention(%q): expected error", s)
+		}
+	}
+}
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	store := newMemoryStore()
+	ctx := context.Background()
+	for _, ts := range []time.Time{now.AddDate(0, 0, -8), now.AddDate(0, 0, -6)} {
+		store.InsertTicks(ctx, "raw", &rawTicks{List: []priceCoinMarketCap{{Id: "bitcoin"}}, Timestamp: ts.Unix()})
+		store.SaveCandles(ctx, coinmarketcapmin, "raw", testTick(ts.Unix(), 1))
+		store.SaveCandles(ctx, coinmarketcaphour, "raw", testTick(ts.Unix(), 1))
+	}
+
+	p := retentionPolicy{keep: map[string]time.Duration{
+		"raw":            7 * 24 * time.Hour,
+		coinmarketcapmin: 7 * 24 * time.Hour,
+	}, batch: 1}
+	p.apply(ctx, store, now)
+
+	if got := len(store.ticks["raw"]); got != 1 {
+		t.Errorf("raw: %d ticks left, want 1", got)
+	}
+	if got := len(store.Candles(coinmarketcapmin)); got != 1 {
+		t.Errorf("%v: %d candles left, want 1", coinmarketcapmin, got)
+	}
+	// 没有配置的表永久保留
+	if got := len(store.Candles(coinmarketcaphour)); got != 2 {
+		t.Errorf("%v: %d candles left, want 2", coinmarketcaphour, got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// assetFreshness 一个资产上游 last_updated 的变化情况
+type assetFreshness struct {
+	lastUpdated int64
+	// since last_updated 最近一次前进时的抓取时间
+	since    int64
+	excluded bool
+}
+
+// staleTracker 按资产记录上游的 last_updated, 没有前进的数据标记为 Stale.
+// exclude > 0 时 last_updated 超过 exclude 没有前进的资产 (停牌, 下架) 不再参与聚合,
+// 恢复更新后自动加回. 只在抓取的 goroutine 中使用
+type staleTracker struct {
+	source  string
+	exclude int64
+	assets  map[string]*assetFreshness
+	log     *slog.Logger
+}
+
+func newStaleTracker(source string, exclude time.Duration) *staleTracker {
+	return &staleTracker{
+		source:  source,
+		exclude: int64(exclude / time.Second),
+		assets:  make(map[string]*assetFreshness),
+		log:     component("stale", "source", source),
+	}
+}
+
+// apply 标记 x 中的 Stale 并去掉被排除的资产
+func (s *staleTracker) apply(x *kPriceCoinMarketCapList) {
+	if s == nil {
+		return
+	}
+	list := x.list[:0]
+	for _, v := range x.list {
+		if s.check(x.timestamp, &v) {
+			list = append(list, v)
+		}
+	}
+	x.list = list
+	var n int
+	for _, a := range s.assets {
+		if a.excluded {
+			n++
+		}
+	}
+	excludedAssets.set(float64(n), s.source)
+}
+
+func (s *staleTracker) check(ts int64, v *kPriceCoinMarketCap) bool {
+	updated := v.LastUpdated.Int64()
+	a := s.assets[v.Id]
+	if a == nil {
+		s.assets[v.Id] = &assetFreshness{lastUpdated: updated, since: ts}
+		return true
+	}
+	if updated > a.lastUpdated {
+		if a.excluded {
+			s.log.Info("asset resumed", "asset", v.Id, "last_updated", updated)
+		}
+		a.lastUpdated, a.since, a.excluded = updated, ts, false
+		return true
+	}
+	v.Stale = true
+	staleTicks.inc(s.source)
+	if s.exclude <= 0 || ts-a.since < s.exclude {
+		return true
+	}
+	if !a.excluded {
+		a.excluded = true
+		s.log.Warn("asset excluded", "asset", v.Id, "last_updated", a.lastUpdated, "stale_for", time.Duration(ts-a.since)*time.Second)
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"market/candles"
+)
+
+// staleTick 一个资产一次抓取, updated 为上游的 last_updated
+func staleTick(ts int64, id string, updated int64) *kPriceCoinMarketCapList {
+	x := testTick(ts, 100)
+	x.list[0].Id = id
+	x.list[0].Symbol = id
+	x.list[0].LastUpdated.SetInt64(updated)
+	return x
+}
+
+func TestStaleTracker(t *testing.T) {
+	s := newStaleTracker(coinMarketCapSource, 3*time.Minute)
+	base := int64(1514937600)
+	var got []string
+	for i, updated := range []int64{base, base + 60, base + 60, base + 60, base + 60, base + 60, base + 300} {
+		ts := base + int64(i)*60
+		x := staleTick(ts, "frozen", updated)
+		x.list = append(x.list, staleTick(ts, "live", ts).list...)
+		s.apply(x)
+		var row string
+		for _, v := range x.list {
+			row += fmt.Sprintf("%v:%v ", v.Id, v.Stale)
+		}
+		got = append(got, row)
+	}
+	want := []string{
+		"frozen:false live:false ",
+		"frozen:false live:false ",
+		"frozen:true live:false ",
+		"frozen:true live:false ",
+		// 三分钟没有更新, 排除
+		"live:false ",
+		"live:false ",
+		// 恢复更新后加回
+		"frozen:false live:false ",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("tick %d: %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// 周期内只要有一次更新就不算 stale
+	x, y := staleTick(base, "frozen", base), staleTick(base+30, "frozen", base)
+	x.list[0].Stale, y.list[0].Stale = true, false
+	summary(x, y)
+	if x.list[0].Stale {
+		t.Errorf("candle with a fresh tick flagged stale")
+	}
+}
+
+func TestStaleColumnSQLite(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// 旧版本建的表没有 stale 列
+	if _, err := store.db.Exec(fmt.Sprintf("CREATE TABLE %v (id INTEGER PRIMARY KEY, asset_id TEXT NOT NULL, name TEXT NOT NULL, symbol TEXT NOT NULL, rank INTEGER NOT NULL, "+
+		"price_usd_first REAL NOT NULL, price_usd_last REAL NOT NULL, price_usd_low REAL NOT NULL, price_usd_high REAL NOT NULL, "+
+		"price_btc_first REAL NOT NULL, price_btc_last REAL NOT NULL, price_btc_low REAL NOT NULL, price_btc_high REAL NOT NULL, "+
+		"price_cny_first REAL NOT NULL, price_cny_last REAL NOT NULL, price_cny_low REAL NOT NULL, price_cny_high REAL NOT NULL, "+
+		"last_updated INTEGER NOT NULL, timestamp INTEGER NOT NULL, _group TEXT);", coinmarketcapmin)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(ctx, "ticks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(ctx, "ticks"); err != nil {
+		t.Fatalf("second init: %v", err)
+	}
+	x := testTick(1514937600, 1)
+	x.list[0].Stale = true
+	if err := store.SaveCandles(ctx, coinmarketcapmin, "", x, testTick(1514937660, 2)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = store.ScanCandles(ctx, candles.Query{Table: coinmarketcapmin}, func(r *candleRow) error {
+		got = append(got, strconv.FormatBool(r.Stale))
+		return nil
+	})
+	if err != nil || len(got) != 2 || got[0] != "true" || got[1] != "false" {
+		t.Errorf("stale %v, %v", got, err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"market/candles"
+
+	"github.com/urfave/cli"
+)
+
+// rawTicks 一次抓取的原始数据
+type rawTicks struct {
+	List      []priceCoinMarketCap `json:"list"`
+	Timestamp int64                `json:"timestamp"`
+}
+
+// TickStore 原始行情表
+type TickStore interface {
+	InsertTicks(ctx context.Context, tbl string, ticks ...*rawTicks) error
+	// ScanTicks 按 timestamp 顺序回调 [from, to) 内的每次抓取
+	ScanTicks(ctx context.Context, tbl string, from, to int64, fn func(*rawTicks) error) error
+	// EarliestTimestamp 返回最早一次抓取的 timestamp, 空表返回 ok=false
+	EarliestTimestamp(ctx context.Context, tbl string) (ts int64, ok bool, err error)
+}
+
+// CandleStore 周期表和 coinmarketcapcurrent
+type CandleStore interface {
+	SaveCandles(ctx context.Context, tbl, group string, dats ...*kPriceCoinMarketCapList) error
+	// ReplaceCurrent 用 dat 替换 coinmarketcapcurrent 中 group 的全部数据
+	ReplaceCurrent(ctx context.Context, group string, dat *kPriceCoinMarketCapList) error
+	// LatestTimestamp 返回表中最新一行的 timestamp, 空表返回 ok=false
+	LatestTimestamp(ctx context.Context, tbl string) (ts int64, ok bool, err error)
+	// ScanCandles 按 timestamp, symbol 顺序逐行回调, 不把结果全部读进内存
+	ScanCandles(ctx context.Context, q candleQuery, fn func(*candleRow) error) error
+	// UpsertCandles 在一个事务里写入, 已有相同 asset_id, timestamp, _group 的行被替换,
+	// rows 中同键的行只写最后一个
+	UpsertCandles(ctx context.Context, tbl string, rows ...*candleRow) error
+}
+
+type candleKey struct {
+	assetID   string
+	timestamp int64
+	group     string
+}
+
+// dedupeCandles 同键的行只保留最后一个, 按第一次出现的顺序排列
+func dedupeCandles(rows []*candleRow) []*candleRow {
+	index := make(map[candleKey]int, len(rows))
+	ret := make([]*candleRow, 0, len(rows))
+	for _, r := range rows {
+		k := candleKey{r.AssetID, r.Timestamp, r.Group}
+		if i, ok := index[k]; ok {
+			ret[i] = r
+			continue
+		}
+		index[k] = len(ret)
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// candleQuery 周期表查询条件, 时间范围为 [From, To), 0 表示不限
+type candleQuery = candles.Query
+
+// candleRow 周期表的一行, 字段顺序同 candleColumns
+type candleRow = candles.Row
+
+func newCandleRow(v *kPriceCoinMarketCap, timestamp int64, group string) *candleRow {
+	r := &candleRow{AssetID: v.Id, Name: v.Name, Symbol: v.Symbol, Rank: v.Rank.Int64(),
+		LastUpdated: v.LastUpdated.Int64(), Timestamp: timestamp, Group: group, Stale: v.Stale,
+		VolumeBase: v.VolumeBase, VolumeQuote: v.VolumeQuote, Trades: v.Trades, VWAP: v.vwap()}
+	r.PriceUSDFirst, _ = v.PriceUSDFirst.Float64()
+	r.PriceUSDLast, _ = v.PriceUSDLast.Float64()
+	r.PriceUSDLow, _ = v.PriceUSDLow.Float64()
+	r.PriceUSDHigh, _ = v.PriceUSDHigh.Float64()
+	r.PriceBTCFirst, _ = v.PriceBTCFirst.Float64()
+	r.PriceBTCLast, _ = v.PriceBTCLast.Float64()
+	r.PriceBTCLow, _ = v.PriceBTCLow.Float64()
+	r.PriceBTCHigh, _ = v.PriceBTCHigh.Float64()
+	r.PriceCNYFirst, _ = v.PriceCNYFirst.Float64()
+	r.PriceCNYLast, _ = v.PriceCNYLast.Float64()
+	r.PriceCNYLow, _ = v.PriceCNYLow.Float64()
+	r.PriceCNYHigh, _ = v.PriceCNYHigh.Float64()
+	return r
+}
+
+// rowCandle 转回聚合使用的结构
+func rowCandle(r *candleRow) kPriceCoinMarketCap {
+	var v kPriceCoinMarketCap
+	v.Id, v.Name, v.Symbol = r.AssetID, r.Name, r.Symbol
+	v.Rank.SetInt64(r.Rank)
+	v.PriceUSDFirst.SetFloat64(r.PriceUSDFirst)
+	v.PriceUSDLast.SetFloat64(r.PriceUSDLast)
+	v.PriceUSDLow.SetFloat64(r.PriceUSDLow)
+	v.PriceUSDHigh.SetFloat64(r.PriceUSDHigh)
+	v.PriceBTCFirst.SetFloat64(r.PriceBTCFirst)
+	v.PriceBTCLast.SetFloat64(r.PriceBTCLast)
+	v.PriceBTCLow.SetFloat64(r.PriceBTCLow)
+	v.PriceBTCHigh.SetFloat64(r.PriceBTCHigh)
+	v.PriceCNYFirst.SetFloat64(r.PriceCNYFirst)
+	v.PriceCNYLast.SetFloat64(r.PriceCNYLast)
+	v.PriceCNYLow.SetFloat64(r.PriceCNYLow)
+	v.PriceCNYHigh.SetFloat64(r.PriceCNYHigh)
+	v.LastUpdated.SetInt64(r.LastUpdated)
+	v.Stale = r.Stale
+	v.VolumeBase, v.VolumeQuote, v.Trades = r.VolumeBase, r.VolumeQuote, r.Trades
+	return v
+}
+
+// Retainer 按 timestamp 清理过期数据
+type Retainer interface {
+	// DeleteBefore 删除 timestamp < ts 的行, batch > 0 时分批删除以缩短锁表时间, 返回删除的行数
+	DeleteBefore(ctx context.Context, tbl string, ts int64, batch int) (int64, error)
+}
+
+type storage interface {
+	TickStore
+	CandleStore
+	Retainer
+	// Init 建表, rawTable 为原始行情表名
+	Init(ctx context.Context, rawTable string) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// 周期表, coinmarketcapcurrent 和周期表同结构
+var candleTables = []string{
+	coinmarketcapmin,
+	coinmarketcap5min,
+	coinmarketcap10min,
+	coinmarketcap15min,
+	coinmarketcap30min,
+	coinmarketcaphour,
+	coinmarketcapday,
+	coinmarketcapweek,
+	coinmarketcapcurrent,
+}
+
+// openStorage 按 drivername 选择存储实现
+func openStorage(c *cli.Context) (storage, error) {
+	dsn := fmt.Sprintf("user=%v password=%v host=%v dbname=%v port=%v sslmode=disable",
+		c.GlobalString("dbusername"), c.GlobalString("dbpassword"), c.GlobalString("dbhost"),
+		c.GlobalString("dbname"), c.GlobalString("dbport"))
+	switch driver := c.GlobalString("drivername"); driver {
+	case "postgres":
+		if c.GlobalBool("partitioned") {
+			return openPartitioned(dsn, c.GlobalInt("partitionsahead"))
+		}
+		return openPostgres(dsn)
+	case "timescaledb":
+		return openTimescale(dsn, c.GlobalDuration("compressafter"), c.GlobalBool("continuousaggs"))
+	case "memory":
+		return newMemoryStore(), nil
+	case "sqlite3":
+		// dbname 作为数据库文件路径
+		return openSQLite(c.GlobalString("dbname"))
+	default:
+		return nil, fmt.Errorf("unsupported drivername %q", driver)
+	}
+}
+
+var candleColumns = candles.Columns
+
+var rawColumns = []string{
+	"asset_id", "name", "symbol", "rank",
+	"price_usd", "price_btc", "volume_usd_24h", "market_cap_usd",
+	"available_supply", "total_supply",
+	"percent_change_1h", "percent_change_24h", "percent_change_7d",
+	"last_updated", "price_cny", "volume_cny_24h", "market_cap_cny",
+	"timestamp",
+}
+
+// deleteBatches 重复执行删除语句直到不足一批, 每批单独提交.
+// batch <= 0 时 q 只带 ts 一个参数, 执行一次
+func deleteBatches(ctx context.Context, db *sql.DB, q string, ts int64, batch int) (int64, error) {
+	var total int64
+	for {
+		args := []interface{}{ts}
+		if batch > 0 {
+			args = append(args, batch)
+		}
+		res, err := db.ExecContext(ctx, q, args...)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if batch <= 0 || n < int64(batch) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// scanTicks 把同一 timestamp 的行合并成一次抓取
+func scanTicks(ctx context.Context, db *sql.DB, query string, from, to int64, fn func(*rawTicks) error) error {
+	rows, err := db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var cur *rawTicks
+	for rows.Next() {
+		var v priceCoinMarketCap
+		var ts int64
+		if err := rows.Scan(&v.Id, &v.Name, &v.Symbol, &v.Rank, &v.PriceUSD, &v.PriceBTC, &v.VolumeUSD24H, &v.MarketCapUSD,
+			&v.AvailableSupply, &v.TotalSupply, &v.PercentChanage1H, &v.PercentChanage24H, &v.PercentChanage7D,
+			&v.LastUpdated, &v.PriceCNY, &v.VolumeCNY24H, &v.MarketCapCNY, &ts); err != nil {
+			return err
+		}
+		if cur != nil && cur.Timestamp != ts {
+			if err := fn(cur); err != nil {
+				return err
+			}
+			cur = nil
+		}
+		if cur == nil {
+			cur = &rawTicks{Timestamp: ts}
+		}
+		cur.List = append(cur.List, v)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if cur != nil {
+		return fn(cur)
+	}
+	return nil
+}
+
+// candleArgs 按 candleColumns 的顺序展开一行
+func candleArgs(v *kPriceCoinMarketCap, timestamp int64, group string) []interface{} {
+	return newCandleRow(v, timestamp, group).Values()
+}
+
+// rawArgs 按 rawColumns 的顺序展开一行
+func rawArgs(v *priceCoinMarketCap, timestamp int64) []interface{} {
+	return []interface{}{v.Id, v.Name, v.Symbol, v.Rank, v.PriceUSD, v.PriceBTC, v.VolumeUSD24H, v.MarketCapUSD, v.AvailableSupply,
+		v.TotalSupply, v.PercentChanage1H, v.PercentChanage24H, v.PercentChanage7D, v.LastUpdated, v.PriceCNY, v.VolumeCNY24H, v.MarketCapCNY,
+		timestamp}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"market/candles"
+)
+
+const tblAlerts = `
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id SERIAL PRIMARY KEY,
+		name character varying(64) NOT NULL DEFAULT '',
+		asset character varying(32) NOT NULL,
+		kind character varying(16) NOT NULL,
+		threshold double precision NOT NULL,
+		window_seconds bigint NOT NULL DEFAULT 0,
+		cooldown_seconds bigint NOT NULL DEFAULT 0,
+		currency character varying(8) NOT NULL DEFAULT 'usd',
+		webhook text NOT NULL DEFAULT '',
+		enabled boolean NOT NULL DEFAULT true
+	);
+
+	CREATE TABLE IF NOT EXISTS alert_history (
+		id SERIAL PRIMARY KEY,
+		rule_id integer NOT NULL,
+		rule character varying(64) NOT NULL,
+		kind character varying(16) NOT NULL,
+		asset_id character varying(32) NOT NULL,
+		symbol character varying(32) NOT NULL,
+		currency character varying(8) NOT NULL,
+		value double precision NOT NULL,
+		threshold double precision NOT NULL,
+		timestamp bigint NOT NULL,
+		message text NOT NULL,
+		delivered boolean NOT NULL,
+		error text NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS index_timestamp_alert_history ON alert_history (timestamp);
+`
+
+var sqliteAlerts = strings.NewReplacer(
+	"id SERIAL PRIMARY KEY", "id INTEGER PRIMARY KEY AUTOINCREMENT",
+).Replace(tblAlerts)
+
+var alertRuleColumns = []string{"name", "asset", "kind", "threshold", "window_seconds", "cooldown_seconds", "currency", "webhook", "enabled"}
+
+var alertHistoryColumns = []string{"rule_id", "rule", "kind", "asset_id", "symbol", "currency", "value", "threshold", "timestamp", "message", "delivered", "error"}
+
+// sqlAlerts postgres 和 sqlite3 共用的实现, 只有占位符不同
+type sqlAlerts struct {
+	db          *sql.DB
+	placeholder candles.Placeholder
+}
+
+func (s sqlAlerts) placeholders(n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = s.placeholder(i + 1)
+	}
+	return strings.Join(ps, ", ")
+}
+
+func (s sqlAlerts) add(ctx context.Context, r *alertRule) (int64, error) {
+	q := fmt.Sprintf("INSERT INTO alert_rules (%v) VALUES (%v) RETURNING id;", strings.Join(alertRuleColumns, ", "), s.placeholders(len(alertRuleColumns)))
+	var id int64
+	err := s.db.QueryRowContext(ctx, q, r.Name, r.Asset, r.Kind, r.Threshold, int64(r.Window/time.Second),
+		int64(r.Cooldown/time.Second), r.Currency, r.Webhook, r.Enabled).Scan(&id)
+	return id, err
+}
+
+func (s sqlAlerts) delete(ctx context.Context, id int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM alert_rules WHERE id = "+s.placeholder(1)+";", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s sqlAlerts) rules(ctx context.Context) ([]alertRule, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT id, %v FROM alert_rules ORDER BY id;", strings.Join(alertRuleColumns, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []alertRule
+	for rows.Next() {
+		var r alertRule
+		var window, cooldown int64
+		if err := rows.Scan(&r.ID, &r.Name, &r.Asset, &r.Kind, &r.Threshold, &window, &cooldown, &r.Currency, &r.Webhook, &r.Enabled); err != nil {
+			return nil, err
+		}
+		r.Window, r.Cooldown = time.Duration(window)*time.Second, time.Duration(cooldown)*time.Second
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func (s sqlAlerts) save(ctx context.Context, a *alertEvent) error {
+	q := fmt.Sprintf("INSERT INTO alert_history (%v) VALUES (%v) RETURNING id;", strings.Join(alertHistoryColumns, ", "), s.placeholders(len(alertHistoryColumns)))
+	return s.db.QueryRowContext(ctx, q, a.RuleID, a.Rule, a.Kind, a.AssetID, a.Symbol, a.Currency, a.Value, a.Threshold,
+		a.Timestamp, a.Message, a.Delivered, a.Error).Scan(&a.ID)
+}
+
+func (s sqlAlerts) history(ctx context.Context, limit int) ([]alertEvent, error) {
+	q := fmt.Sprintf("SELECT id, %v FROM alert_history ORDER BY id DESC LIMIT %v;", strings.Join(alertHistoryColumns, ", "), s.placeholder(1))
+	rows, err := s.db.QueryContext(ctx, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []alertEvent
+	for rows.Next() {
+		var a alertEvent
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.Rule, &a.Kind, &a.AssetID, &a.Symbol, &a.Currency, &a.Value, &a.Threshold,
+			&a.Timestamp, &a.Message, &a.Delivered, &a.Error); err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+func (s *pgStore) alerts() sqlAlerts { return sqlAlerts{s.db, candles.Dollar} }
+
+func (s *pgStore) InitAlerts(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, tblAlerts)
+	return err
+}
+
+func (s *pgStore) AddAlertRule(ctx context.Context, r *alertRule) (int64, error) {
+	return s.alerts().add(ctx, r)
+}
+
+func (s *pgStore) DeleteAlertRule(ctx context.Context, id int64) (bool, error) {
+	return s.alerts().delete(ctx, id)
+}
+
+func (s *pgStore) AlertRules(ctx context.Context) ([]alertRule, error) {
+	return s.alerts().rules(ctx)
+}
+
+func (s *pgStore) SaveAlert(ctx context.Context, a *alertEvent) error {
+	return s.alerts().save(ctx, a)
+}
+
+func (s *pgStore) AlertHistory(ctx context.Context, limit int) ([]alertEvent, error) {
+	return s.alerts().history(ctx, limit)
+}
+
+func (s *sqliteStore) alerts() sqlAlerts { return sqlAlerts{s.db, candles.Question} }
+
+func (s *sqliteStore) InitAlerts(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteAlerts)
+	return err
+}
+
+func (s *sqliteStore) AddAlertRule(ctx context.Context, r *alertRule) (int64, error) {
+	return s.alerts().add(ctx, r)
+}
+
+func (s *sqliteStore) DeleteAlertRule(ctx context.Context, id int64) (bool, error) {
+	return s.alerts().delete(ctx, id)
+}
+
+func (s *sqliteStore) AlertRules(ctx context.Context) ([]alertRule, error) {
+	return s.alerts().rules(ctx)
+}
+
+func (s *sqliteStore) SaveAlert(ctx context.Context, a *alertEvent) error {
+	return s.alerts().save(ctx, a)
+}
+
+func (s *sqliteStore) AlertHistory(ctx context.Context, limit int) ([]alertEvent, error) {
+	return s.alerts().history(ctx, limit)
+}
+
+func (s *memoryStore) InitAlerts(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) AddAlertRule(ctx context.Context, r *alertRule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alertSeq++
+	rule := *r
+	rule.ID = s.alertSeq
+	s.alertRules = append(s.alertRules, rule)
+	return rule.ID, nil
+}
+
+func (s *memoryStore) DeleteAlertRule(ctx context.Context, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.alertRules {
+		if r.ID == id {
+			s.alertRules = append(s.alertRules[:i:i], s.alertRules[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) AlertRules(ctx context.Context) ([]alertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]alertRule(nil), s.alertRules...), nil
+}
+
+func (s *memoryStore) SaveAlert(ctx context.Context, a *alertEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.ID = int64(len(s.alertHistory) + 1)
+	s.alertHistory = append(s.alertHistory, *a)
+	return nil
+}
+
+func (s *memoryStore) AlertHistory(ctx context.Context, limit int) ([]alertEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []alertEvent
+	for i := len(s.alertHistory) - 1; i >= 0 && len(ret) < limit; i-- {
+		ret = append(ret, s.alertHistory[i])
+	}
+	return ret, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type memoryCandles struct {
+	group string
+	dat   *kPriceCoinMarketCapList
+}
+
+// memoryStore 进程内存储, 用于测试和不需要持久化的本地运行
+type memoryStore struct {
+	mu      sync.Mutex
+	ticks   map[string][]*rawTicks
+	candles map[string][]memoryCandles
+	// indicators 物化的指标, 按写入顺序
+	indicators []indicatorRow
+	// rates, converted 汇率和换算后的周期数据, 按写入顺序
+	rates     []fxRate
+	converted []convertedRow
+	// 告警规则和历史
+	alertSeq     int64
+	alertRules   []alertRule
+	alertHistory []alertEvent
+	rejected     []rejectedTick
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		ticks:   make(map[string][]*rawTicks),
+		candles: make(map[string][]memoryCandles),
+	}
+}
+
+func (s *memoryStore) Init(ctx context.Context, rawTable string) error {
+	return nil
+}
+
+func (s *memoryStore) InsertTicks(ctx context.Context, tbl string, ticks ...*rawTicks) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ticks[tbl] = append(s.ticks[tbl], ticks...)
+	return nil
+}
+
+// DeleteBefore 内存中没有锁表问题, 忽略 batch, 按行数计
+func (s *memoryStore) DeleteBefore(ctx context.Context, tbl string, ts int64, batch int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	var ticks []*rawTicks
+	for _, t := range s.ticks[tbl] {
+		if t.Timestamp < ts {
+			n += int64(len(t.List))
+			continue
+		}
+		ticks = append(ticks, t)
+	}
+	s.ticks[tbl] = ticks
+	var candles []memoryCandles
+	for _, c := range s.candles[tbl] {
+		if c.dat.timestamp < ts {
+			n += int64(len(c.dat.list))
+			continue
+		}
+		candles = append(candles, c)
+	}
+	s.candles[tbl] = candles
+	return n, nil
+}
+
+func (s *memoryStore) SaveCandles(ctx context.Context, tbl, group string, dats ...*kPriceCoinMarketCapList) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dat := range dats {
+		s.candles[tbl] = append(s.candles[tbl], memoryCandles{group: group, dat: dat.Copy()})
+	}
+	return nil
+}
+
+func (s *memoryStore) ReplaceCurrent(ctx context.Context, group string, dat *kPriceCoinMarketCapList) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []memoryCandles
+	for _, c := range s.candles[coinmarketcapcurrent] {
+		if c.group != group {
+			kept = append(kept, c)
+		}
+	}
+	s.candles[coinmarketcapcurrent] = append(kept, memoryCandles{group: group, dat: dat.Copy()})
+	return nil
+}
+
+func (s *memoryStore) LatestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest int64
+	var ok bool
+	for _, c := range s.candles[tbl] {
+		if !ok || c.dat.timestamp > latest {
+			latest, ok = c.dat.timestamp, true
+		}
+	}
+	for _, t := range s.ticks[tbl] {
+		if !ok || t.Timestamp > latest {
+			latest, ok = t.Timestamp, true
+		}
+	}
+	return latest, ok, nil
+}
+
+func (s *memoryStore) UpsertCandles(ctx context.Context, tbl string, rows ...*candleRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range dedupeCandles(rows) {
+		var kept []memoryCandles
+		for _, c := range s.candles[tbl] {
+			if c.group == r.Group && c.dat.timestamp == r.Timestamp {
+				dat := &kPriceCoinMarketCapList{timestamp: c.dat.timestamp}
+				for _, v := range c.dat.list {
+					if v.Id != r.AssetID {
+						dat.list = append(dat.list, v)
+					}
+				}
+				if len(dat.list) == 0 {
+					continue
+				}
+				c.dat = dat
+			}
+			kept = append(kept, c)
+		}
+		dat := &kPriceCoinMarketCapList{list: []kPriceCoinMarketCap{rowCandle(r)}, timestamp: r.Timestamp}
+		s.candles[tbl] = append(kept, memoryCandles{group: r.Group, dat: dat})
+	}
+	return nil
+}
+
+func (s *memoryStore) ScanTicks(ctx context.Context, tbl string, from, to int64, fn func(*rawTicks) error) error {
+	s.mu.Lock()
+	var ticks []*rawTicks
+	for _, t := range s.ticks[tbl] {
+		if t.Timestamp >= from && t.Timestamp < to {
+			ticks = append(ticks, t)
+		}
+	}
+	s.mu.Unlock()
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].Timestamp < ticks[j].Timestamp })
+	for _, t := range ticks {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) EarliestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var earliest int64
+	var ok bool
+	for _, t := range s.ticks[tbl] {
+		if t.Timestamp > 0 && (!ok || t.Timestamp < earliest) {
+			earliest, ok = t.Timestamp, true
+		}
+	}
+	return earliest, ok, nil
+}
+
+func (s *memoryStore) ScanCandles(ctx context.Context, q candleQuery, fn func(*candleRow) error) error {
+	s.mu.Lock()
+	var rows []*candleRow
+	for _, c := range s.candles[q.Table] {
+		for i := range c.dat.list {
+			if v := &c.dat.list[i]; q.Match(v.Id, v.Symbol, c.group, c.dat.timestamp) {
+				rows = append(rows, newCandleRow(v, c.dat.timestamp, c.group))
+			}
+		}
+	}
+	s.mu.Unlock()
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Timestamp != rows[j].Timestamp {
+			return rows[i].Timestamp < rows[j].Timestamp
+		}
+		return rows[i].Symbol < rows[j].Symbol
+	})
+	for _, r := range rows {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) InitIndicators(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) SaveIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rows {
+		s.indicators = append(s.indicators, *r)
+	}
+	return nil
+}
+
+func (s *memoryStore) UpsertIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rows {
+		var kept []indicatorRow
+		for _, v := range s.indicators {
+			if v.AssetID != r.AssetID || v.Timeframe != r.Timeframe || v.Indicator != r.Indicator || v.Field != r.Field || v.Timestamp != r.Timestamp {
+				kept = append(kept, v)
+			}
+		}
+		s.indicators = append(kept, *r)
+	}
+	return nil
+}
+
+// Indicators 返回物化的指标
+func (s *memoryStore) Indicators() []indicatorRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]indicatorRow(nil), s.indicators...)
+}
+
+func (s *memoryStore) InitConverted(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) SaveFXRates(ctx context.Context, rates ...fxRate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rates = append(s.rates, rates...)
+	return nil
+}
+
+func (s *memoryStore) SaveConverted(ctx context.Context, rows ...*convertedRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rows {
+		s.converted = append(s.converted, *r)
+	}
+	return nil
+}
+
+func (s *memoryStore) UpsertConverted(ctx context.Context, rows ...*convertedRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rows {
+		var kept []convertedRow
+		for _, v := range s.converted {
+			if v.AssetID != r.AssetID || v.Timeframe != r.Timeframe || v.Quote != r.Quote || v.Timestamp != r.Timestamp {
+				kept = append(kept, v)
+			}
+		}
+		s.converted = append(kept, *r)
+	}
+	return nil
+}
+
+// Converted 返回换算后的周期数据
+func (s *memoryStore) Converted() []convertedRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]convertedRow(nil), s.converted...)
+}
+
+func (s *memoryStore) InitRejected(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) SaveRejected(ctx context.Context, ticks ...*rejectedTick) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range ticks {
+		s.rejected = append(s.rejected, *r)
+	}
+	return nil
+}
+
+// Candles 返回表中按写入顺序排列的数据
+func (s *memoryStore) Candles(tbl string) []*kPriceCoinMarketCapList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*kPriceCoinMarketCapList, 0, len(s.candles[tbl]))
+	for _, c := range s.candles[tbl] {
+		ret = append(ret, c.dat.Copy())
+	}
+	return ret
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
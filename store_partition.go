@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// partitionSpan 分区跨度, 分区键为 unix 秒的 timestamp 列
+type partitionSpan int
+
+const (
+	spanDay partitionSpan = iota + 1
+	spanMonth
+	spanYear
+)
+
+func (s partitionSpan) start(t time.Time) time.Time {
+	t = t.UTC()
+	switch s {
+	case spanDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case spanMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func (s partitionSpan) next(t time.Time) time.Time {
+	switch s {
+	case spanDay:
+		return t.AddDate(0, 0, 1)
+	case spanMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(1, 0, 0)
+	}
+}
+
+func (s partitionSpan) layout() string {
+	switch s {
+	case spanDay:
+		return "20060102"
+	case spanMonth:
+		return "200601"
+	default:
+		return "2006"
+	}
+}
+
+// partitionName 分区表名, 如 pricecoinmarketcap_p20180103
+func partitionName(tbl string, s partitionSpan, start time.Time) string {
+	return tbl + "_p" + start.Format(s.layout())
+}
+
+// parsePartitionName 从分区名还原起始时间, 不是本表的分区返回 false
+func parsePartitionName(tbl string, s partitionSpan, name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, tbl+"_p") {
+		return time.Time{}, false
+	}
+	start, err := time.ParseInLocation(s.layout(), strings.TrimPrefix(name, tbl+"_p"), time.UTC)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// 原始表按天, 一分钟表按月, 其余 30 分钟以内的表按年, 更长周期数据量小不分区
+func partitionSpans(rawTable string) map[string]partitionSpan {
+	return map[string]partitionSpan{
+		rawTable:           spanDay,
+		coinmarketcapmin:   spanMonth,
+		coinmarketcap5min:  spanYear,
+		coinmarketcap10min: spanYear,
+		coinmarketcap15min: spanYear,
+		coinmarketcap30min: spanYear,
+	}
+}
+
+// partitioned 把建表语句改成按 timestamp 范围分区, 主键必须包含分区键.
+// 建表语句的格式变了对不上时返回错误, 不会悄悄建出普通表
+func partitioned(ddl string) (string, error) {
+	const pk, end = "id SERIAL PRIMARY KEY,", "\n\t);"
+	if strings.Count(ddl, pk) != 1 || strings.Count(ddl, end) != 1 || !strings.Contains(ddl, "timestamp ") {
+		return "", fmt.Errorf("partitioned: unexpected table definition:\n%v", ddl)
+	}
+	ddl = strings.Replace(ddl, pk, "id SERIAL,", 1)
+	return strings.Replace(ddl, end, ",\n\t\tPRIMARY KEY (id, timestamp)\n\t) PARTITION BY RANGE (timestamp);", 1), nil
+}
+
+// partitionedStore 新建的表使用声明式分区, 提前建好后续分区,
+// 过期数据通过 detach + drop 整个分区清理
+type partitionedStore struct {
+	*pgStore
+	ahead int
+	spans map[string]partitionSpan
+}
+
+func openPartitioned(dsn string, ahead int) (*partitionedStore, error) {
+	s, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &partitionedStore{pgStore: s, ahead: ahead}, nil
+}
+
+func (s *partitionedStore) Init(ctx context.Context, rawTable string) error {
+	spans := partitionSpans(rawTable)
+	s.spans = make(map[string]partitionSpan)
+	plog := component("partition")
+	raw, err := partitioned(tblCoinMarketCap)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(raw, rawTable)); err != nil {
+		return fmt.Errorf("create %v: %v", rawTable, err)
+	}
+	for _, tbl := range candleTables {
+		ddl := tblCoinMarketCapXmin
+		if _, ok := spans[tbl]; ok {
+			var err error
+			if ddl, err = partitioned(ddl); err != nil {
+				return err
+			}
+		}
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf(ddl, tbl, tbl, tbl, tbl, tbl, tbl, tbl)); err != nil {
+			return fmt.Errorf("create %v: %v", tbl, err)
+		}
+	}
+	if err := addCandleColumns(ctx, s.db, candleTables); err != nil {
+		return err
+	}
+	for tbl, span := range spans {
+		var kind string
+		if err := s.db.QueryRowContext(ctx, "SELECT relkind FROM pg_class WHERE oid = $1::regclass;", tbl).Scan(&kind); err != nil {
+			return fmt.Errorf("%v: %v", tbl, err)
+		}
+		// 已存在的普通表不能原地转换, 保持原样
+		if kind != "p" {
+			plog.Warn("table exists without partitioning, leaving it as is", "table", tbl)
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %[1]s_default PARTITION OF %[1]s DEFAULT;", tbl)); err != nil {
+			return fmt.Errorf("default partition %v: %v", tbl, err)
+		}
+		s.spans[tbl] = span
+	}
+	return s.MaintainPartitions(ctx, time.Now())
+}
+
+// MaintainPartitions 建好当前及之后 ahead 个分区
+func (s *partitionedStore) MaintainPartitions(ctx context.Context, now time.Time) error {
+	for tbl, span := range s.spans {
+		start := span.start(now)
+		for i := 0; i <= s.ahead; i++ {
+			end := span.next(start)
+			q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v PARTITION OF %v FOR VALUES FROM (%d) TO (%d);",
+				partitionName(tbl, span, start), tbl, start.Unix(), end.Unix())
+			if _, err := s.db.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("partition %v: %v", partitionName(tbl, span, start), err)
+			}
+			start = end
+		}
+	}
+	return nil
+}
+
+// DropPartitionsBefore 分离并删除结束时间不晚于 ts 的分区
+func (s *partitionedStore) DropPartitionsBefore(ctx context.Context, tbl string, ts int64) (int, error) {
+	span, ok := s.spans[tbl]
+	if !ok {
+		return 0, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass;`, tbl)
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if start, ok := parsePartitionName(tbl, span, name); ok && span.next(start).Unix() <= ts {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, name := range expired {
+		q := fmt.Sprintf("ALTER TABLE %v DETACH PARTITION %v; DROP TABLE %v;", tbl, name, name)
+		if _, err := s.db.ExecContext(ctx, q); err != nil {
+			return i, fmt.Errorf("drop %v: %v", name, err)
+		}
+	}
+	return len(expired), nil
+}
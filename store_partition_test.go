@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionSpan(t *testing.T) {
+	now := time.Date(2018, 12, 31, 15, 4, 5, 0, time.UTC)
+	for _, c := range []struct {
+		span  partitionSpan
+		start time.Time
+		next  time.Time
+		name  string
+	}{
+		{spanDay, time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), "t_p20181231"},
+		{spanMonth, time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), "t_p201812"},
+		{spanYear, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), "t_p2018"},
+	} {
+		start := c.span.start(now)
+		if !start.Equal(c.start) {
+			t.Errorf("span %d: start %v, want %v", c.span, start, c.start)
+		}
+		if next := c.span.next(start); !next.Equal(c.next) {
+			t.Errorf("span %d: next %v, want %v", c.span, next, c.next)
+		}
+		name := partitionName("t", c.span, start)
+		if name != c.name {
+			t.Errorf("span %d: name %v, want %v", c.span, name, c.name)
+		}
+		if got, ok := parsePartitionName("t", c.span, name); !ok || !got.Equal(start) {
+			t.Errorf("parsePartitionName(%v) = %v, %v", name, got, ok)
+		}
+	}
+	for _, name := range []string{"t_default", "other_p20181231", "t_p2018123"} {
+		if _, ok := parsePartitionName("t", spanDay, name); ok {
+			t.Errorf("parsePartitionName(%v): expected no match", name)
+		}
+	}
+}
+
+func TestPartitionedDDL(t *testing.T) {
+	for _, ddl := range []string{tblCoinMarketCap, tblCoinMarketCapXmin} {
+		got, err := partitioned(ddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(got, "id SERIAL PRIMARY KEY") {
+			t.Errorf("primary key on id alone left in:\n%v", got)
+		}
+		if !strings.Contains(got, "PRIMARY KEY (id, timestamp)\n\t) PARTITION BY RANGE (timestamp);") {
+			t.Errorf("missing partition clause in:\n%v", got)
+		}
+	}
+	// 对不上的建表语句报错
+	for _, ddl := range []string{"CREATE TABLE t (id BIGSERIAL PRIMARY KEY, timestamp BIGINT);", strings.Replace(tblCoinMarketCap, "\n\t);", ");", 1)} {
+		if got, err := partitioned(ddl); err == nil {
+			t.Errorf("expected error, got:\n%v", got)
+		}
+	}
+}
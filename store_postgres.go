@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"market/candles"
+
+	"github.com/lib/pq"
+)
+
+// CREATE INDEX IF NOT EXISTS  pg 9.5 才支持
+const tblCoinMarketCapXmin = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		asset_id character varying(32) NOT NULL,  
+		name character varying(32) NOT NULL, 
+		symbol character varying(32) NOT NULL, 
+		rank integer  NOT NULL, 
+		price_usd_first real  NOT NULL, 
+		price_usd_last real NOT NULL, 
+		price_usd_low real  NOT NULL, 
+		price_usd_high  real NOT NULL, 
+		price_btc_first real  NOT NULL, 
+		price_btc_last real  NOT NULL, 
+		price_btc_low real  NOT NULL, 
+		price_btc_high real  NOT NULL, 
+		price_cny_first real  NOT NULL, 
+		price_cny_last real  NOT NULL, 
+		price_cny_low real  NOT NULL, 
+		price_cny_high real  NOT NULL, 
+		last_updated bigint NOT NULL,
+		timestamp bigint NOT NULL,
+        _group character varying(32),
+		stale boolean NOT NULL DEFAULT false,
+		volume_base double precision NOT NULL DEFAULT 0,
+		volume_quote double precision NOT NULL DEFAULT 0,
+		trades bigint NOT NULL DEFAULT 0,
+		vwap double precision NOT NULL DEFAULT 0
+	); 
+
+   CREATE INDEX IF NOT EXISTS index_timestamp_%s ON %s (timestamp);
+   CREATE INDEX IF NOT EXISTS index_last_updated_%s ON %s (last_updated);
+   CREATE INDEX IF NOT EXISTS index_symbol_%s ON %s USING hash (symbol);
+`
+
+const tblCoinMarketCap = `
+	CREATE TABLE IF NOT EXISTS %[1]s (
+		id SERIAL PRIMARY KEY,
+		asset_id  character varying(32) NOT NULL,  
+		name character varying(32) NOT NULL,
+		symbol character varying(32) NOT NULL,
+		rank character varying(32) NOT NULL,
+		price_usd character varying(64) NOT NULL,
+		price_btc character varying(64) NOT NULL,
+		volume_usd_24h character varying(64) NOT NULL,
+		market_cap_usd character varying(64) NOT NULL,
+		available_supply character varying(64) NOT NULL,
+		total_supply character varying(64) NOT NULL,
+		percent_change_1h character varying(64) NOT NULL,
+		percent_change_24h character varying(64) NOT NULL,
+		percent_change_7d character varying(64) NOT NULL,
+		last_updated character varying(64) NOT NULL,
+		price_cny character varying(64) NOT NULL,
+		volume_cny_24h character varying(64) NOT NULL,
+		market_cap_cny character varying(64) NOT NULL,
+		timestamp bigint NOT NULL DEFAULT 0
+	);
+
+   ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS timestamp bigint NOT NULL DEFAULT 0;
+   CREATE INDEX IF NOT EXISTS index_timestamp_%[1]s ON %[1]s (timestamp);
+   -- 加列之前的数据用 last_updated 补上, 否则会被保留策略当成 1970 年的数据删掉
+   UPDATE %[1]s SET timestamp = last_updated::bigint WHERE timestamp = 0 AND last_updated ~ '^[0-9]+$';
+`
+
+const tblIndicators = `
+	CREATE TABLE IF NOT EXISTS indicators (
+		asset_id character varying(32) NOT NULL,
+		symbol character varying(32) NOT NULL,
+		timeframe character varying(8) NOT NULL,
+		indicator character varying(32) NOT NULL,
+		field character varying(16) NOT NULL,
+		timestamp bigint NOT NULL,
+		value double precision NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_asset_indicators ON indicators (asset_id, timeframe, indicator, timestamp);
+	CREATE INDEX IF NOT EXISTS index_timestamp_indicators ON indicators (timestamp);
+`
+
+const tblConverted = `
+	CREATE TABLE IF NOT EXISTS fx_rates (
+		currency character varying(8) NOT NULL,
+		timestamp bigint NOT NULL,
+		rate double precision NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_currency_fx_rates ON fx_rates (currency, timestamp);
+
+	CREATE TABLE IF NOT EXISTS converted_candles (
+		asset_id character varying(32) NOT NULL,
+		symbol character varying(32) NOT NULL,
+		timeframe character varying(8) NOT NULL,
+		quote character varying(32) NOT NULL,
+		timestamp bigint NOT NULL,
+		open double precision NOT NULL,
+		high double precision NOT NULL,
+		low double precision NOT NULL,
+		close double precision NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_asset_converted_candles ON converted_candles (asset_id, timeframe, quote, timestamp);
+	CREATE INDEX IF NOT EXISTS index_timestamp_converted_candles ON converted_candles (timestamp);
+`
+
+const tblRejectedTicks = `
+	CREATE TABLE IF NOT EXISTS rejected_ticks (
+		id SERIAL PRIMARY KEY,
+		source character varying(32) NOT NULL,
+		asset_id character varying(32) NOT NULL,
+		symbol character varying(32) NOT NULL,
+		price_usd character varying(64) NOT NULL,
+		median double precision NOT NULL,
+		deviation double precision NOT NULL,
+		reason text NOT NULL,
+		status character varying(16) NOT NULL,
+		timestamp bigint NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_timestamp_rejected_ticks ON rejected_ticks (timestamp);
+`
+
+// pgStore 默认的 Postgres 实现, 批量写入走 COPY
+type pgStore struct {
+	db *sql.DB
+}
+
+func openPostgres(dsn string) (*pgStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &pgStore{db: db}, nil
+}
+
+func (s *pgStore) Init(ctx context.Context, rawTable string) error {
+	if err := createTable(s.db, fmt.Sprintf(tblCoinMarketCap, rawTable)); err != nil {
+		return err
+	}
+	for _, tbl := range candleTables {
+		if err := createTable(s.db, fmt.Sprintf(tblCoinMarketCapXmin, tbl, tbl, tbl, tbl, tbl, tbl, tbl)); err != nil {
+			return err
+		}
+	}
+	return addCandleColumns(ctx, s.db, candleTables)
+}
+
+// candleColumnsAdded 周期表建好之后新增的列
+var candleColumnsAdded = []string{
+	"stale boolean NOT NULL DEFAULT false",
+	"volume_base double precision NOT NULL DEFAULT 0",
+	"volume_quote double precision NOT NULL DEFAULT 0",
+	"trades bigint NOT NULL DEFAULT 0",
+	"vwap double precision NOT NULL DEFAULT 0",
+}
+
+// addCandleColumns 给旧版本建的周期表补上新增的列
+func addCandleColumns(ctx context.Context, db *sql.DB, tables []string) error {
+	for _, tbl := range tables {
+		for _, col := range candleColumnsAdded {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %v ADD COLUMN IF NOT EXISTS %v;", tbl, col)); err != nil {
+				return fmt.Errorf("alter %v: %v", tbl, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *pgStore) InsertTicks(ctx context.Context, tbl string, ticks ...*rawTicks) error {
+	return insert(ctx, s.db, tbl, ticks...)
+}
+
+// DeleteBefore 分区表的 ctid 只在分区内唯一, 所以带上 tableoid
+func (s *pgStore) DeleteBefore(ctx context.Context, tbl string, ts int64, batch int) (int64, error) {
+	if batch <= 0 {
+		return deleteBatches(ctx, s.db, fmt.Sprintf("DELETE FROM %v WHERE timestamp < $1;", tbl), ts, 0)
+	}
+	q := fmt.Sprintf("DELETE FROM %[1]v WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM %[1]v WHERE timestamp < $1 LIMIT $2);", tbl)
+	return deleteBatches(ctx, s.db, q, ts, batch)
+}
+
+func (s *pgStore) SaveCandles(ctx context.Context, tbl, group string, dats ...*kPriceCoinMarketCapList) error {
+	return saveKPriceCoinMarketCap(ctx, s.db, tbl, group, dats...)
+}
+
+func (s *pgStore) ReplaceCurrent(ctx context.Context, group string, dat *kPriceCoinMarketCapList) error {
+	return upsertCoinMarketCapCurrent(ctx, s.db, group, dat)
+}
+
+func (s *pgStore) LatestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	var latest sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("select max(timestamp) from %v;", tbl)).Scan(&latest); err != nil {
+		return 0, false, err
+	}
+	return latest.Int64, latest.Valid, nil
+}
+
+// UpsertCandles 表上没有唯一约束, 先删除同键的行再 COPY
+func (s *pgStore) UpsertCandles(ctx context.Context, tbl string, rows ...*candleRow) error {
+	rows = dedupeCandles(rows)
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE asset_id = $1 AND timestamp = $2 AND _group = $3;", tbl))
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timestamp, r.Group); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+		}
+		stmt, err := txn.Prepare(pq.CopyIn(tbl, candleColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.Values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+func (s *pgStore) InitIndicators(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, tblIndicators)
+	return err
+}
+
+func (s *pgStore) SaveIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn("indicators", indicatorColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+// UpsertIndicators 和 UpsertCandles 一样先删除同键的行再 COPY
+func (s *pgStore) UpsertIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, "DELETE FROM indicators WHERE asset_id = $1 AND timeframe = $2 AND indicator = $3 AND field = $4 AND timestamp = $5;")
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timeframe, r.Indicator, r.Field, r.Timestamp); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+		}
+		stmt, err := txn.Prepare(pq.CopyIn("indicators", indicatorColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+func (s *pgStore) InitConverted(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, tblConverted)
+	return err
+}
+
+func (s *pgStore) SaveFXRates(ctx context.Context, rates ...fxRate) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn("fx_rates", fxRateColumns...))
+		if err != nil {
+			return err
+		}
+		for i := range rates {
+			if _, err := stmt.Exec(rates[i].values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", rates[i].Currency, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+func (s *pgStore) SaveConverted(ctx context.Context, rows ...*convertedRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn("converted_candles", convertedColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+// UpsertConverted 和 UpsertCandles 一样先删除同键的行再 COPY
+func (s *pgStore) UpsertConverted(ctx context.Context, rows ...*convertedRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, "DELETE FROM converted_candles WHERE asset_id = $1 AND timeframe = $2 AND quote = $3 AND timestamp = $4;")
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timeframe, r.Quote, r.Timestamp); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+		}
+		stmt, err := txn.Prepare(pq.CopyIn("converted_candles", convertedColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+func (s *pgStore) InitRejected(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, tblRejectedTicks)
+	return err
+}
+
+func (s *pgStore) SaveRejected(ctx context.Context, ticks ...*rejectedTick) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn("rejected_ticks", rejectedColumns...))
+		if err != nil {
+			return err
+		}
+		for _, r := range ticks {
+			if _, err := stmt.Exec(r.values()...); err != nil {
+				return fmt.Errorf("copy %v: %v", r.Symbol, err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+}
+
+func (s *pgStore) ScanTicks(ctx context.Context, tbl string, from, to int64, fn func(*rawTicks) error) error {
+	q := fmt.Sprintf("SELECT %v FROM %v WHERE timestamp >= $1 AND timestamp < $2 ORDER BY timestamp, id;", strings.Join(rawColumns, ", "), tbl)
+	return scanTicks(ctx, s.db, q, from, to, fn)
+}
+
+func (s *pgStore) EarliestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	var earliest sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("select min(timestamp) from %v where timestamp > 0;", tbl)).Scan(&earliest); err != nil {
+		return 0, false, err
+	}
+	return earliest.Int64, earliest.Valid, nil
+}
+
+func (s *pgStore) ScanCandles(ctx context.Context, q candleQuery, fn func(*candleRow) error) error {
+	return candles.Scan(ctx, s.db, q, candles.Dollar, fn)
+}
+
+func (s *pgStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *pgStore) Close() error {
+	return s.db.Close()
+}
+
+// createTable 语句都带 IF NOT EXISTS, 只忽略并发建表时的已存在错误
+func createTable(db *sql.DB, sql string) error {
+	_, err := db.Exec(sql)
+	if alreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// alreadyExists duplicate_table, duplicate_object, 以及并发 CREATE 在系统表上的 unique_violation
+func alreadyExists(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "42P07", "42710", "23505":
+		return true
+	}
+	return false
+}
+
+func tx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+func upsertCoinMarketCapCurrent(ctx context.Context, db *sql.DB, tblname string, dat *kPriceCoinMarketCapList) error {
+	return tx(ctx, db, func(txn *sql.Tx) error {
+		if _, err := txn.Exec(fmt.Sprintf("delete from %v where _group = $1 ;", coinmarketcapcurrent), tblname); err != nil {
+			return err
+		}
+		return copyCandles(txn, coinmarketcapcurrent, tblname, dat)
+	})
+}
+
+func saveKPriceCoinMarketCap(ctx context.Context, db *sql.DB, tblname, group string, dats ...*kPriceCoinMarketCapList) error {
+	return tx(ctx, db, func(txn *sql.Tx) error {
+		return copyCandles(txn, tblname, group, dats...)
+	})
+}
+
+// copyCandles 按 candleColumns 用 COPY 写入
+func copyCandles(txn *sql.Tx, tblname, group string, dats ...*kPriceCoinMarketCapList) error {
+	stmt, err := txn.Prepare(pq.CopyIn(tblname, candleColumns...))
+	if err != nil {
+		return err
+	}
+	for _, dat := range dats {
+		for i := range dat.list {
+			if _, err := stmt.Exec(candleArgs(&dat.list[i], dat.timestamp, group)...); err != nil {
+				stmt.Close()
+				return fmt.Errorf("copy %v: %v", dat.list[i].Symbol, err)
+			}
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+// insert 按 rawColumns 用 COPY 写入
+func insert(ctx context.Context, db *sql.DB, tblname string, ticks ...*rawTicks) error {
+	return tx(ctx, db, func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn(tblname, rawColumns...))
+		if err != nil {
+			return err
+		}
+		for _, t := range ticks {
+			for i := range t.List {
+				if _, err := stmt.Exec(rawArgs(&t.List[i], t.Timestamp)...); err != nil {
+					stmt.Close()
+					return fmt.Errorf("copy %v: %v", t.List[i].Symbol, err)
+				}
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			stmt.Close()
+			return err
+		}
+		return stmt.Close()
+	})
+}
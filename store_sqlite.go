@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"market/candles"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteCoinMarketCapXmin = `
+	CREATE TABLE IF NOT EXISTS %[1]s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		asset_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		rank INTEGER NOT NULL,
+		price_usd_first REAL NOT NULL,
+		price_usd_last REAL NOT NULL,
+		price_usd_low REAL NOT NULL,
+		price_usd_high REAL NOT NULL,
+		price_btc_first REAL NOT NULL,
+		price_btc_last REAL NOT NULL,
+		price_btc_low REAL NOT NULL,
+		price_btc_high REAL NOT NULL,
+		price_cny_first REAL NOT NULL,
+		price_cny_last REAL NOT NULL,
+		price_cny_low REAL NOT NULL,
+		price_cny_high REAL NOT NULL,
+		last_updated INTEGER NOT NULL,
+		timestamp INTEGER NOT NULL,
+		_group TEXT,
+		stale INTEGER NOT NULL DEFAULT 0,
+		volume_base REAL NOT NULL DEFAULT 0,
+		volume_quote REAL NOT NULL DEFAULT 0,
+		trades INTEGER NOT NULL DEFAULT 0,
+		vwap REAL NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS index_timestamp_%[1]s ON %[1]s (timestamp);
+	CREATE INDEX IF NOT EXISTS index_last_updated_%[1]s ON %[1]s (last_updated);
+	CREATE INDEX IF NOT EXISTS index_symbol_%[1]s ON %[1]s (symbol);
+	CREATE INDEX IF NOT EXISTS index_group_%[1]s ON %[1]s (_group);
+`
+
+// sqliteCandleColumnsAdded 同 candleColumnsAdded
+var sqliteCandleColumnsAdded = []string{
+	"stale INTEGER NOT NULL DEFAULT 0",
+	"volume_base REAL NOT NULL DEFAULT 0",
+	"volume_quote REAL NOT NULL DEFAULT 0",
+	"trades INTEGER NOT NULL DEFAULT 0",
+	"vwap REAL NOT NULL DEFAULT 0",
+}
+
+const sqliteCoinMarketCap = `
+	CREATE TABLE IF NOT EXISTS %[1]s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		asset_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		rank TEXT NOT NULL,
+		price_usd TEXT NOT NULL,
+		price_btc TEXT NOT NULL,
+		volume_usd_24h TEXT NOT NULL,
+		market_cap_usd TEXT NOT NULL,
+		available_supply TEXT NOT NULL,
+		total_supply TEXT NOT NULL,
+		percent_change_1h TEXT NOT NULL,
+		percent_change_24h TEXT NOT NULL,
+		percent_change_7d TEXT NOT NULL,
+		last_updated TEXT NOT NULL,
+		price_cny TEXT NOT NULL,
+		volume_cny_24h TEXT NOT NULL,
+		market_cap_cny TEXT NOT NULL,
+		timestamp INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS index_timestamp_%[1]s ON %[1]s (timestamp);
+`
+
+const sqliteConverted = `
+	CREATE TABLE IF NOT EXISTS fx_rates (
+		currency TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		rate REAL NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_currency_fx_rates ON fx_rates (currency, timestamp);
+
+	CREATE TABLE IF NOT EXISTS converted_candles (
+		asset_id TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		timeframe TEXT NOT NULL,
+		quote TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		open REAL NOT NULL,
+		high REAL NOT NULL,
+		low REAL NOT NULL,
+		close REAL NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_asset_converted_candles ON converted_candles (asset_id, timeframe, quote, timestamp);
+	CREATE INDEX IF NOT EXISTS index_timestamp_converted_candles ON converted_candles (timestamp);
+`
+
+const sqliteIndicators = `
+	CREATE TABLE IF NOT EXISTS indicators (
+		asset_id TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		timeframe TEXT NOT NULL,
+		indicator TEXT NOT NULL,
+		field TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		value REAL NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_asset_indicators ON indicators (asset_id, timeframe, indicator, timestamp);
+	CREATE INDEX IF NOT EXISTS index_timestamp_indicators ON indicators (timestamp);
+`
+
+const sqliteRejectedTicks = `
+	CREATE TABLE IF NOT EXISTS rejected_ticks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL,
+		asset_id TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		price_usd TEXT NOT NULL,
+		median REAL NOT NULL,
+		deviation REAL NOT NULL,
+		reason TEXT NOT NULL,
+		status TEXT NOT NULL,
+		timestamp INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS index_timestamp_rejected_ticks ON rejected_ticks (timestamp);
+`
+
+// sqliteStore 单文件存储, 用于本地开发和测试, 不依赖外部服务
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLite(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, err
+	}
+	// sqlite 同时只能有一个写者
+	db.SetMaxOpenConns(1)
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Init(ctx context.Context, rawTable string) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteCoinMarketCap, rawTable)); err != nil {
+		return err
+	}
+	for _, tbl := range candleTables {
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteCoinMarketCapXmin, tbl)); err != nil {
+			return err
+		}
+		// sqlite 没有 ADD COLUMN IF NOT EXISTS, 旧表补列时忽略已存在的错误
+		for _, col := range sqliteCandleColumnsAdded {
+			_, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v;", tbl, col))
+			if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func insertStmt(tbl string, columns []string) string {
+	return fmt.Sprintf("insert into %v (%v) values (%v);", tbl, strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+}
+
+func (s *sqliteStore) InsertTicks(ctx context.Context, tbl string, ticks ...*rawTicks) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.PrepareContext(ctx, insertStmt(tbl, rawColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, t := range ticks {
+			for i := range t.List {
+				if _, err := stmt.ExecContext(ctx, rawArgs(&t.List[i], t.Timestamp)...); err != nil {
+					return fmt.Errorf("insert %v: %v", t.List[i].Symbol, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) DeleteBefore(ctx context.Context, tbl string, ts int64, batch int) (int64, error) {
+	if batch <= 0 {
+		return deleteBatches(ctx, s.db, fmt.Sprintf("DELETE FROM %v WHERE timestamp < ?;", tbl), ts, 0)
+	}
+	q := fmt.Sprintf("DELETE FROM %[1]v WHERE rowid IN (SELECT rowid FROM %[1]v WHERE timestamp < ? LIMIT ?);", tbl)
+	return deleteBatches(ctx, s.db, q, ts, batch)
+}
+
+func saveCandles(ctx context.Context, txn *sql.Tx, tbl, group string, dats ...*kPriceCoinMarketCapList) error {
+	stmt, err := txn.PrepareContext(ctx, insertStmt(tbl, candleColumns))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, dat := range dats {
+		for i := range dat.list {
+			if _, err := stmt.ExecContext(ctx, candleArgs(&dat.list[i], dat.timestamp, group)...); err != nil {
+				return fmt.Errorf("insert %v: %v", dat.list[i].Symbol, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveCandles(ctx context.Context, tbl, group string, dats ...*kPriceCoinMarketCapList) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		return saveCandles(ctx, txn, tbl, group, dats...)
+	})
+}
+
+func (s *sqliteStore) ReplaceCurrent(ctx context.Context, group string, dat *kPriceCoinMarketCapList) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		if _, err := txn.ExecContext(ctx, fmt.Sprintf("delete from %v where _group = ?;", coinmarketcapcurrent), group); err != nil {
+			return err
+		}
+		return saveCandles(ctx, txn, coinmarketcapcurrent, group, dat)
+	})
+}
+
+func (s *sqliteStore) UpsertCandles(ctx context.Context, tbl string, rows ...*candleRow) error {
+	rows = dedupeCandles(rows)
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, fmt.Sprintf("delete from %v where asset_id = ? and timestamp = ? and _group = ?;", tbl))
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		stmt, err := txn.PrepareContext(ctx, insertStmt(tbl, candleColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timestamp, r.Group); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+			if _, err := stmt.ExecContext(ctx, r.Values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) InitIndicators(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteIndicators)
+	return err
+}
+
+func (s *sqliteStore) SaveIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.PrepareContext(ctx, insertStmt("indicators", indicatorColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := stmt.ExecContext(ctx, r.values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) UpsertIndicators(ctx context.Context, rows ...*indicatorRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, "delete from indicators where asset_id = ? and timeframe = ? and indicator = ? and field = ? and timestamp = ?;")
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		stmt, err := txn.PrepareContext(ctx, insertStmt("indicators", indicatorColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timeframe, r.Indicator, r.Field, r.Timestamp); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+			if _, err := stmt.ExecContext(ctx, r.values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) InitConverted(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteConverted)
+	return err
+}
+
+func (s *sqliteStore) SaveFXRates(ctx context.Context, rates ...fxRate) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.PrepareContext(ctx, insertStmt("fx_rates", fxRateColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i := range rates {
+			if _, err := stmt.ExecContext(ctx, rates[i].values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", rates[i].Currency, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) SaveConverted(ctx context.Context, rows ...*convertedRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.PrepareContext(ctx, insertStmt("converted_candles", convertedColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := stmt.ExecContext(ctx, r.values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) UpsertConverted(ctx context.Context, rows ...*convertedRow) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		del, err := txn.PrepareContext(ctx, "delete from converted_candles where asset_id = ? and timeframe = ? and quote = ? and timestamp = ?;")
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		stmt, err := txn.PrepareContext(ctx, insertStmt("converted_candles", convertedColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := del.ExecContext(ctx, r.AssetID, r.Timeframe, r.Quote, r.Timestamp); err != nil {
+				return fmt.Errorf("delete %v: %v", r.Symbol, err)
+			}
+			if _, err := stmt.ExecContext(ctx, r.values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) InitRejected(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteRejectedTicks)
+	return err
+}
+
+func (s *sqliteStore) SaveRejected(ctx context.Context, ticks ...*rejectedTick) error {
+	return tx(ctx, s.db, func(txn *sql.Tx) error {
+		stmt, err := txn.PrepareContext(ctx, insertStmt("rejected_ticks", rejectedColumns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range ticks {
+			if _, err := stmt.ExecContext(ctx, r.values()...); err != nil {
+				return fmt.Errorf("insert %v: %v", r.Symbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) LatestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	var latest sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("select max(timestamp) from %v;", tbl)).Scan(&latest); err != nil {
+		return 0, false, err
+	}
+	return latest.Int64, latest.Valid, nil
+}
+
+func (s *sqliteStore) ScanTicks(ctx context.Context, tbl string, from, to int64, fn func(*rawTicks) error) error {
+	q := fmt.Sprintf("SELECT %v FROM %v WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp, id;", strings.Join(rawColumns, ", "), tbl)
+	return scanTicks(ctx, s.db, q, from, to, fn)
+}
+
+func (s *sqliteStore) EarliestTimestamp(ctx context.Context, tbl string) (int64, bool, error) {
+	var earliest sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("select min(timestamp) from %v where timestamp > 0;", tbl)).Scan(&earliest); err != nil {
+		return 0, false, err
+	}
+	return earliest.Int64, earliest.Valid, nil
+}
+
+func (s *sqliteStore) ScanCandles(ctx context.Context, q candleQuery, fn func(*candleRow) error) error {
+	return candles.Scan(ctx, s.db, q, candles.Question, fn)
+}
+
+func (s *sqliteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 整型时间列的 hypertable 需要 integer_now 函数才能使用策略
+const tsUnixNow = `
+	CREATE OR REPLACE FUNCTION unix_now() RETURNS bigint
+	LANGUAGE SQL STABLE AS $$ SELECT extract(epoch from now())::bigint $$;
+`
+
+const tsHypertable = `
+	ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[1]s_pkey;
+	SELECT create_hypertable('%[1]s', 'timestamp', chunk_time_interval => %[2]d, if_not_exists => TRUE, migrate_data => TRUE);
+	SELECT set_integer_now_func('%[1]s', 'unix_now', replace_if_exists => TRUE);
+`
+
+const tsCompression = `
+	ALTER TABLE %[1]s SET (timescaledb.compress, timescaledb.compress_segmentby = 'symbol', timescaledb.compress_orderby = 'timestamp DESC');
+	SELECT add_compression_policy('%[1]s', compress_after => %[2]d::bigint, if_not_exists => TRUE);
+`
+
+// 由一分钟数据汇总, 和 aggregation 的计算方式一致
+const tsContinuousAggregate = `
+	CREATE MATERIALIZED VIEW IF NOT EXISTS %[1]s
+	WITH (timescaledb.continuous) AS
+	SELECT time_bucket(%[2]d, timestamp, %[3]d) AS timestamp,
+		asset_id,
+		symbol,
+		_group,
+		last(name, timestamp) AS name,
+		last(rank, timestamp) AS rank,
+		first(price_usd_first, timestamp) AS price_usd_first,
+		last(price_usd_last, timestamp) AS price_usd_last,
+		min(price_usd_low) AS price_usd_low,
+		max(price_usd_high) AS price_usd_high,
+		first(price_btc_first, timestamp) AS price_btc_first,
+		last(price_btc_last, timestamp) AS price_btc_last,
+		min(price_btc_low) AS price_btc_low,
+		max(price_btc_high) AS price_btc_high,
+		first(price_cny_first, timestamp) AS price_cny_first,
+		last(price_cny_last, timestamp) AS price_cny_last,
+		min(price_cny_low) AS price_cny_low,
+		max(price_cny_high) AS price_cny_high,
+		max(last_updated) AS last_updated,
+		bool_and(stale) AS stale,
+		sum(volume_base) AS volume_base,
+		sum(volume_quote) AS volume_quote,
+		sum(trades) AS trades,
+		CASE WHEN sum(volume_base) > 0 THEN sum(volume_quote) / sum(volume_base) ELSE 0 END AS vwap
+	FROM %[4]s
+	GROUP BY 1, asset_id, symbol, _group
+	WITH NO DATA;
+
+	SELECT add_continuous_aggregate_policy('%[1]s', start_offset => %[5]d::bigint, end_offset => %[2]d::bigint, schedule_interval => INTERVAL '%[6]d seconds', if_not_exists => TRUE);
+`
+
+// 汇总表的周期, 周线从星期一开始 (1970-01-01 是星期四)
+var rollups = []struct {
+	tbl    string
+	width  int64
+	offset int64
+}{
+	{coinmarketcap5min, min5, 0},
+	{coinmarketcap10min, min10, 0},
+	{coinmarketcap15min, min15, 0},
+	{coinmarketcap30min, min30, 0},
+	{coinmarketcaphour, hour, 0},
+	{coinmarketcapday, day, 0},
+	{coinmarketcapweek, week, 4 * day},
+}
+
+// timescaleStore 原始表和周期表建成 hypertable 并开启压缩,
+// continuousAggs 时汇总表改为 continuous aggregate, 不再由 aggregation 写入
+type timescaleStore struct {
+	*pgStore
+	compressAfter  time.Duration
+	continuousAggs map[string]bool
+}
+
+func openTimescale(dsn string, compressAfter time.Duration, continuousAggs bool) (*timescaleStore, error) {
+	pg, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &timescaleStore{
+		pgStore:        pg,
+		compressAfter:  compressAfter,
+		continuousAggs: make(map[string]bool),
+	}
+	if continuousAggs {
+		for _, r := range rollups {
+			s.continuousAggs[r.tbl] = true
+		}
+	}
+	return s, nil
+}
+
+func chunkInterval(tbl string) int64 {
+	switch tbl {
+	case coinmarketcapmin, coinmarketcap5min, coinmarketcap10min:
+		return week
+	case coinmarketcap15min, coinmarketcap30min, coinmarketcaphour:
+		return 4 * week
+	case coinmarketcapday, coinmarketcapweek:
+		return 52 * week
+	}
+	// 原始表数据量最大, 按天分块
+	return day
+}
+
+func (s *timescaleStore) Init(ctx context.Context, rawTable string) error {
+	for _, q := range []string{"CREATE EXTENSION IF NOT EXISTS timescaledb;", tsUnixNow} {
+		if _, err := s.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	// hypertable 的唯一约束必须包含时间列, 去掉 id 主键
+	raw := strings.Replace(tblCoinMarketCap, "id SERIAL PRIMARY KEY", "id SERIAL", 1)
+	xmin := strings.Replace(tblCoinMarketCapXmin, "id SERIAL PRIMARY KEY", "id SERIAL", 1)
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(raw, rawTable)); err != nil {
+		return err
+	}
+	if err := s.hypertable(ctx, rawTable); err != nil {
+		return err
+	}
+	for _, tbl := range candleTables {
+		if s.continuousAggs[tbl] {
+			continue
+		}
+		if tbl == coinmarketcapcurrent {
+			// 快照表只保留最新数据, 保持普通表
+			if err := createTable(s.db, fmt.Sprintf(tblCoinMarketCapXmin, tbl, tbl, tbl, tbl, tbl, tbl, tbl)); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf(xmin, tbl, tbl, tbl, tbl, tbl, tbl, tbl)); err != nil {
+			return err
+		}
+		if err := s.hypertable(ctx, tbl); err != nil {
+			return err
+		}
+	}
+	// continuous aggregate 是视图, 只给普通表补列
+	var plain []string
+	for _, tbl := range candleTables {
+		if !s.continuousAggs[tbl] {
+			plain = append(plain, tbl)
+		}
+	}
+	if err := addCandleColumns(ctx, s.db, plain); err != nil {
+		return err
+	}
+	for _, r := range rollups {
+		if !s.continuousAggs[r.tbl] {
+			continue
+		}
+		q := fmt.Sprintf(tsContinuousAggregate, r.tbl, r.width, r.offset, coinmarketcapmin, 3*r.width, scheduleInterval(r.width))
+		if _, err := s.db.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("continuous aggregate %v: %v", r.tbl, err)
+		}
+	}
+	return nil
+}
+
+func (s *timescaleStore) hypertable(ctx context.Context, tbl string) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(tsHypertable, tbl, chunkInterval(tbl))); err != nil {
+		return fmt.Errorf("hypertable %v: %v", tbl, err)
+	}
+	if s.compressAfter <= 0 {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(tsCompression, tbl, int64(s.compressAfter/time.Second))); err != nil {
+		return fmt.Errorf("compression %v: %v", tbl, err)
+	}
+	return nil
+}
+
+// scheduleInterval 刷新频率, 不超过一小时
+func scheduleInterval(width int64) int64 {
+	if width > hour {
+		return hour
+	}
+	return width
+}
+
+func (s *timescaleStore) SaveCandles(ctx context.Context, tbl, group string, dats ...*kPriceCoinMarketCapList) error {
+	if s.continuousAggs[tbl] {
+		return nil
+	}
+	return s.pgStore.SaveCandles(ctx, tbl, group, dats...)
+}
+
+// UpsertCandles 和 SaveCandles 一样跳过连续聚合, 修正的分钟数据由刷新策略带进聚合
+func (s *timescaleStore) UpsertCandles(ctx context.Context, tbl string, rows ...*candleRow) error {
+	if s.continuousAggs[tbl] {
+		return nil
+	}
+	return s.pgStore.UpsertCandles(ctx, tbl, rows...)
+}
+
+// DropPartitionsBefore 删除整块过期的 chunk
+func (s *timescaleStore) DropPartitionsBefore(ctx context.Context, tbl string, ts int64) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM drop_chunks('%v', older_than => $1::bigint);", tbl), ts).Scan(&n)
+	return n, err
+}
+
+// DeleteBefore continuous aggregate 不能 DELETE, 只能删 chunk
+func (s *timescaleStore) DeleteBefore(ctx context.Context, tbl string, ts int64, batch int) (int64, error) {
+	if s.continuousAggs[tbl] {
+		_, err := s.DropPartitionsBefore(ctx, tbl, ts)
+		return 0, err
+	}
+	return s.pgStore.DeleteBefore(ctx, tbl, ts, batch)
+}
@@ -0,0 +1,40 @@
+#!/usr/bin/env python3
+"""用 pyarrow 读回 TestParquetFixture 生成的文件, 和 candles.json 逐行核对.
+
+    pip install pyarrow
+    python3 testdata/check_parquet.py
+"""
+import datetime
+import json
+import os
+import sys
+
+import pyarrow.parquet as pq
+
+here = os.path.dirname(os.path.abspath(__file__))
+with open(os.path.join(here, "candles.json")) as f:
+    want = json.load(f)
+
+ok = True
+for name, iso in (("candles.parquet", False), ("candles_iso.parquet", True)):
+    table = pq.read_table(os.path.join(here, name))
+    if table.column_names != want["columns"]:
+        print(f"{name}: columns {table.column_names}")
+        ok = False
+        continue
+    for col in ("last_updated", "timestamp"):
+        typ = str(table.schema.field(col).type)
+        if not (typ.startswith("timestamp[ms") if iso else typ == "int64"):
+            print(f"{name}: {col} has type {typ}")
+            ok = False
+    rows = [list(r.values()) for r in table.to_pylist()]
+    for i, r in enumerate(rows):
+        r = [int(v.replace(tzinfo=datetime.timezone.utc).timestamp()) if isinstance(v, datetime.datetime) else v for v in r]
+        if i >= len(want["rows"]) or r != want["rows"][i]:
+            print(f"{name} row {i}: {r}")
+            ok = False
+    if len(rows) != len(want["rows"]):
+        print(f"{name}: {len(rows)} rows, want {len(want['rows'])}")
+        ok = False
+    print(f"{name}: {'ok' if ok else 'FAILED'}")
+sys.exit(0 if ok else 1)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// 过滤结果
+const (
+	filterRejected    = "rejected"
+	filterQuarantined = "quarantined"
+	filterReleased    = "released"
+)
+
+// rejectedTick 被过滤的一条数据, Released 表示隔离的新价格得到确认后放行
+type rejectedTick struct {
+	Source    string  `json:"source"`
+	AssetID   string  `json:"asset_id"`
+	Symbol    string  `json:"symbol"`
+	PriceUSD  string  `json:"price_usd"`
+	Median    float64 `json:"median"`
+	Deviation float64 `json:"deviation"`
+	Reason    string  `json:"reason"`
+	Status    string  `json:"status"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+var rejectedColumns = []string{"source", "asset_id", "symbol", "price_usd", "median", "deviation", "reason", "status", "timestamp"}
+
+func (r *rejectedTick) values() []interface{} {
+	return []interface{}{r.Source, r.AssetID, r.Symbol, r.PriceUSD, r.Median, r.Deviation, r.Reason, r.Status, r.Timestamp}
+}
+
+// RejectStore 保存被过滤的数据
+type RejectStore interface {
+	InitRejected(ctx context.Context) error
+	SaveRejected(ctx context.Context, ticks ...*rejectedTick) error
+}
+
+type filterMode int
+
+const (
+	// filterReject 偏离的数据直接丢弃. 连续 window 次偏离时认为价格确实变了,
+	// 清空窗口并放行这一次, 否则真实的大幅变动之后会一直丢弃
+	filterReject filterMode = iota
+	// filterQuarantine 偏离的数据先隔离, 连续 confirm 次都在同一水平时认为价格确实变了
+	filterQuarantine
+)
+
+func parseFilterMode(s string) (filterMode, error) {
+	switch s {
+	case "reject":
+		return filterReject, nil
+	case "quarantine":
+		return filterQuarantine, nil
+	}
+	return 0, fmt.Errorf("unknown filter mode %q", s)
+}
+
+// tickFilter 在数据进入 dispatch 之前, 按每个资产最近 window 个正常价格 (USD) 的中位数
+// 过滤偏离超过 deviation 的数据, 价格不是正数的直接丢弃.
+// 只在抓取的 goroutine 中使用
+type tickFilter struct {
+	window    int
+	deviation float64
+	mode      filterMode
+	confirm   int
+	// record 为空时只计数
+	record func(*rejectedTick)
+
+	prices map[string][]float64
+	held   map[string][]float64
+	// rejects reject 模式下每个资产连续偏离的次数
+	rejects map[string]int
+	log     *slog.Logger
+}
+
+func newTickFilter(window int, deviation float64, mode filterMode, confirm int) *tickFilter {
+	if confirm < 1 {
+		confirm = 1
+	}
+	return &tickFilter{
+		window:    window,
+		deviation: deviation,
+		mode:      mode,
+		confirm:   confirm,
+		prices:    make(map[string][]float64),
+		held:      make(map[string][]float64),
+		rejects:   make(map[string]int),
+		log:       component("filter"),
+	}
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+func validPrice(s string) (float64, bool) {
+	p, err := strconv.ParseFloat(s, 64)
+	return p, err == nil && p > 0 && !math.IsInf(p, 0)
+}
+
+// apply 返回通过的数据, 不修改 list
+func (f *tickFilter) apply(source string, ts int64, list []priceCoinMarketCap) []priceCoinMarketCap {
+	if f == nil {
+		return list
+	}
+	ret := make([]priceCoinMarketCap, 0, len(list))
+	for _, v := range list {
+		if f.check(source, ts, &v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (f *tickFilter) check(source string, ts int64, v *priceCoinMarketCap) bool {
+	r := &rejectedTick{Source: source, AssetID: v.Id, Symbol: v.Symbol, PriceUSD: v.PriceUSD, Timestamp: ts}
+	p, ok := validPrice(v.PriceUSD)
+	_, btc := validPrice(v.PriceBTC)
+	_, cny := validPrice(v.PriceCNY)
+	if !ok || !btc || !cny {
+		r.Reason, r.Status = "invalid price", filterRejected
+		f.reject(r)
+		return false
+	}
+	window := f.prices[v.Id]
+	// 数据太少时中位数没有意义, 先全部接受
+	if len(window) < 3 && len(window) < f.window {
+		f.accept(v.Id, p)
+		return true
+	}
+	r.Median = median(window)
+	r.Deviation = math.Abs(p/r.Median - 1)
+	if r.Deviation <= f.deviation {
+		delete(f.held, v.Id)
+		delete(f.rejects, v.Id)
+		f.accept(v.Id, p)
+		return true
+	}
+	r.Reason = fmt.Sprintf("deviates %.1f%% from median", r.Deviation*100)
+	if f.mode == filterReject {
+		if f.rejects[v.Id]++; f.rejects[v.Id] < f.window {
+			r.Status = filterRejected
+			f.reject(r)
+			return false
+		}
+		// 窗口里的价格已经过时, 从这一次重新开始
+		delete(f.rejects, v.Id)
+		f.prices[v.Id] = nil
+		f.accept(v.Id, p)
+		r.Status = filterReleased
+		f.reject(r)
+		return true
+	}
+	held := append(f.held[v.Id], p)
+	// 只保留和最新价格一致的一段
+	for len(held) > 1 && math.Abs(held[0]/p-1) > f.deviation {
+		held = held[1:]
+	}
+	if len(held) < f.confirm {
+		f.held[v.Id] = held
+		r.Status = filterQuarantined
+		f.reject(r)
+		return false
+	}
+	// 新的价格水平已经确认, 用它替换窗口
+	delete(f.held, v.Id)
+	f.prices[v.Id] = nil
+	for _, x := range held {
+		f.accept(v.Id, x)
+	}
+	r.Status = filterReleased
+	f.reject(r)
+	return true
+}
+
+func (f *tickFilter) accept(asset string, p float64) {
+	window := append(f.prices[asset], p)
+	if len(window) > f.window {
+		window = window[len(window)-f.window:]
+	}
+	f.prices[asset] = window
+}
+
+func (f *tickFilter) reject(r *rejectedTick) {
+	tickFiltered.inc(r.Source, r.Status)
+	// 每条都计入 market_tick_filter_total, 日志只在 debug 级别输出
+	f.log.Debug("tick filtered", "asset", r.AssetID, "price_usd", r.PriceUSD, "median", r.Median, "status", r.Status, "reason", r.Reason)
+	if f.record != nil {
+		f.record(r)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func filterTick(usd float64) []priceCoinMarketCap {
+	return []priceCoinMarketCap{{
+		Id:       "bitcoin",
+		Symbol:   "BTC",
+		PriceUSD: strconv.FormatFloat(usd, 'f', -1, 64),
+		PriceBTC: "1",
+		PriceCNY: strconv.FormatFloat(usd*7, 'f', -1, 64),
+	}}
+}
+
+// runFilter 依次喂入价格, 返回通过的价格和被过滤的记录
+func runFilter(f *tickFilter, prices ...float64) ([]float64, []rejectedTick) {
+	var passed []float64
+	var rejected []rejectedTick
+	f.record = func(r *rejectedTick) { rejected = append(rejected, *r) }
+	for i, p := range prices {
+		for _, v := range f.apply(coinMarketCapSource, int64(i), filterTick(p)) {
+			x, _ := strconv.ParseFloat(v.PriceUSD, 64)
+			passed = append(passed, x)
+		}
+	}
+	return passed, rejected
+}
+
+func TestTickFilterReject(t *testing.T) {
+	f := newTickFilter(5, 0.5, filterReject, 3)
+	passed, rejected := runFilter(f, 100, 101, 99, 10000, 0, 102, 9900, 9900, 9900)
+	if want := []float64{100, 101, 99, 102}; len(passed) != len(want) || passed[3] != 102 {
+		t.Errorf("passed %v, want %v", passed, want)
+	}
+	if len(rejected) != 5 || rejected[0].Status != filterRejected || rejected[0].Median != 100 || rejected[1].Reason != "invalid price" {
+		t.Errorf("rejected %+v", rejected)
+	}
+	// 连续 5 次偏离后认为价格变了, 之后以新价格为准
+	passed, rejected = runFilter(f, 9900, 9900, 9950, 9920, 100)
+	if len(passed) != 3 || passed[0] != 9900 || passed[2] != 9920 {
+		t.Errorf("passed after reset %v", passed)
+	}
+	if len(rejected) != 3 || rejected[0].Status != filterRejected || rejected[1].Status != filterReleased || rejected[2].Median != 9920 {
+		t.Errorf("rejected after reset %+v", rejected)
+	}
+
+	// 价格缺失也要过滤, 不能让 0 进入蜡烛
+	list := filterTick(100)
+	list[0].PriceBTC = ""
+	if got := f.apply(coinMarketCapSource, 0, list); len(got) != 0 {
+		t.Errorf("empty btc price passed: %+v", got)
+	}
+	var nilFilter *tickFilter
+	if got := nilFilter.apply(coinMarketCapSource, 0, list); len(got) != 1 {
+		t.Errorf("nil filter dropped ticks")
+	}
+}
+
+func TestTickFilterQuarantine(t *testing.T) {
+	f := newTickFilter(5, 0.5, filterQuarantine, 3)
+	// 单个异常值被隔离, 之后价格恢复正常时丢掉隔离的数据
+	passed, rejected := runFilter(f, 100, 101, 99, 500, 100)
+	if len(passed) != 4 || len(rejected) != 1 || rejected[0].Status != filterQuarantined || len(f.held) != 0 {
+		t.Fatalf("passed %v, rejected %+v", passed, rejected)
+	}
+
+	// 价格真的变了, 连续 3 次在同一水平后放行, 之后以新价格为准
+	passed, rejected = runFilter(f, 300, 310, 305, 300, 100)
+	if len(passed) != 2 || passed[0] != 305 || passed[1] != 300 {
+		t.Errorf("passed %v", passed)
+	}
+	var status []string
+	for _, r := range rejected {
+		status = append(status, r.Status)
+	}
+	if got, want := strings.Join(status, " "), "quarantined quarantined released quarantined"; got != want {
+		t.Errorf("status %v, want %v", got, want)
+	}
+	if m := median(f.prices["bitcoin"]); m != 302.5 {
+		t.Errorf("median after release %v", m)
+	}
+}
+
+func TestRejectStoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.InitRejected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := &rejectedTick{Source: coinMarketCapSource, AssetID: "bitcoin", Symbol: "BTC", PriceUSD: "0", Reason: "invalid price", Status: filterRejected, Timestamp: 1}
+	if err := store.SaveRejected(ctx, r, r); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := store.db.QueryRow("SELECT count(*) FROM rejected_ticks WHERE status = 'rejected';").Scan(&n); err != nil || n != 2 {
+		t.Errorf("%d rows, %v", n, err)
+	}
+}
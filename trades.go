@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// trade 一笔成交, 价格为 USD, Amount 为资产数量
+type trade struct {
+	AssetID string  `json:"asset_id"`
+	Symbol  string  `json:"symbol"`
+	Name    string  `json:"name"`
+	Price   float64 `json:"price"`
+	Amount  float64 `json:"amount"`
+	Time    int64   `json:"time"`
+}
+
+// tradeBucket 把一段时间内的成交合成一次抓取的数据, 开高低收为成交价.
+// 成交数据只有 USD 价格, BTC 和 CNY 为 0
+type tradeBucket struct {
+	index map[string]int
+	list  []kPriceCoinMarketCap
+	// first 每个资产开盘价对应的成交时间, 成交乱序到达时开收按成交时间取
+	first []int64
+}
+
+func newTradeBucket() *tradeBucket {
+	return &tradeBucket{index: make(map[string]int)}
+}
+
+func (b *tradeBucket) add(t *trade) {
+	i, ok := b.index[t.AssetID]
+	if !ok {
+		var v kPriceCoinMarketCap
+		v.Id, v.Symbol, v.Name = t.AssetID, t.Symbol, t.Name
+		v.PriceUSDFirst.SetFloat64(t.Price)
+		v.PriceUSDLow.SetFloat64(t.Price)
+		v.PriceUSDHigh.SetFloat64(t.Price)
+		v.PriceUSDLast.SetFloat64(t.Price)
+		v.LastUpdated.SetInt64(t.Time)
+		i = len(b.list)
+		b.index[t.AssetID] = i
+		b.list = append(b.list, v)
+		b.first = append(b.first, t.Time)
+	}
+	v := &b.list[i]
+	p := new(big.Float).SetFloat64(t.Price)
+	if t.Time < b.first[i] {
+		b.first[i] = t.Time
+		v.PriceUSDFirst.Set(p)
+	}
+	if t.Time >= v.LastUpdated.Int64() {
+		v.LastUpdated.SetInt64(t.Time)
+		v.PriceUSDLast.Set(p)
+	}
+	if v.PriceUSDLow.Cmp(p) > 0 {
+		v.PriceUSDLow.Set(p)
+	}
+	if v.PriceUSDHigh.Cmp(p) < 0 {
+		v.PriceUSDHigh.Set(p)
+	}
+	v.VolumeBase += t.Amount
+	v.VolumeQuote += t.Price * t.Amount
+	v.Trades++
+}
+
+// flush 取出累积的数据, 没有成交时返回 nil
+func (b *tradeBucket) flush(ts int64) *kPriceCoinMarketCapList {
+	if len(b.list) == 0 {
+		return nil
+	}
+	x := &kPriceCoinMarketCapList{list: b.list, timestamp: ts}
+	b.list, b.index, b.first = nil, make(map[string]int), nil
+	return x
+}
+
+// tradeWindows 按成交时间把成交分到长度为 every 秒的窗口, 窗口起点作为数据的时间戳,
+// 成交不会因为到达的时间被算进下一个周期
+type tradeWindows struct {
+	every   int64
+	buckets map[int64]*tradeBucket
+}
+
+func newTradeWindows(every time.Duration) *tradeWindows {
+	return &tradeWindows{every: max(int64(every/time.Second), 1), buckets: make(map[int64]*tradeBucket)}
+}
+
+func (w *tradeWindows) add(t *trade) {
+	start := t.Time - t.Time%w.every
+	b := w.buckets[start]
+	if b == nil {
+		b = newTradeBucket()
+		w.buckets[start] = b
+	}
+	b.add(t)
+}
+
+// flush 按时间顺序取出在 now 或之前结束的窗口. 窗口取出后才到达的成交另成一次数据,
+// 按迟到的数据处理
+func (w *tradeWindows) flush(now int64) []*kPriceCoinMarketCapList {
+	var starts []int64
+	for start := range w.buckets {
+		if start+w.every <= now {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	var ret []*kPriceCoinMarketCapList
+	for _, start := range starts {
+		if x := w.buckets[start].flush(start); x != nil {
+			ret = append(ret, x)
+		}
+		delete(w.buckets, start)
+	}
+	return ret
+}
+
+func validTrade(t *trade) error {
+	switch {
+	case t.AssetID == "" || t.Symbol == "":
+		return fmt.Errorf("trade without asset_id or symbol")
+	case t.Price <= 0 || t.Amount <= 0:
+		return fmt.Errorf("trade %v: price and amount must be positive", t.AssetID)
+	case t.Time <= 0:
+		return fmt.Errorf("trade %v: missing time", t.AssetID)
+	}
+	return nil
+}
+
+// readTrades 逐行读取 JSON 格式的成交, 格式错误的行跳过
+func readTrades(r io.Reader, log *slog.Logger, fn func(*trade)) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var t trade
+		if err := json.Unmarshal(sc.Bytes(), &t); err != nil {
+			log.Warn("bad trade", "err", err)
+			continue
+		}
+		if err := validTrade(&t); err != nil {
+			log.Warn("bad trade", "err", err)
+			continue
+		}
+		fn(&t)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// tradeStream 读取一个按行输出 JSON 成交的 HTTP 长连接, 断开后按指数退避重连,
+// 每 every 把已经结束的成交时间窗口合成数据送给 out
+type tradeStream struct {
+	name       string
+	url        string
+	client     *http.Client
+	backoff    time.Duration
+	maxBackoff time.Duration
+	log        *slog.Logger
+}
+
+func newTradeStream(name, url string) *tradeStream {
+	return &tradeStream{
+		name:       name,
+		url:        url,
+		client:     &http.Client{},
+		backoff:    time.Second,
+		maxBackoff: 30 * time.Second,
+		log:        component("trades", "source", name),
+	}
+}
+
+func (s *tradeStream) connect(trades chan<- *trade) error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode}
+	}
+	s.log.Info("connected")
+	return readTrades(resp.Body, s.log, func(t *trade) { trades <- t })
+}
+
+func (s *tradeStream) run(clk clock, every time.Duration, out chan<- *kPriceCoinMarketCapList) {
+	trades := make(chan *trade, 1000)
+	go func() {
+		wait := s.backoff
+		for {
+			start := time.Now()
+			err := s.connect(trades)
+			fetchErrors.inc(s.name)
+			// 连上过一段时间再断开的从头退避
+			if time.Since(start) > s.maxBackoff {
+				wait = s.backoff
+			}
+			s.log.Warn("stream closed", "err", err, "retry_in", wait)
+			time.Sleep(wait)
+			if wait *= 2; wait > s.maxBackoff {
+				wait = s.maxBackoff
+			}
+		}
+	}()
+	w := newTradeWindows(every)
+	ticker := clk.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-trades:
+			w.add(t)
+		case t := <-ticker.C():
+			for _, x := range w.flush(t.Unix()) {
+				assetsPerTick.set(float64(len(x.list)), s.name)
+				out <- x
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTradeBucket(t *testing.T) {
+	b := newTradeBucket()
+	if b.flush(0) != nil {
+		t.Fatal("empty bucket flushed")
+	}
+	var got []*trade
+	err := readTrades(strings.NewReader(`{"asset_id":"bitcoin","symbol":"BTC","price":100,"amount":1,"time":10}
+
+not json
+{"asset_id":"bitcoin","symbol":"BTC","price":90,"amount":0,"time":11}
+{"asset_id":"bitcoin","symbol":"BTC","price":110,"amount":3,"time":12}
+{"asset_id":"ethereum","symbol":"ETH","price":5,"amount":2,"time":11}
+{"asset_id":"bitcoin","symbol":"BTC","price":105,"amount":1,"time":11}
+`), component("test"), func(t *trade) { got = append(got, t) })
+	if err != io.EOF || len(got) != 4 {
+		t.Fatalf("%d trades, %v", len(got), err)
+	}
+	for _, tr := range got {
+		b.add(tr)
+	}
+	x := b.flush(60)
+	if x == nil || len(x.list) != 2 || b.flush(120) != nil {
+		t.Fatalf("flush %+v", x)
+	}
+	r := newCandleRow(&x.list[0], x.timestamp, "trades")
+	// 105 在 12 秒的成交之后到达, 但成交时间更早, 收盘价取 110
+	if r.PriceUSDFirst != 100 || r.PriceUSDLast != 110 || r.PriceUSDLow != 100 || r.PriceUSDHigh != 110 || r.LastUpdated != 12 {
+		t.Errorf("prices %+v", r)
+	}
+	if r.VolumeBase != 5 || r.VolumeQuote != 535 || r.Trades != 3 || r.VWAP != 107 {
+		t.Errorf("volume %+v", r)
+	}
+}
+
+func TestTradeWindows(t *testing.T) {
+	w := newTradeWindows(10 * time.Second)
+	for _, tr := range []trade{
+		{AssetID: "bitcoin", Symbol: "BTC", Price: 100, Amount: 1, Time: 59},
+		{AssetID: "bitcoin", Symbol: "BTC", Price: 101, Amount: 1, Time: 60},
+		{AssetID: "bitcoin", Symbol: "BTC", Price: 99, Amount: 1, Time: 52},
+		{AssetID: "bitcoin", Symbol: "BTC", Price: 102, Amount: 1, Time: 71},
+	} {
+		w.add(&tr)
+	}
+	// 61 秒时 [60, 70) 还没有结束, 59 秒的成交仍属于 50 秒开始的窗口, 开收按成交时间
+	xs := w.flush(61)
+	if v := xs[0].list[0]; len(xs) != 1 || xs[0].timestamp != 50 || v.Trades != 2 || v.PriceUSDFirst.String() != "99" || v.PriceUSDLast.String() != "100" {
+		t.Fatalf("flush(61) %+v", xs)
+	}
+	xs = w.flush(80)
+	if len(xs) != 2 || xs[0].timestamp != 60 || xs[1].timestamp != 70 || len(w.flush(1000)) != 0 {
+		t.Fatalf("flush(80) %+v", xs)
+	}
+}
+
+func TestDispatchVolume(t *testing.T) {
+	start := time.Date(2018, 1, 3, 0, 0, 30, 0, time.UTC)
+	p := startPipeline(start)
+	var n float64
+	// 最后一条在 5:00, 属于下一个周期
+	for end := start.Add(4*time.Minute + 20*time.Second); p.clk.Now().Before(end); {
+		p.clk.Advance(10 * time.Second)
+		n++
+		x := testTick(p.clk.Now().Unix(), n)
+		x.list[0].VolumeBase, x.list[0].VolumeQuote, x.list[0].Trades = 1, n, 1
+		p.ch <- x
+	}
+	p.clk.Advance(20 * time.Second)
+
+	// 成交量逐级相加, VWAP 按成交量加权而不是取最后一个
+	mins := p.wait(t, coinmarketcapmin, 5)
+	if v := mins[1].list[0]; v.VolumeBase != 6 || v.Trades != 6 || v.vwap() != 5.5 {
+		t.Errorf("1m: %+v", v)
+	}
+	min5 := p.wait(t, coinmarketcap5min, 1)[0].list[0]
+	if min5.VolumeBase != n || min5.VolumeQuote != n*(n+1)/2 || min5.Trades != int64(n) || min5.vwap() != (n+1)/2 {
+		t.Errorf("5m after %v ticks: %+v", n, min5)
+	}
+}